@@ -0,0 +1,648 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+
+	"zabbix-technical-task/pkg/storage"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+const usage = `Usage: recordctl [flags] <command> [arguments]
+
+Inspects and repairs the records of a store. Stop the server before changing them.
+
+Commands:
+  verify [file...]          check every line of snapshot files, by default the one of the file backend
+  dump [-format json|csv]   print all records
+  get <id>                  print a record
+  put [file]                add or replace the record read from file, or from stdin
+  delete <id>               delete a record
+  compact [-force]          rewrite the store as a single snapshot, dropping its log; with
+                            -force even if some lines could not be loaded, which are lost
+  convert -to <backend>     copy the records to another backend in the same data directory
+
+Flags:
+`
+
+var (
+	errUsage       = errors.New("invalid usage")
+	errProblems    = errors.New("problems found")
+	errNotFound    = errors.New("record not found")
+	errNoFiles     = errors.New("memory backend keeps no files")
+	errSameBackend = errors.New("store already uses this backend")
+	errNotEmpty    = errors.New("destination store is not empty")
+)
+
+// tool runs the commands on the store in dataDir.
+type tool struct {
+	dataDir string
+	backend storage.Backend
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+// problem is an issue found by verify on a line of a snapshot.
+type problem struct {
+	line    int
+	message string
+}
+
+func main() {
+	// Storages log what they skip; the commands report it themselves.
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	err := run(ctx, os.Args[1:], os.LookupEnv, os.Stdin, os.Stdout, os.Stderr)
+
+	stop()
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "recordctl:", err)
+		os.Exit(1)
+	}
+}
+
+// run parses args and runs the command they name. The data directory, backend
+// and schema default to the environment variables the server reads.
+func run(ctx context.Context, args []string, lookupEnv func(string) (string, bool),
+	stdin io.Reader, stdout, stderr io.Writer,
+) error {
+	env := func(name, fallback string) string {
+		if value, ok := lookupEnv(name); ok {
+			return value
+		}
+
+		return fallback
+	}
+
+	flags := flag.NewFlagSet("recordctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	dataDir := flags.String("data-dir", env("RECORDS_DATA_DIR", "data"),
+		"directory holding the records (env RECORDS_DATA_DIR)")
+	backend := flags.String("storage", env("RECORDS_STORAGE", string(storage.BackendFile)),
+		"storage backend: file, log, dir or lsm (env RECORDS_STORAGE)")
+	schemaFile := flags.String("schema-file", env("RECORDS_SCHEMA_FILE", ""),
+		"JSON Schema to validate records against, <data-dir>/schema.json if present (env RECORDS_SCHEMA_FILE)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+
+		return errUsage
+	}
+
+	err = loadSchema(*schemaFile, *dataDir)
+	if err != nil {
+		return err
+	}
+
+	t := &tool{dataDir: *dataDir, backend: storage.Backend(*backend), stdin: stdin, stdout: stdout}
+	command, rest := flags.Arg(0), flags.Args()[1:]
+
+	switch command {
+	case "verify":
+		return t.verify(ctx, rest)
+	case "dump":
+		return t.dump(ctx, rest, stderr)
+	case "get":
+		return t.get(ctx, rest)
+	case "put":
+		return t.put(ctx, rest)
+	case "delete":
+		return t.delete(ctx, rest)
+	case "compact":
+		return t.compact(ctx, rest, stderr)
+	case "convert":
+		return t.convert(ctx, rest, stderr)
+	default:
+		return fmt.Errorf("command %q: %w", command, errUsage)
+	}
+}
+
+// loadSchema sets the schema records are validated against, as the server does.
+func loadSchema(name, dataDir string) error {
+	required := name != ""
+	if !required {
+		name = filepath.Join(dataDir, "schema.json")
+	}
+
+	schema, err := userrecord.LoadSchema(name)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !required:
+		return nil
+	case err != nil:
+		return fmt.Errorf("loading schema: %w", err)
+	default:
+		userrecord.SetSchema(schema)
+
+		return nil
+	}
+}
+
+// verify checks every line of the snapshot files: corrupt lines, records that
+// fail Validate and ids that appear more than once are reported.
+func (t *tool) verify(ctx context.Context, files []string) error {
+	if len(files) == 0 {
+		if t.backend != storage.BackendFile {
+			return fmt.Errorf("verify: name the snapshot files of the %s backend: %w", t.backend, errUsage)
+		}
+
+		files = []string{storage.SnapshotFile(t.dataDir)}
+	}
+
+	var total int
+
+	for _, name := range files {
+		problems, err := t.verifyFile(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		total += problems
+	}
+
+	if total > 0 {
+		return fmt.Errorf("%d problems: %w", total, errProblems)
+	}
+
+	return nil
+}
+
+// verifyFile reports the problems of one snapshot file and returns their number.
+func (t *tool) verifyFile(ctx context.Context, name string) (int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, fmt.Errorf("opening snapshot: %w", err)
+	}
+
+	defer func() {
+		closeErr := file.Close()
+		if closeErr != nil {
+			slog.ErrorContext(ctx, "failed to close file", "file", name, "err", closeErr)
+		}
+	}()
+
+	var (
+		problems []problem
+		records  int
+		seen     = make(map[uint64]int)
+	)
+
+	corrupted, err := storage.ScanSnapshot(ctx, file, func(line int, rec userrecord.Record) {
+		records++
+
+		err := rec.Validate()
+		if err != nil {
+			problems = append(problems, problem{line, err.Error()})
+		}
+
+		id, err := rec.ID()
+		if err != nil {
+			return
+		}
+
+		if first, ok := seen[id]; ok {
+			problems = append(problems, problem{line, fmt.Sprintf("duplicate id %d, first on line %d", id, first)})
+
+			return
+		}
+
+		seen[id] = line
+	})
+	if err != nil {
+		return 0, fmt.Errorf("verifying %q: %w", name, err)
+	}
+
+	for _, entry := range corrupted {
+		problems = append(problems, problem{entry.Line, entry.Reason})
+	}
+
+	slices.SortStableFunc(problems, func(a, b problem) int {
+		return a.line - b.line
+	})
+
+	for _, p := range problems {
+		fmt.Fprintf(t.stdout, "%s:%d: %s\n", name, p.line, p.message)
+	}
+
+	fmt.Fprintf(t.stdout, "%s: %d records, %d problems\n", name, records, len(problems))
+
+	return len(problems), nil
+}
+
+// dump prints all records in id order as an indented JSON array or as CSV
+// with a column for every field.
+func (t *tool) dump(ctx context.Context, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	format := flags.String("format", "json", "output format: json or csv")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	store, records, err := t.open(ctx, t.backend, false)
+	if err != nil {
+		return err
+	}
+
+	defer closeStore(ctx, store)
+
+	ids := slices.Sorted(maps.Keys(records))
+
+	switch *format {
+	case "json":
+		list := make([]userrecord.Record, 0, len(ids))
+		for _, id := range ids {
+			list = append(list, records[id])
+		}
+
+		return t.printJSON(list)
+	case "csv":
+		return t.printCSV(ids, records)
+	default:
+		return fmt.Errorf("format %q: %w", *format, errUsage)
+	}
+}
+
+// get prints the record with the id given in args.
+func (t *tool) get(ctx context.Context, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	store, records, err := t.open(ctx, t.backend, false)
+	if err != nil {
+		return err
+	}
+
+	defer closeStore(ctx, store)
+
+	rec, ok := records[id]
+	if !ok {
+		return fmt.Errorf("record with id %d: %w", id, errNotFound)
+	}
+
+	return t.printJSON(rec)
+}
+
+// put adds the record read from the file named in args, or from stdin, or
+// replaces the record with its id. A record without an id gets the next one.
+func (t *tool) put(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("put takes at most one file: %w", errUsage)
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+
+	if len(args) == 0 || args[0] == "-" {
+		data, err = io.ReadAll(t.stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+
+	if err != nil {
+		return fmt.Errorf("reading record: %w", err)
+	}
+
+	var rec userrecord.Record
+
+	err = json.Unmarshal(data, &rec)
+	if err != nil {
+		return fmt.Errorf("decoding record: %w", err)
+	}
+
+	store, records, err := t.open(ctx, t.backend, true)
+	if err != nil {
+		return err
+	}
+
+	defer closeStore(ctx, store)
+
+	if _, ok := rec["id"]; !ok {
+		rec["id"] = store.Sequence() + 1
+	}
+
+	err = rec.Validate()
+	if err != nil {
+		return fmt.Errorf("validating record: %w", err)
+	}
+
+	id, err := rec.ID()
+	if err != nil {
+		return fmt.Errorf("validating record: %w", err)
+	}
+
+	op, verb := storage.OpAdd, "added"
+	if _, ok := records[id]; ok {
+		op, verb = storage.OpUpdate, "updated"
+	}
+
+	err = store.Append(ctx, storage.Entry{Op: op, ID: id, Record: rec})
+	if err != nil {
+		return fmt.Errorf("writing record: %w", err)
+	}
+
+	fmt.Fprintf(t.stdout, "%s record %d\n", verb, id)
+
+	return nil
+}
+
+// delete removes the record with the id given in args.
+func (t *tool) delete(ctx context.Context, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	store, records, err := t.open(ctx, t.backend, false)
+	if err != nil {
+		return err
+	}
+
+	defer closeStore(ctx, store)
+
+	if _, ok := records[id]; !ok {
+		return fmt.Errorf("record with id %d: %w", id, errNotFound)
+	}
+
+	err = store.Append(ctx, storage.Entry{Op: storage.OpDelete, ID: id})
+	if err != nil {
+		return fmt.Errorf("deleting record: %w", err)
+	}
+
+	fmt.Fprintf(t.stdout, "deleted record %d\n", id)
+
+	return nil
+}
+
+// compact saves the loaded records, which folds the log of the store into a
+// single snapshot, segment or table. Lines that could not be loaded are listed
+// as by verify, and compact refuses to drop them unless forced.
+func (t *tool) compact(ctx context.Context, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("compact", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	force := flags.Bool("force", false, "compact even if lines could not be loaded, dropping them")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("compact takes no arguments: %w", errUsage)
+	}
+
+	store, records, err := t.open(ctx, t.backend, false)
+	if err != nil {
+		return err
+	}
+
+	defer closeStore(ctx, store)
+
+	corrupted := store.Report().Corrupted
+	for _, entry := range corrupted {
+		fmt.Fprintf(t.stdout, "%s:%d: %s\n", entry.File, entry.Line, entry.Reason)
+	}
+
+	if len(corrupted) > 0 && !*force {
+		return fmt.Errorf("%d lines could not be loaded, compact with -force to drop them: %w",
+			len(corrupted), errProblems)
+	}
+
+	err = store.Save(ctx, records)
+	if err != nil {
+		return fmt.Errorf("compacting: %w", err)
+	}
+
+	fmt.Fprintf(t.stdout, "compacted %d records, dropped %d lines\n", len(records), len(corrupted))
+
+	return nil
+}
+
+// convert copies the records and the sequence of the store to an empty store
+// of another backend in the same data directory.
+func (t *tool) convert(ctx context.Context, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	to := flags.String("to", "", "backend to convert to: file, log, dir or lsm")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	target := storage.Backend(*to)
+	if target == t.backend {
+		return fmt.Errorf("backend %q: %w", target, errSameBackend)
+	}
+
+	src, records, err := t.open(ctx, t.backend, false)
+	if err != nil {
+		return err
+	}
+
+	defer closeStore(ctx, src)
+
+	dst, existing, err := t.open(ctx, target, true)
+	if err != nil {
+		return err
+	}
+
+	defer closeStore(ctx, dst)
+
+	if len(existing) > 0 {
+		return fmt.Errorf("%s store holds %d records: %w", target, len(existing), errNotEmpty)
+	}
+
+	err = storage.Convert(ctx, dst, records, src.Sequence())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(t.stdout, "converted %d records from %s to %s\n", len(records), t.backend, target)
+
+	return nil
+}
+
+// open initializes the store of backend in the data directory and returns it
+// with its records. If create is set, a missing store is created.
+func (t *tool) open(
+	ctx context.Context, backend storage.Backend, create bool,
+) (storage.Store, map[uint64]userrecord.Record, error) {
+	if backend == storage.BackendMemory {
+		return nil, nil, errNoFiles
+	}
+
+	store, err := storage.New(backend, t.dataDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening store: %w", err)
+	}
+
+	records := make(map[uint64]userrecord.Record)
+
+	err = store.Init(ctx, records)
+	if errors.Is(err, storage.ErrNoSnapshot) && create {
+		err = createStore(backend, t.dataDir)
+		if err == nil {
+			err = store.Init(ctx, records)
+		}
+	}
+
+	if err != nil {
+		closeStore(ctx, store)
+
+		return nil, nil, fmt.Errorf("loading %s store in %q: %w", backend, t.dataDir, err)
+	}
+
+	return store, records, nil
+}
+
+// createStore prepares an empty store of backend in dir, creating dir if needed.
+func createStore(backend storage.Backend, dir string) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating %q: %w", dir, err)
+	}
+
+	err = storage.Create(backend, dir)
+	if err != nil {
+		return fmt.Errorf("creating store: %w", err)
+	}
+
+	return nil
+}
+
+// closeStore closes store, logging a failure: the changes are already synced.
+func closeStore(ctx context.Context, store storage.Store) {
+	err := store.Close()
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to close store", "err", err)
+	}
+}
+
+// printJSON writes v as indented JSON.
+func (t *tool) printJSON(v any) error {
+	encoder := json.NewEncoder(t.stdout)
+	encoder.SetIndent("", "  ")
+
+	err := encoder.Encode(v)
+	if err != nil {
+		return fmt.Errorf("writing JSON: %w", err)
+	}
+
+	return nil
+}
+
+// printCSV writes the records with ids as CSV: the id column comes first and
+// the other fields follow by name. Strings are written as they are and other
+// values as JSON; missing fields are empty.
+func (t *tool) printCSV(ids []uint64, records map[uint64]userrecord.Record) error {
+	fields := make(map[string]bool)
+
+	for _, rec := range records {
+		for field := range rec {
+			fields[field] = field != "id"
+		}
+	}
+
+	columns := slices.Sorted(maps.Keys(fields))
+	columns = slices.DeleteFunc(columns, func(field string) bool { return !fields[field] })
+
+	w := csv.NewWriter(t.stdout)
+
+	err := w.Write(append([]string{"id"}, columns...))
+	if err != nil {
+		return fmt.Errorf("writing CSV: %w", err)
+	}
+
+	for _, id := range ids {
+		row := []string{strconv.FormatUint(id, 10)}
+
+		for _, field := range columns {
+			row = append(row, csvValue(records[id][field]))
+		}
+
+		err = w.Write(row)
+		if err != nil {
+			return fmt.Errorf("writing CSV: %w", err)
+		}
+	}
+
+	w.Flush()
+
+	err = w.Error()
+	if err != nil {
+		return fmt.Errorf("writing CSV: %w", err)
+	}
+
+	return nil
+}
+
+// csvValue formats a field for a CSV cell.
+func csvValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+
+		return string(data)
+	}
+}
+
+// parseID returns the single record id in args.
+func parseID(args []string) (uint64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expected one record id: %w", errUsage)
+	}
+
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("record id %q: %w", args[0], errUsage)
+	}
+
+	return id, nil
+}
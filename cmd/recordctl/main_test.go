@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// recordctl runs the tool with args on the data directory dir and returns what it printed.
+func recordctl(t *testing.T, dir, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	var stdout bytes.Buffer
+
+	args = append([]string{"-data-dir", dir}, args...)
+	noEnv := func(string) (string, bool) { return "", false }
+
+	err := run(t.Context(), args, noEnv, strings.NewReader(stdin), &stdout, io.Discard)
+
+	return stdout.String(), err
+}
+
+// seed puts records into a new data directory and returns it.
+func seed(t *testing.T, records ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for _, record := range records {
+		_, err := recordctl(t, dir, record, "put")
+		if err != nil {
+			t.Fatalf("seeding %s: %v", record, err)
+		}
+	}
+
+	return dir
+}
+
+// expectOutput fails the test unless output is expected.
+func expectOutput(t *testing.T, output, expected string) {
+	t.Helper()
+
+	if output != expected {
+		t.Errorf("expected output %q, got %q", expected, output)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown command", []string{"repair"}},
+		{"get without id", []string{"get"}},
+		{"get bad id", []string{"get", "x"}},
+		{"compact with arguments", []string{"compact", "now"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := recordctl(t, t.TempDir(), "", tt.args...)
+			if !errors.Is(err, errUsage) {
+				t.Errorf("expected errUsage, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPut(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	output, err := recordctl(t, dir, `{"name":"a"}`, "put")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "added record 1\n")
+
+	output, err = recordctl(t, dir, `{"id":5,"name":"b"}`, "put", "-")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "added record 5\n")
+
+	output, err = recordctl(t, dir, `{"id":1,"name":"A"}`, "put")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "updated record 1\n")
+
+	// A record without an id gets the one after the highest issued.
+	output, err = recordctl(t, dir, `{"name":"c"}`, "put")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "added record 6\n")
+}
+
+func TestPutInvalidID(t *testing.T) {
+	t.Parallel()
+
+	_, err := recordctl(t, t.TempDir(), `{"id":-1}`, "put")
+	if !errors.Is(err, userrecord.ErrInvalidID) {
+		t.Errorf("expected userrecord.ErrInvalidID, got %v", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	dir := seed(t, `{"name":"a"}`)
+
+	output, err := recordctl(t, dir, "", "get", "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "{\n  \"id\": 1,\n  \"name\": \"a\"\n}\n")
+
+	_, err = recordctl(t, dir, "", "get", "2")
+	if !errors.Is(err, errNotFound) {
+		t.Errorf("expected errNotFound, got %v", err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	t.Parallel()
+
+	dir := seed(t, `{"name":"a"}`, `{"id":5,"name":"b","tags":["x"]}`)
+
+	output, err := recordctl(t, dir, "", "dump", "-format", "csv")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "id,name,tags\n1,a,\n5,b,\"[\"\"x\"\"]\"\n")
+
+	_, err = recordctl(t, dir, "", "dump", "-format", "xml")
+	if !errors.Is(err, errUsage) {
+		t.Errorf("expected errUsage, got %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	dir := seed(t, `{"name":"a"}`)
+
+	output, err := recordctl(t, dir, "", "delete", "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "deleted record 1\n")
+
+	_, err = recordctl(t, dir, "", "delete", "1")
+	if !errors.Is(err, errNotFound) {
+		t.Errorf("expected errNotFound, got %v", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+
+	dir := seed(t, `{"name":"a"}`, `{"id":1,"name":"A"}`)
+
+	output, err := recordctl(t, dir, "", "compact")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "compacted 1 records, dropped 0 lines\n")
+
+	// The record is in the snapshot now, which verify reads.
+	output, err = recordctl(t, dir, "", "verify")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, filepath.Join(dir, "data.txt")+": 1 records, 0 problems\n")
+}
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+
+	dir := seed(t, `{"name":"a"}`, `{"id":5,"name":"b"}`)
+
+	_, err := recordctl(t, dir, "", "delete", "5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err := recordctl(t, dir, "", "convert", "-to", "lsm")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "converted 1 records from file to lsm\n")
+
+	// The lsm store carries on the sequence of the file store, where 5 was issued.
+	output, err = recordctl(t, dir, `{"name":"c"}`, "-storage", "lsm", "put")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "added record 6\n")
+
+	_, err = recordctl(t, dir, "", "convert", "-to", "lsm")
+	if !errors.Is(err, errNotEmpty) {
+		t.Errorf("expected errNotEmpty, got %v", err)
+	}
+}
+
+func TestConvertRefused(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		to   string
+		err  error
+	}{
+		{"same backend", "file", errSameBackend},
+		{"memory", "memory", errNoFiles},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := recordctl(t, seed(t, `{"name":"a"}`), "", "convert", "-to", tt.to)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "data.txt")
+
+	err := os.WriteFile(name, []byte(`{"id":1}`+"\n"+`{"id":1}`+"\n"+`{"name":"x"}`+"\n"+"bad\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err := recordctl(t, dir, "", "verify", name)
+	if !errors.Is(err, errProblems) {
+		t.Fatalf("expected errProblems, got %v", err)
+	}
+
+	expectOutput(t, output, name+":2: duplicate id 1, first on line 1\n"+
+		name+":3: missing id\n"+
+		name+":4: undecodable record\n"+
+		name+": 3 records, 3 problems\n")
+}
+
+func TestCompactCorrupt(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "data.txt")
+
+	err := os.WriteFile(name, []byte(`{"id":1,"name":"a"}`+"\n"+"bad\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err := recordctl(t, dir, "", "compact")
+	if !errors.Is(err, errProblems) {
+		t.Fatalf("expected errProblems, got %v", err)
+	}
+
+	report := name + ":2: undecodable record\n"
+	expectOutput(t, output, report)
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(string(data), "bad") {
+		t.Fatalf("expected the corrupt line to be kept, got %q", data)
+	}
+
+	output, err = recordctl(t, dir, "", "compact", "-force")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, report+"compacted 1 records, dropped 1 lines\n")
+}
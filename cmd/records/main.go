@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/client"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+const usage = `Usage: records [flags] <command> [arguments]
+
+Calls the records API of a running server.
+
+Commands:
+  create [file...]                        create the records read from files, or from stdin
+  get <id...>                             print records
+  update [-if-match version] [file...]    replace the records with the ids of those read
+  delete [-if-match version] <id...>      delete records
+  list [-limit n] [-cursor c] [-sort id|-id] [-all] [field=value...]
+                                          print a page of records, or all of them, one per line
+  watch [-interval d] <id>                print the record whenever it changes, null once deleted
+
+Records are read as JSON objects, one after another, or as JSON arrays of them.
+
+Flags:
+`
+
+var (
+	errUsage  = errors.New("invalid usage")
+	errFilter = errors.New("filters must be field=value")
+)
+
+// cli runs the commands with a client of the server.
+type cli struct {
+	client *client.Client
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	err := run(ctx, os.Args[1:], os.LookupEnv, os.Stdin, os.Stdout, os.Stderr)
+
+	stop()
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "records:", err)
+		os.Exit(1)
+	}
+}
+
+// run parses args and runs the command they name against the server.
+func run(ctx context.Context, args []string, lookupEnv func(string) (string, bool),
+	stdin io.Reader, stdout, stderr io.Writer,
+) error {
+	addrDefault, ok := lookupEnv("RECORDS_URL")
+	if !ok {
+		addrDefault = "http://localhost:8080"
+	}
+
+	flags := flag.NewFlagSet("records", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	addr := flags.String("addr", addrDefault, "URL of the server (env RECORDS_URL)")
+	collection := flags.String("collection", "", "collection to work on instead of the default one")
+	retries := flags.Int("retries", 3, "times to repeat a request the server could not serve")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of every request")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+
+		return errUsage
+	}
+
+	opts := []client.Option{
+		client.WithRetries(*retries),
+		client.WithHTTPClient(&http.Client{Timeout: *timeout}),
+	}
+	if *collection != "" {
+		opts = append(opts, client.WithCollection(*collection))
+	}
+
+	c, err := client.New(*addr, opts...)
+	if err != nil {
+		return err
+	}
+
+	app := &cli{client: c, stdin: stdin, stdout: stdout, stderr: stderr}
+	command, rest := flags.Arg(0), flags.Args()[1:]
+
+	switch command {
+	case "create":
+		return app.create(ctx, rest)
+	case "get":
+		return app.get(ctx, rest)
+	case "update":
+		return app.update(ctx, rest)
+	case "delete":
+		return app.delete(ctx, rest)
+	case "list":
+		return app.list(ctx, rest)
+	case "watch":
+		return app.watch(ctx, rest)
+	default:
+		return fmt.Errorf("command %q: %w", command, errUsage)
+	}
+}
+
+// create creates the records read from files, each under its own id or the next free one.
+func (a *cli) create(ctx context.Context, files []string) error {
+	records, err := a.readRecords(files)
+	if err != nil {
+		return err
+	}
+
+	for i, record := range records {
+		id, err := a.client.Create(ctx, record)
+		if err != nil {
+			return fmt.Errorf("creating record %d of %d: %w", i+1, len(records), err)
+		}
+
+		fmt.Fprintf(a.stdout, "created record %d\n", id)
+	}
+
+	return nil
+}
+
+// get prints the records with the ids in args.
+func (a *cli) get(ctx context.Context, args []string) error {
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		record, err := a.client.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("getting record %d: %w", id, err)
+		}
+
+		err = a.printJSON(record, "  ")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// update replaces the records with the ids of the records read from files.
+func (a *cli) update(ctx context.Context, args []string) error {
+	flags := a.flagSet("update")
+	versions := flags.String("if-match", "", "update only if the record has this version")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	records, err := a.readRecords(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	for i, record := range records {
+		err = record.ValidateID()
+		if err != nil {
+			return fmt.Errorf("record %d of %d: %w", i+1, len(records), err)
+		}
+
+		id, _ := record.ID()
+
+		err = a.client.Update(ctx, id, record, splitVersions(*versions))
+		if err != nil {
+			return fmt.Errorf("updating record %d: %w", id, err)
+		}
+
+		fmt.Fprintf(a.stdout, "updated record %d\n", id)
+	}
+
+	return nil
+}
+
+// delete deletes the records with the ids in args.
+func (a *cli) delete(ctx context.Context, args []string) error {
+	flags := a.flagSet("delete")
+	versions := flags.String("if-match", "", "delete only if the record has this version")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	ids, err := parseIDs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = a.client.Delete(ctx, id, splitVersions(*versions))
+		if err != nil {
+			return fmt.Errorf("deleting record %d: %w", id, err)
+		}
+
+		fmt.Fprintf(a.stdout, "deleted record %d\n", id)
+	}
+
+	return nil
+}
+
+// list prints a page of records, or every page with -all, one record per line.
+// Without -all the cursor of the next page is written to stderr.
+func (a *cli) list(ctx context.Context, args []string) error {
+	flags := a.flagSet("list")
+	limit := flags.Int("limit", 0, "records per page, the server's default if 0")
+	cursor := flags.String("cursor", "", "cursor of the page to print")
+	sort := flags.String("sort", "id", "order of the records: id or -id")
+	all := flags.Bool("all", false, "print every page")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	if *sort != "id" && *sort != "-id" {
+		return fmt.Errorf("sort %q: %w", *sort, errUsage)
+	}
+
+	opts := cache.ListOptions{Limit: *limit, Cursor: *cursor, Descending: *sort == "-id"}
+
+	for _, arg := range flags.Args() {
+		field, value, ok := strings.Cut(arg, "=")
+		if !ok || field == "" {
+			return fmt.Errorf("%q: %w", arg, errFilter)
+		}
+
+		opts.Filters = append(opts.Filters, cache.Filter{Field: field, Value: value})
+	}
+
+	for {
+		page, err := a.client.List(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("listing records: %w", err)
+		}
+
+		for _, record := range page.Records {
+			err = a.printJSON(record, "")
+			if err != nil {
+				return err
+			}
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+
+		if !*all {
+			fmt.Fprintf(a.stderr, "next cursor: %s\n", page.NextCursor)
+
+			return nil
+		}
+
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// watch prints the record with the id in args whenever it changes, until interrupted.
+func (a *cli) watch(ctx context.Context, args []string) error {
+	flags := a.flagSet("watch")
+	interval := flags.Duration("interval", 2*time.Second, "how often to ask the server for changes")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	ids, err := parseIDs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	if len(ids) != 1 || *interval <= 0 {
+		return fmt.Errorf("watch takes one id and a positive interval: %w", errUsage)
+	}
+
+	err = a.client.Watch(ctx, ids[0], *interval, func(record userrecord.Record) error {
+		return a.printJSON(record, "")
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	return err
+}
+
+// readRecords reads the records in files, or in stdin if there are none or for "-".
+func (a *cli) readRecords(files []string) ([]userrecord.Record, error) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	var records []userrecord.Record
+
+	for _, name := range files {
+		var (
+			data []byte
+			err  error
+		)
+
+		if name == "-" {
+			data, err = io.ReadAll(a.stdin)
+		} else {
+			data, err = os.ReadFile(name)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("reading records: %w", err)
+		}
+
+		decoded, err := decodeRecords(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading records from %s: %w", name, err)
+		}
+
+		records = append(records, decoded...)
+	}
+
+	return records, nil
+}
+
+// decodeRecords decodes a sequence of JSON objects and arrays of them.
+func decodeRecords(data []byte) ([]userrecord.Record, error) {
+	var records []userrecord.Record
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var raw json.RawMessage
+
+		err := decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("decoding JSON: %w", err)
+		}
+
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			var list []userrecord.Record
+
+			err = json.Unmarshal(raw, &list)
+			if err != nil {
+				return nil, fmt.Errorf("decoding records: %w", err)
+			}
+
+			records = append(records, list...)
+
+			continue
+		}
+
+		var record userrecord.Record
+
+		err = json.Unmarshal(raw, &record)
+		if err != nil {
+			return nil, fmt.Errorf("decoding record: %w", err)
+		}
+
+		records = append(records, record)
+	}
+}
+
+// flagSet returns the flag set of a command.
+func (a *cli) flagSet(command string) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(a.stderr)
+
+	return flags
+}
+
+// printJSON writes v as a line of JSON, or indented by indent if it is not empty.
+func (a *cli) printJSON(v any, indent string) error {
+	encoder := json.NewEncoder(a.stdout)
+	encoder.SetIndent("", indent)
+
+	err := encoder.Encode(v)
+	if err != nil {
+		return fmt.Errorf("writing JSON: %w", err)
+	}
+
+	return nil
+}
+
+// parseIDs parses the record ids in args, of which there must be at least one.
+func parseIDs(args []string) ([]uint64, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("expected a record id: %w", errUsage)
+	}
+
+	ids := make([]uint64, 0, len(args))
+
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("record id %q: %w", arg, errUsage)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// splitVersions returns the comma-separated versions of an -if-match flag.
+func splitVersions(versions string) []string {
+	if versions == "" {
+		return nil
+	}
+
+	return strings.Split(versions, ",")
+}
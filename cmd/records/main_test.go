@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"zabbix-technical-task/internal/health"
+	"zabbix-technical-task/internal/router"
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/collection"
+	"zabbix-technical-task/pkg/metrics"
+	"zabbix-technical-task/pkg/storage"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// server serves an empty in-memory cache and returns the environment that points the tool at it.
+func server(t *testing.T) func(string) (string, bool) {
+	t.Helper()
+
+	records := cache.New(t.Context(), storage.NewMemoryStorage())
+	collections := collection.NewFileRegistry(t.TempDir(), collection.WithDefault(records),
+		collection.WithBackend(storage.BackendMemory))
+
+	srv := httptest.NewServer(router.New(records, collections, metrics.NewRegistry(), health.NewChecker()).Handler)
+	t.Cleanup(srv.Close)
+
+	return func(name string) (string, bool) {
+		return srv.URL, name == "RECORDS_URL"
+	}
+}
+
+// records runs the tool with args against the server env points at and returns what it printed.
+func records(t *testing.T, env func(string) (string, bool), stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	var stdout bytes.Buffer
+
+	err := run(t.Context(), args, env, strings.NewReader(stdin), &stdout, io.Discard)
+
+	return stdout.String(), err
+}
+
+// seeded returns the environment of a server holding the records 1 "a", 7 "b" and 8 "c".
+func seeded(t *testing.T) func(string) (string, bool) {
+	t.Helper()
+
+	env := server(t)
+
+	_, err := records(t, env, `{"name":"a"} {"id":7,"name":"b"} {"name":"c"}`, "create")
+	if err != nil {
+		t.Fatalf("seeding: %v", err)
+	}
+
+	return env
+}
+
+// expectOutput fails the test unless output is expected.
+func expectOutput(t *testing.T, output, expected string) {
+	t.Helper()
+
+	if output != expected {
+		t.Errorf("expected output %q, got %q", expected, output)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+
+	env := server(t)
+
+	tests := []struct {
+		name string
+		args []string
+		err  error
+	}{
+		{"unknown command", []string{"patch"}, errUsage},
+		{"get bad id", []string{"get", "x"}, errUsage},
+		{"watch without id", []string{"watch"}, errUsage},
+		{"list bad sort", []string{"list", "-sort", "name"}, errUsage},
+		{"list bad filter", []string{"list", "name"}, errFilter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := records(t, env, "", tt.args...)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+
+	env := server(t)
+
+	output, err := records(t, env, `{"name":"a"} [{"id":7,"name":"b"},{"name":"c"}]`, "create")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "created record 1\ncreated record 7\ncreated record 8\n")
+
+	_, err = records(t, env, `{"id":7}`, "create", "-")
+	if !errors.Is(err, cache.ErrRecordExists) {
+		t.Errorf("expected cache.ErrRecordExists, got %v", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	env := seeded(t)
+
+	output, err := records(t, env, "", "get", "1", "7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "{\n  \"id\": 1,\n  \"name\": \"a\"\n}\n{\n  \"id\": 7,\n  \"name\": \"b\"\n}\n")
+
+	_, err = records(t, env, "", "get", "2")
+	if !errors.Is(err, cache.ErrRecordNotFound) {
+		t.Errorf("expected cache.ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	env := seeded(t)
+
+	output, err := records(t, env, `{"id":7,"name":"B"}`, "update")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "updated record 7\n")
+
+	_, err = records(t, env, `{"name":"x"}`, "update")
+	if !errors.Is(err, userrecord.ErrNoID) {
+		t.Errorf("expected userrecord.ErrNoID, got %v", err)
+	}
+
+	_, err = records(t, env, `{"id":7}`, "update", "-if-match", "stale")
+	if !errors.Is(err, cache.ErrVersionMismatch) {
+		t.Errorf("expected cache.ErrVersionMismatch, got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	env := seeded(t)
+
+	tests := []struct {
+		name   string
+		args   []string
+		output string
+	}{
+		{"page", []string{"list", "-limit", "2"}, `{"id":1,"name":"a"}` + "\n" + `{"id":7,"name":"b"}` + "\n"},
+		{"all", []string{"list", "-all", "-limit", "1", "-sort", "-id"},
+			`{"id":8,"name":"c"}` + "\n" + `{"id":7,"name":"b"}` + "\n" + `{"id":1,"name":"a"}` + "\n"},
+		{"filtered", []string{"list", "name=c"}, `{"id":8,"name":"c"}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			output, err := records(t, env, "", tt.args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectOutput(t, output, tt.output)
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	env := seeded(t)
+
+	output, err := records(t, env, "", "delete", "1", "8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, output, "deleted record 1\ndeleted record 8\n")
+
+	_, err = records(t, env, "", "delete", "1")
+	if !errors.Is(err, cache.ErrRecordNotFound) {
+		t.Errorf("expected cache.ErrRecordNotFound, got %v", err)
+	}
+}
@@ -2,62 +2,180 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"zabbix-technical-task/internal/config"
+	"zabbix-technical-task/internal/health"
 	"zabbix-technical-task/internal/router"
 	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/collection"
+	"zabbix-technical-task/pkg/logging"
+	"zabbix-technical-task/pkg/metrics"
 	"zabbix-technical-task/pkg/storage"
+	"zabbix-technical-task/pkg/userrecord"
 )
 
 func main() {
-	port := "8080"
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 
-	fileStorage := storage.NewFileStorage("data/data.txt")
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
 
-	records := cache.New(fileStorage)
-	if records == nil {
-		log.Fatal("failed to create record cache")
+	level, _ := cfg.Level()
+	slog.SetDefault(slog.New(logging.NewHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))))
 
-		return
+	// The default schema file is optional: without it records are not checked.
+	schemaFile, required := cfg.Schema()
+	schema, err := userrecord.LoadSchema(schemaFile)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !required:
+	case err != nil:
+		fatal("failed to load schema", err)
+	default:
+		userrecord.SetSchema(schema)
+		slog.Info("validating records against schema", "file", schemaFile)
 	}
 
-	routes := router.New(records)
+	reg := metrics.NewRegistry()
+	checker := health.NewChecker()
+	checker.Add("data_dir", health.Writable(cfg.DataDir))
+
+	// Serve the health probes while the records are loading.
+	handler := router.NewSwitch(router.Starting(reg, checker).Handler)
 
 	srv := &http.Server{
-		Addr:              "" + ":" + port,
-		Handler:           routes.Mux,
-		ReadHeaderTimeout: 5 * time.Second,
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
 	}
 
 	go func() {
-		log.Println("Listening on :" + port)
+		slog.Info("listening", "addr", cfg.Addr)
 
 		err := srv.ListenAndServe()
 		if err != nil {
-			log.Printf("Stopped listening: %v\n", err)
+			slog.Info("stopped listening", "err", err)
 		}
 	}()
 
+	storageOpts := append(cfg.StorageOptions(), storage.WithMetrics(reg))
+
+	store, err := storage.New(cfg.Backend(), cfg.DataDir, storageOpts...)
+	if err != nil {
+		fatal("failed to create storage", err)
+	}
+
+	slog.Info("storing records", "backend", cfg.Backend(), "dir", cfg.DataDir)
+
+	records := cache.New(context.Background(), store, append(cfg.CacheOptions(), cache.WithMetrics(reg, collection.DefaultName))...)
+	if records == nil {
+		fatal("failed to create record cache", nil)
+	}
+
+	report := store.Report()
+	for _, invalid := range report.Invalid {
+		slog.Warn("record does not match the schema",
+			"id", invalid.ID, "err", &userrecord.SchemaError{Violations: invalid.Violations})
+	}
+
+	if len(report.Invalid) > 0 {
+		slog.Warn("loaded records do not match the schema", "invalid", len(report.Invalid), "loaded", report.Loaded)
+	}
+
+	for _, corrupt := range report.Corrupted {
+		slog.Warn("snapshot entry is corrupt", "file", corrupt.File, "line", corrupt.Line, "reason", corrupt.Reason)
+	}
+
+	collections := collection.NewFileRegistry(cfg.CollectionsDir(),
+		collection.WithDefault(records),
+		collection.WithBackend(cfg.Backend()),
+		collection.WithCacheOptions(cfg.CacheOptions()...),
+		collection.WithStorageOptions(storageOpts...),
+		collection.WithMetrics(reg),
+	)
+
+	err = collections.Open(context.Background())
+	if err != nil {
+		fatal("failed to open collections", err)
+	}
+
+	checker.Add("flush", func() error {
+		return errors.Join(records.FlushErr(), collections.FlushErr())
+	})
+
+	routes := router.New(records, collections, reg, checker,
+		router.WithMaxBodyBytes(cfg.MaxBodyBytes),
+		router.WithReadTimeout(time.Duration(cfg.ReadTimeout)),
+	)
+
+	handler.Set(routes.Handler)
+	checker.MarkLoaded()
+
 	shutdown, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
 	<-shutdown.Done()
 
-	log.Println("Shutting down server...")
+	// Fail readiness first, so load balancers stop sending requests before the listener closes.
+	checker.Shutdown()
+
+	if cfg.DrainDelay > 0 {
+		slog.Info("draining", "delay", time.Duration(cfg.DrainDelay))
+		time.Sleep(time.Duration(cfg.DrainDelay))
+	}
+
+	slog.Info("shutting down server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
 
-	err := srv.Shutdown(context.Background())
+	err = srv.Shutdown(ctx)
 	if err != nil {
-		log.Printf("Shutdown with error: %v\n", err)
+		slog.Error("failed to shut down server", "err", err)
 	}
 
-	err = records.SaveRecords()
+	// The final flushes get what is left of the shutdown timeout. Changes they
+	// cannot save in time are still in the write-ahead log and replayed on startup.
+	err = collections.Close(ctx)
+	if err != nil {
+		slog.Error("failed to close collections", "err", err)
+	}
+
+	err = records.Close(ctx)
+	if err != nil {
+		slog.Error("failed to save records", "err", err)
+	}
+
+	err = store.Close()
+	if err != nil {
+		slog.Error("failed to close storage", "err", err)
+	}
+
+	slog.Info("shutdown complete")
+}
+
+// fatal logs msg with err, if any, and exits.
+func fatal(msg string, err error) {
 	if err != nil {
-		log.Printf("Shutdown whit error saving records: %v\n", err)
+		slog.Error(msg, "err", err)
+	} else {
+		slog.Error(msg)
 	}
 
-	log.Println("Shutdown complete.")
+	os.Exit(1)
 }
@@ -0,0 +1,466 @@
+// Package config loads the server configuration from defaults, a config file,
+// environment variables and command-line flags, in increasing order of precedence.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/storage"
+)
+
+const (
+	// envPrefix starts the names of all environment variables read by Load.
+	envPrefix = "RECORDS_"
+	// configEnv names the config file if the -config flag is not given.
+	configEnv = envPrefix + "CONFIG"
+)
+
+// WAL sync policies accepted by the walSync setting.
+const (
+	syncAlways = "always"
+	syncNever  = "never"
+)
+
+// Eviction policies accepted by the eviction setting.
+const (
+	evictLRU = "lru"
+	evictLFU = "lfu"
+)
+
+var (
+	errReadFile      = errors.New("failed to read config file")
+	errDecodeFile    = errors.New("failed to decode config file")
+	errInvalidValue  = errors.New("invalid value")
+	errMustBeSet     = errors.New("must be set")
+	errNegative      = errors.New("must not be negative")
+	errNotPositive   = errors.New("must be positive")
+	errAboveLine     = errors.New("must not exceed the longest line the storage reads")
+	errUnknownSync   = errors.New("must be always or never")
+	errUnknownEvict  = errors.New("must be lru or lfu")
+	errUnknownStore  = errors.New("must be file, log, dir, lsm or memory")
+	errUnknownLevel  = errors.New("must be debug, info, warn or error")
+	errDuplicateIdx  = errors.New("field is indexed more than once")
+	errEmptyIdxField = errors.New("index field must be set")
+)
+
+// Config is the configuration of the server. Config files use the yaml keys,
+// environment variables the RECORDS_ prefix and flags the kebab-case form,
+// so dataDir is set by RECORDS_DATA_DIR and -data-dir.
+type Config struct {
+	// Addr is the address the server listens on.
+	Addr string `yaml:"addr"`
+	// DataDir holds the default collection's records and the collections directory.
+	DataDir string `yaml:"dataDir"`
+	// SchemaFile is the JSON Schema the records of all collections are
+	// validated against. If empty, dataDir/schema.json is used when it exists.
+	SchemaFile string `yaml:"schemaFile"`
+	// Storage is the storage backend of every collection.
+	Storage string `yaml:"storage"`
+
+	FlushInterval  Duration `yaml:"flushInterval"`
+	FlushThreshold int      `yaml:"flushThreshold"`
+	WALSync        string   `yaml:"walSync"`
+	Backups        int      `yaml:"backups"`
+	// StrictLoad refuses to start when a snapshot has corrupt entries,
+	// instead of loading the intact ones and logging the others.
+	StrictLoad bool `yaml:"strictLoad"`
+
+	// MaxCachedRecords bounds the records every cache holds in memory, 0 for
+	// no bound. It only applies to the lsm storage backend.
+	MaxCachedRecords int    `yaml:"maxCachedRecords"`
+	Eviction         string `yaml:"eviction"`
+
+	ReadHeaderTimeout Duration `yaml:"readHeaderTimeout"`
+	ReadTimeout       Duration `yaml:"readTimeout"`
+	WriteTimeout      Duration `yaml:"writeTimeout"`
+	IdleTimeout       Duration `yaml:"idleTimeout"`
+	ShutdownTimeout   Duration `yaml:"shutdownTimeout"`
+	// DrainDelay is how long the server keeps serving, while reporting itself
+	// unready, between the shutdown signal and closing its listener.
+	DrainDelay Duration `yaml:"drainDelay"`
+
+	// MaxBodyBytes limits the size of request bodies other than bulk imports.
+	// It may not exceed storage.MaxLine, so that every record the API accepts
+	// can be read back.
+	MaxBodyBytes int64  `yaml:"maxBodyBytes"`
+	LogLevel     string `yaml:"logLevel"`
+
+	// Indexes are maintained by the cache of every collection. They can only
+	// be set in the config file.
+	Indexes []Index `yaml:"indexes"`
+}
+
+// Index declares a secondary index on a top-level record field.
+type Index struct {
+	Field  string `yaml:"field"`
+	Unique bool   `yaml:"unique"`
+}
+
+// Duration is a time.Duration written as a string such as "5s" or "1m30s".
+type Duration time.Duration
+
+// setting describes a Config field that can be set by environment variable and flag.
+type setting struct {
+	name  string // kebab-case flag name
+	usage string
+	set   func(c *Config, value string) error
+}
+
+// Default returns the configuration used for settings that are not given.
+func Default() Config {
+	return Config{
+		Addr:              ":8080",
+		DataDir:           "data",
+		Storage:           string(storage.BackendFile),
+		FlushInterval:     Duration(5 * time.Second),
+		FlushThreshold:    50,
+		WALSync:           syncAlways,
+		Backups:           3,
+		Eviction:          evictLRU,
+		ReadHeaderTimeout: Duration(5 * time.Second),
+		ReadTimeout:       Duration(30 * time.Second),
+		IdleTimeout:       Duration(2 * time.Minute),
+		ShutdownTimeout:   Duration(10 * time.Second),
+		MaxBodyBytes:      10 << 20,
+		LogLevel:          "info",
+	}
+}
+
+// Load builds the configuration from args (without the program name) and the
+// environment, looked up with lookupEnv. Flags take precedence over environment
+// variables, which take precedence over the config file named by -config or
+// RECORDS_CONFIG. The result is validated. Load returns flag.ErrHelp if args
+// ask for help, after writing the usage to output.
+func Load(args []string, lookupEnv func(string) (string, bool), output io.Writer) (Config, error) {
+	cfg := Default()
+	settings := allSettings()
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.SetOutput(output)
+
+	configFile := flags.String("config", "", "path of a YAML or JSON config file (env "+configEnv+")")
+	values := make(map[string]*string, len(settings))
+
+	for _, s := range settings {
+		values[s.name] = flags.String(s.name, "", s.usage+" (env "+envName(s.name)+")")
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return Config{}, fmt.Errorf("parsing flags: %w", err)
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(configEnv)
+	}
+
+	if *configFile != "" {
+		err = cfg.loadFile(*configFile)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := lookupEnv(envName(s.name)); ok {
+			err = s.set(&cfg, value)
+			if err != nil {
+				return Config{}, fmt.Errorf("environment variable %s: %w", envName(s.name), err)
+			}
+		}
+	}
+
+	var flagErr error
+
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name == f.Name && flagErr == nil {
+				if err := s.set(&cfg, *values[s.name]); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %w", s.name, err)
+				}
+			}
+		}
+	})
+
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks all settings and reports every invalid one.
+func (c Config) Validate() error {
+	var errs []error
+
+	check := func(ok bool, key string, err error) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	check(c.Addr != "", "addr", errMustBeSet)
+	check(c.DataDir != "", "dataDir", errMustBeSet)
+	check(slices.Contains(storage.Backends(), c.Backend()), "storage", errUnknownStore)
+	check(c.FlushInterval >= 0, "flushInterval", errNegative)
+	check(c.FlushThreshold >= 0, "flushThreshold", errNegative)
+	check(c.WALSync == syncAlways || c.WALSync == syncNever, "walSync", errUnknownSync)
+	check(c.Backups >= 0, "backups", errNegative)
+	check(c.MaxCachedRecords >= 0, "maxCachedRecords", errNegative)
+	check(c.Eviction == evictLRU || c.Eviction == evictLFU, "eviction", errUnknownEvict)
+	check(c.ReadHeaderTimeout >= 0, "readHeaderTimeout", errNegative)
+	check(c.ReadTimeout >= 0, "readTimeout", errNegative)
+	check(c.WriteTimeout >= 0, "writeTimeout", errNegative)
+	check(c.IdleTimeout >= 0, "idleTimeout", errNegative)
+	check(c.ShutdownTimeout > 0, "shutdownTimeout", errNotPositive)
+	check(c.DrainDelay >= 0, "drainDelay", errNegative)
+	check(c.MaxBodyBytes > 0, "maxBodyBytes", errNotPositive)
+	check(c.MaxBodyBytes <= storage.MaxLine, "maxBodyBytes", errAboveLine)
+
+	_, err := c.Level()
+	check(err == nil, "logLevel", errUnknownLevel)
+
+	fields := make(map[string]bool, len(c.Indexes))
+	for i, idx := range c.Indexes {
+		key := fmt.Sprintf("indexes[%d]", i)
+		check(idx.Field != "", key, errEmptyIdxField)
+		check(!fields[idx.Field], key, errDuplicateIdx)
+		fields[idx.Field] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+// Backend returns the storage backend of every collection.
+func (c Config) Backend() storage.Backend {
+	return storage.Backend(c.Storage)
+}
+
+// CollectionsDir returns the directory holding the other collections.
+func (c Config) CollectionsDir() string {
+	return filepath.Join(c.DataDir, "collections")
+}
+
+// Schema returns the path of the schema file and whether it must exist.
+func (c Config) Schema() (string, bool) {
+	if c.SchemaFile != "" {
+		return c.SchemaFile, true
+	}
+
+	return filepath.Join(c.DataDir, "schema.json"), false
+}
+
+// CacheOptions returns the options for the cache of every collection.
+func (c Config) CacheOptions() []cache.Option {
+	eviction := cache.EvictLRU
+	if c.Eviction == evictLFU {
+		eviction = cache.EvictLFU
+	}
+
+	opts := []cache.Option{
+		cache.WithFlushInterval(time.Duration(c.FlushInterval)),
+		cache.WithFlushThreshold(c.FlushThreshold),
+		cache.WithMaxRecords(c.MaxCachedRecords),
+		cache.WithEviction(eviction),
+	}
+
+	for _, idx := range c.Indexes {
+		if idx.Unique {
+			opts = append(opts, cache.WithUniqueIndex(idx.Field))
+		} else {
+			opts = append(opts, cache.WithIndex(idx.Field))
+		}
+	}
+
+	return opts
+}
+
+// StorageOptions returns the options for the storage of every collection.
+func (c Config) StorageOptions() []storage.Option {
+	policy := storage.SyncAlways
+	if c.WALSync == syncNever {
+		policy = storage.SyncNever
+	}
+
+	opts := []storage.Option{
+		storage.WithSyncPolicy(policy),
+		storage.WithBackups(c.Backups),
+	}
+
+	if c.StrictLoad {
+		opts = append(opts, storage.WithStrict())
+	}
+
+	return opts
+}
+
+// Level returns the log level.
+func (c Config) Level() (slog.Level, error) {
+	var level slog.Level
+
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	if err != nil || !strings.EqualFold(c.LogLevel, level.String()) {
+		return 0, fmt.Errorf("log level %q: %w", c.LogLevel, errUnknownLevel)
+	}
+
+	return level, nil
+}
+
+// UnmarshalText parses a duration such as "5s".
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errInvalidValue, err)
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+// MarshalText formats the duration like time.Duration.String.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// loadFile decodes the YAML or JSON config file into c. Unknown keys are errors.
+func (c *Config) loadFile(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("config file %q: %w: %w", name, errReadFile, err)
+	}
+
+	// JSON is a subset of YAML, so one decoder reads both formats.
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(c)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %q: %w: %w", name, errDecodeFile, err)
+	}
+
+	return nil
+}
+
+// allSettings lists the settings that can be given as flags and environment variables.
+func allSettings() []setting {
+	return []setting{
+		{"addr", "address to listen on", stringSetting(func(c *Config) *string { return &c.Addr })},
+		{"data-dir", "directory holding the records", stringSetting(func(c *Config) *string { return &c.DataDir })},
+		{"schema-file", "JSON Schema to validate the records of all collections against",
+			stringSetting(func(c *Config) *string { return &c.SchemaFile })},
+		{"flush-interval", "how often to save records, 0 to disable", durationSetting(func(c *Config) *Duration {
+			return &c.FlushInterval
+		})},
+		{"flush-threshold", "number of changes that triggers a save, 0 to disable", intSetting(func(c *Config) *int {
+			return &c.FlushThreshold
+		})},
+		{"storage", "storage backend: file, log, dir, lsm or memory", stringSetting(func(c *Config) *string {
+			return &c.Storage
+		})},
+		{"wal-sync", "when to fsync the write-ahead log: always or never", stringSetting(func(c *Config) *string {
+			return &c.WALSync
+		})},
+		{"backups", "number of previous snapshots to keep", intSetting(func(c *Config) *int { return &c.Backups })},
+		{"strict-load", "true to refuse to start on corrupt snapshots", boolSetting(func(c *Config) *bool {
+			return &c.StrictLoad
+		})},
+		{"max-cached-records", "records every cache holds in memory, 0 for all", intSetting(func(c *Config) *int {
+			return &c.MaxCachedRecords
+		})},
+		{"eviction", "cache eviction policy: lru or lfu", stringSetting(func(c *Config) *string { return &c.Eviction })},
+		{"read-header-timeout", "timeout for reading request headers", durationSetting(func(c *Config) *Duration {
+			return &c.ReadHeaderTimeout
+		})},
+		{"read-timeout", "timeout for reading requests, 0 for none", durationSetting(func(c *Config) *Duration {
+			return &c.ReadTimeout
+		})},
+		{"write-timeout", "timeout for writing responses, 0 for none", durationSetting(func(c *Config) *Duration {
+			return &c.WriteTimeout
+		})},
+		{"idle-timeout", "timeout for idle keep-alive connections", durationSetting(func(c *Config) *Duration {
+			return &c.IdleTimeout
+		})},
+		{"shutdown-timeout", "time allowed for a graceful shutdown", durationSetting(func(c *Config) *Duration {
+			return &c.ShutdownTimeout
+		})},
+		{"drain-delay", "time to keep serving as unready before shutting down", durationSetting(func(c *Config) *Duration {
+			return &c.DrainDelay
+		})},
+		{"max-body-bytes", "largest accepted request body", func(c *Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%q: %w", value, errInvalidValue)
+			}
+
+			c.MaxBodyBytes = n
+
+			return nil
+		}},
+		{"log-level", "debug, info, warn or error", stringSetting(func(c *Config) *string { return &c.LogLevel })},
+	}
+}
+
+// envName returns the environment variable of the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func stringSetting(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+
+		return nil
+	}
+}
+
+func intSetting(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q: %w", value, errInvalidValue)
+		}
+
+		*field(c) = n
+
+		return nil
+	}
+}
+
+func boolSetting(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q: %w", value, errInvalidValue)
+		}
+
+		*field(c) = b
+
+		return nil
+	}
+}
+
+func durationSetting(field func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		return field(c).UnmarshalText([]byte(value))
+	}
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		return path
+	}
+
+	yamlFile := writeFile("config.yaml", `
+addr: ":9000"
+dataDir: /var/lib/records
+flushInterval: 1m
+storage: log
+walSync: never
+indexes:
+  - field: email
+    unique: true
+`)
+	jsonFile := writeFile("config.json", "{\n\t\"addr\": \":9001\",\n\t\"backups\": 5\n}\n")
+	unknownKeyFile := writeFile("unknown.yaml", "port: 8080\n")
+
+	withDefaults := func(change func(c *Config)) Config {
+		c := Default()
+		change(&c)
+
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected Config
+		err      error
+	}{
+		{
+			name:     "defaults",
+			expected: Default(),
+		},
+		{
+			name: "yaml file",
+			args: []string{"-config", yamlFile},
+			expected: withDefaults(func(c *Config) {
+				c.Addr = ":9000"
+				c.DataDir = "/var/lib/records"
+				c.FlushInterval = Duration(time.Minute)
+				c.Storage = "log"
+				c.WALSync = "never"
+				c.Indexes = []Index{{Field: "email", Unique: true}}
+			}),
+		},
+		{
+			name: "json file from environment",
+			env:  map[string]string{"RECORDS_CONFIG": jsonFile},
+			expected: withDefaults(func(c *Config) {
+				c.Addr = ":9001"
+				c.Backups = 5
+			}),
+		},
+		{
+			name: "environment overrides file",
+			args: []string{"-config", yamlFile},
+			env:  map[string]string{"RECORDS_ADDR": ":7000", "RECORDS_FLUSH_THRESHOLD": "0", "RECORDS_STORAGE": "dir"},
+			expected: withDefaults(func(c *Config) {
+				c.Addr = ":7000"
+				c.DataDir = "/var/lib/records"
+				c.Storage = "dir"
+				c.FlushInterval = Duration(time.Minute)
+				c.FlushThreshold = 0
+				c.WALSync = "never"
+				c.Indexes = []Index{{Field: "email", Unique: true}}
+			}),
+		},
+		{
+			name: "flags override environment",
+			args: []string{"-addr", ":6000", "-shutdown-timeout", "1s", "-log-level", "debug", "-eviction", "lfu"},
+			env: map[string]string{
+				"RECORDS_ADDR": ":7000", "RECORDS_MAX_BODY_BYTES": "1024", "RECORDS_MAX_CACHED_RECORDS": "500",
+				"RECORDS_STRICT_LOAD": "true",
+			},
+			expected: withDefaults(func(c *Config) {
+				c.StrictLoad = true
+				c.Addr = ":6000"
+				c.ShutdownTimeout = Duration(time.Second)
+				c.LogLevel = "debug"
+				c.MaxBodyBytes = 1024
+				c.MaxCachedRecords = 500
+				c.Eviction = "lfu"
+			}),
+		},
+		{name: "missing file", args: []string{"-config", filepath.Join(dir, "missing.yaml")}, err: errReadFile},
+		{name: "unknown key", args: []string{"-config", unknownKeyFile}, err: errDecodeFile},
+		{name: "bad duration", args: []string{"-flush-interval", "soon"}, err: errInvalidValue},
+		{name: "bad bool", args: []string{"-strict-load", "maybe"}, err: errInvalidValue},
+		{name: "bad number", env: map[string]string{"RECORDS_BACKUPS": "many"}, err: errInvalidValue},
+		{name: "unknown flag", args: []string{"-port", "8080"}, err: errors.New("flag provided but not defined")},
+		{name: "help", args: []string{"-h"}, err: flag.ErrHelp},
+		{name: "invalid storage", env: map[string]string{"RECORDS_STORAGE": "tape"}, err: errUnknownStore},
+		{name: "invalid sync", args: []string{"-wal-sync", "sometimes"}, err: errUnknownSync},
+		{name: "invalid eviction", args: []string{"-eviction", "fifo"}, err: errUnknownEvict},
+		{name: "invalid level", args: []string{"-log-level", "verbose"}, err: errUnknownLevel},
+		{name: "negative threshold", args: []string{"-flush-threshold", "-1"}, err: errNegative},
+		{name: "negative drain delay", env: map[string]string{"RECORDS_DRAIN_DELAY": "-1s"}, err: errNegative},
+		{name: "zero body limit", args: []string{"-max-body-bytes", "0"}, err: errNotPositive},
+		{name: "huge body limit", args: []string{"-max-body-bytes", "1099511627776"}, err: errAboveLine},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			lookupEnv := func(key string) (string, bool) {
+				value, ok := tt.env[key]
+
+				return value, ok
+			}
+
+			cfg, err := Load(tt.args, lookupEnv, io.Discard)
+
+			switch {
+			case tt.err == nil:
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, cfg)
+			case errors.Is(err, tt.err):
+			default:
+				require.ErrorContains(t, err, tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	t.Parallel()
+
+	cfg := Default()
+	cfg.Addr = ""
+	cfg.Backups = -1
+	cfg.Indexes = []Index{{Field: "email"}, {Field: "email"}}
+
+	err := cfg.Validate()
+
+	require.ErrorIs(t, err, errMustBeSet)
+	require.ErrorIs(t, err, errNegative)
+	require.ErrorIs(t, err, errDuplicateIdx)
+	assert.EqualError(t, err, "addr: must be set\nbackups: must not be negative\n"+
+		"indexes[1]: field is indexed more than once")
+}
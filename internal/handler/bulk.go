@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// Import modes, selecting what happens to a record whose id is already stored.
+const (
+	// modeFail reports the conflict and stops the import.
+	modeFail = "fail"
+	// modeSkip keeps the stored record.
+	modeSkip = "skip"
+	// modeUpsert replaces the stored record.
+	modeUpsert = "upsert"
+)
+
+// Outcomes of importing a single line.
+const (
+	statusCreated = "created"
+	statusUpdated = "updated"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+)
+
+const (
+	ndjsonType = "application/x-ndjson"
+	// maxBulkLine is the longest line accepted by a bulk import.
+	maxBulkLine = 1 << 20
+	// exportPageSize is the number of records read from the cache at a time while exporting.
+	exportPageSize = 1000
+)
+
+var errInvalidMode = errors.New("mode must be fail, skip or upsert")
+
+// bulkResult reports the outcome of importing one line of a bulk request.
+type bulkResult struct {
+	Line   int     `json:"line"`
+	ID     *uint64 `json:"id,omitempty"`
+	Status string  `json:"status"`
+	Error  string  `json:"error,omitempty"`
+	// Code is the problem code of Error, as in error responses.
+	Code string `json:"code,omitempty"`
+}
+
+// Bulk handles POST /records:bulk requests that import one JSON record per line.
+// The mode query parameter (fail, skip or upsert) decides how records whose id
+// is already stored are treated; in fail mode the import stops at the first
+// failed line. The response lists the outcome of every non-empty line as NDJSON.
+// The import stops after the current line once the client goes away.
+func (h *RecordHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+
+	switch mode {
+	case "":
+		mode = modeFail
+	case modeFail, modeSkip, modeUpsert:
+	default:
+		WriteProblem(w, r, errInvalidMode)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", ndjsonType)
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxBulkLine)
+
+	line := 0
+
+	for scanner.Scan() {
+		err := r.Context().Err()
+		if err != nil {
+			slog.InfoContext(r.Context(), "bulk import canceled", "line", line, "err", err)
+
+			return
+		}
+
+		line++
+
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		result := h.importLine(r.Context(), data, mode)
+		result.Line = line
+
+		err = encoder.Encode(result)
+		if err != nil {
+			slog.WarnContext(r.Context(), "failed to write bulk result", "err", err)
+
+			return
+		}
+
+		if result.Status == statusFailed && mode == modeFail {
+			return
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		result := failedResult(r.Context(), nil, fmt.Errorf("%w: %w", errInvalidPayload, err))
+		result.Line = line + 1
+
+		err = encoder.Encode(result)
+		if err != nil {
+			slog.WarnContext(r.Context(), "failed to write bulk result", "err", err)
+		}
+	}
+}
+
+// importLine stores the record encoded in data according to mode.
+func (h *RecordHandler) importLine(ctx context.Context, data []byte, mode string) bulkResult {
+	var record userrecord.Record
+
+	err := json.Unmarshal(data, &record)
+	if err != nil {
+		return failedResult(ctx, nil, fmt.Errorf("%w: %w", errInvalidPayload, err))
+	}
+
+	if _, hasID := record["id"]; !hasID {
+		id, createErr := h.cache.Create(ctx, record)
+		if createErr != nil {
+			return failedResult(ctx, nil, createErr)
+		}
+
+		return bulkResult{ID: &id, Status: statusCreated}
+	}
+
+	err = record.Validate()
+	if err != nil {
+		return failedResult(ctx, nil, err)
+	}
+
+	id, err := record.ID()
+	if err != nil {
+		return failedResult(ctx, nil, err)
+	}
+
+	err = h.cache.Add(ctx, id, record)
+
+	switch {
+	case err == nil:
+		return bulkResult{ID: &id, Status: statusCreated}
+	case errors.Is(err, cache.ErrRecordExists) && mode == modeSkip:
+		return bulkResult{ID: &id, Status: statusSkipped}
+	case errors.Is(err, cache.ErrRecordExists) && mode == modeUpsert:
+		err = h.cache.Update(ctx, id, record, nil)
+		if err != nil {
+			return failedResult(ctx, &id, err)
+		}
+
+		return bulkResult{ID: &id, Status: statusUpdated}
+	default:
+		return failedResult(ctx, &id, err)
+	}
+}
+
+// failedResult reports that importing the record with id, if known, failed with err.
+// Server faults are described by their title only, as for WriteProblem.
+func failedResult(ctx context.Context, id *uint64, err error) bulkResult {
+	problem := reportProblem(ctx, err)
+
+	return bulkResult{ID: id, Status: statusFailed, Error: cmp.Or(problem.Detail, problem.Title), Code: problem.Code}
+}
+
+// Export handles GET /records:export requests by streaming all records as NDJSON,
+// ordered by id. The sort and filter query parameters work as for List. Records
+// are read a page at a time, so writes are not blocked for the whole response,
+// and no more pages are read once the client goes away.
+func (h *RecordHandler) Export(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		WriteProblem(w, r, err)
+
+		return
+	}
+
+	opts.Cursor = ""
+	opts.Limit = exportPageSize
+
+	page, err := h.cache.List(r.Context(), opts)
+	if err != nil {
+		WriteProblem(w, r, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", ndjsonType)
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		for _, record := range page.Records {
+			err = encoder.Encode(record)
+			if err != nil {
+				slog.WarnContext(r.Context(), "failed to write exported record", "err", err)
+
+				return
+			}
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if page.NextCursor == "" {
+			return
+		}
+
+		opts.Cursor = page.NextCursor
+
+		err = r.Context().Err()
+		if err != nil {
+			slog.InfoContext(r.Context(), "export canceled", "cursor", opts.Cursor, "err", err)
+
+			return
+		}
+
+		page, err = h.cache.List(r.Context(), opts)
+		if err != nil {
+			// The status is already sent, so the truncated stream is all the client gets.
+			slog.ErrorContext(r.Context(), "failed to export records", "cursor", opts.Cursor, "err", err)
+
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"zabbix-technical-task/pkg/collection"
+)
+
+// CollectionHandler handles HTTP requests for collection operations and
+// serves the records of each collection.
+type CollectionHandler struct {
+	registry collection.Registry
+}
+
+// collectionRequest is the body of a POST /collections request.
+type collectionRequest struct {
+	Name string `json:"name"`
+}
+
+// collectionsResponse is the body of a GET /collections response.
+type collectionsResponse struct {
+	Collections []string `json:"collections"`
+}
+
+// NewCollectionHandler creates a new handler with the given collection registry.
+func NewCollectionHandler(registry collection.Registry) *CollectionHandler {
+	return &CollectionHandler{
+		registry: registry,
+	}
+}
+
+// Create handles POST /collections requests to create an empty collection.
+func (h *CollectionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req collectionRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		WriteProblem(w, r, fmt.Errorf("%w: %w", errInvalidPayload, err))
+
+		return
+	}
+
+	err = h.registry.Create(r.Context(), req.Name)
+	if err != nil {
+		WriteProblem(w, r, err)
+
+		return
+	}
+
+	w.Header().Set("Location", path.Join(r.URL.Path, req.Name))
+	writeJSON(r.Context(), w, http.StatusCreated, collectionRequest{Name: req.Name})
+}
+
+// List handles GET /collections requests to list the names of all collections.
+func (h *CollectionHandler) List(w http.ResponseWriter, r *http.Request) {
+	writeJSON(r.Context(), w, http.StatusOK, collectionsResponse{Collections: h.registry.List()})
+}
+
+// Drop handles DELETE /collections/{name} requests to delete a collection with all its records.
+func (h *CollectionHandler) Drop(w http.ResponseWriter, r *http.Request) {
+	err := h.registry.Drop(r.PathValue("name"))
+	if err != nil {
+		WriteProblem(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Records returns a handler that serves a record request with serve,
+// using the cache of the collection named in the path.
+func (h *CollectionHandler) Records(serve func(*RecordHandler, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		records, err := h.registry.Get(r.PathValue("name"))
+		if err != nil {
+			WriteProblem(w, r, err)
+
+			return
+		}
+
+		serve(New(records), w, r)
+	}
+}
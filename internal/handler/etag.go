@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// setETag sets the ETag header to the version of record and returns the
+// version, or an empty string if the record cannot be versioned. The tag is
+// derived from the content, so it is the same for equal contents written at
+// different times: If-Match checks the content a client read, not a revision.
+func setETag(w http.ResponseWriter, record userrecord.Record) string {
+	version, err := record.Version()
+	if err != nil {
+		return ""
+	}
+
+	w.Header().Set("ETag", `"`+version+`"`)
+
+	return version
+}
+
+// ifMatchVersions returns the record versions listed in the If-Match header.
+// An empty result means the write is unconditional, which is also the case for
+// "*" as writes only ever apply to existing records. Weak tags are kept as they
+// are, so they never match, as If-Match requires strong comparison.
+func ifMatchVersions(r *http.Request) []string {
+	header := r.Header.Get("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return nil
+	}
+
+	tags := strings.Split(header, ",")
+
+	versions := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, "W/") {
+			tag = strings.Trim(tag, `"`)
+		}
+
+		versions = append(versions, tag)
+	}
+
+	return versions
+}
+
+// noneMatch reports whether the If-None-Match header matches version,
+// using weak comparison.
+func noneMatch(r *http.Request, version string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+		if tag == version {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,21 +4,40 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
 	"strconv"
-	"strings"
 
 	"zabbix-technical-task/pkg/cache"
 	"zabbix-technical-task/pkg/userrecord"
 )
 
-var errWrongID = errors.New("wrong ID")
+// Media types accepted by PATCH requests.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+var (
+	errUnsupportedPatch = errors.New("unsupported patch media type")
+	errInvalidLimit     = errors.New("limit must be a positive integer")
+	errInvalidSort      = errors.New("sort must be either id or -id")
+)
 
 // RecordHandler handles HTTP requests for record operations.
 type RecordHandler struct {
 	cache cache.Cache
 }
 
+// listResponse is the body of a GET /records response.
+type listResponse struct {
+	Records    []userrecord.Record `json:"records"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
 // New creates a new handler with the given record cache.
 func New(recordsCache cache.Cache) *RecordHandler {
 	return &RecordHandler{
@@ -26,128 +45,195 @@ func New(recordsCache cache.Cache) *RecordHandler {
 	}
 }
 
-// Post handles POST /records requests to create a new record.
+// Post handles POST /records requests to create a new record and responds
+// with the stored record. Records without an id are assigned the next free one.
 func (h *RecordHandler) Post(w http.ResponseWriter, r *http.Request) {
 	var record userrecord.Record
 
 	err := json.NewDecoder(r.Body).Decode(&record)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		WriteProblem(w, r, fmt.Errorf("%w: %w", errInvalidPayload, err))
+
+		return
+	}
+
+	if _, hasID := record["id"]; !hasID {
+		h.create(w, r, record)
 
 		return
 	}
 
 	err = record.Validate()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteProblem(w, r, err)
 
 		return
 	}
 
 	id, err := record.ID()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteProblem(w, r, err)
 
 		return
 	}
 
-	err = h.cache.Add(id, record)
+	err = h.cache.Add(r.Context(), id, record)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
+		WriteProblem(w, r, err)
 
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	setLocation(w, r, id)
+	setETag(w, record)
+	writeJSON(r.Context(), w, http.StatusCreated, record)
+}
 
-	_, err = w.Write([]byte("Record created\n"))
+// create stores a record under a server-assigned id and responds with the stored record.
+func (h *RecordHandler) create(w http.ResponseWriter, r *http.Request, record userrecord.Record) {
+	id, err := h.cache.Create(r.Context(), record)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteProblem(w, r, err)
 
 		return
 	}
+
+	setLocation(w, r, id)
+	setETag(w, record)
+	writeJSON(r.Context(), w, http.StatusCreated, record)
 }
 
 // Get handles GET /records/{id} requests to retrieve a record by ID.
 func (h *RecordHandler) Get(w http.ResponseWriter, r *http.Request) {
-	id, err := parseID(strings.TrimPrefix(r.URL.Path, "/records/"))
+	id, err := parseID(path.Base(r.URL.Path))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteProblem(w, r, err)
 
 		return
 	}
 
-	record, err := h.cache.Get(id)
+	record, err := h.cache.Get(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		WriteProblem(w, r, err)
+
+		return
+	}
+
+	version := setETag(w, record)
+	if version != "" && noneMatch(r, version) {
+		w.WriteHeader(http.StatusNotModified)
 
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	writeJSON(r.Context(), w, http.StatusOK, record)
+}
 
-	err = json.NewEncoder(w).Encode(record)
+// List handles GET /records requests to list records ordered by id.
+// The limit, cursor and sort (id or -id) query parameters control paging,
+// and any other parameter filters records by the field of the same name.
+func (h *RecordHandler) List(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteProblem(w, r, err)
 
 		return
 	}
+
+	page, err := h.cache.List(r.Context(), opts)
+	if err != nil {
+		WriteProblem(w, r, err)
+
+		return
+	}
+
+	writeJSON(r.Context(), w, http.StatusOK, listResponse{
+		Records:    page.Records,
+		NextCursor: page.NextCursor,
+	})
 }
 
-// Put handles PUT /records/{id} requests to update an existing record.
+// Put handles PUT /records/{id} requests to update an existing record
+// and responds with the stored record.
 func (h *RecordHandler) Put(w http.ResponseWriter, r *http.Request) {
 	var record userrecord.Record
 
-	id, err := parseID(strings.TrimPrefix(r.URL.Path, "/records/"))
+	id, err := parseID(path.Base(r.URL.Path))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteProblem(w, r, err)
 
 		return
 	}
 
 	err = json.NewDecoder(r.Body).Decode(&record)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		WriteProblem(w, r, fmt.Errorf("%w: %w", errInvalidPayload, err))
 
 		return
 	}
 
 	err = record.Validate()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteProblem(w, r, err)
 
 		return
 	}
 
-	err = h.cache.Update(id, record)
+	err = h.cache.Update(r.Context(), id, record, ifMatchVersions(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		WriteProblem(w, r, err)
 
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	setETag(w, record)
+	writeJSON(r.Context(), w, http.StatusOK, record)
+}
+
+// Patch handles PATCH /records/{id} requests to partially update a record.
+// The body is a JSON merge patch unless Content-Type is application/json-patch+json.
+func (h *RecordHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(path.Base(r.URL.Path))
+	if err != nil {
+		WriteProblem(w, r, err)
+
+		return
+	}
+
+	patch, err := decodePatch(r)
+	if errors.Is(err, errUnsupportedPatch) {
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+	}
+
+	if err != nil {
+		WriteProblem(w, r, err)
+
+		return
+	}
 
-	_, err = w.Write([]byte("Record updated\n"))
+	record, err := h.cache.Patch(r.Context(), id, patch, ifMatchVersions(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteProblem(w, r, err)
 
 		return
 	}
+
+	setETag(w, record)
+	writeJSON(r.Context(), w, http.StatusOK, record)
 }
 
 // Delete handles DELETE /records/{id} requests to delete a record by ID.
 func (h *RecordHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	id, err := parseID(strings.TrimPrefix(r.URL.Path, "/records/"))
+	id, err := parseID(path.Base(r.URL.Path))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteProblem(w, r, err)
 
 		return
 	}
 
-	err = h.cache.Delete(id)
+	err = h.cache.Delete(r.Context(), id, ifMatchVersions(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		WriteProblem(w, r, err)
 
 		return
 	}
@@ -155,15 +241,95 @@ func (h *RecordHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// setLocation points the Location header at the record created by a POST request.
+func setLocation(w http.ResponseWriter, r *http.Request, id uint64) {
+	w.Header().Set("Location", path.Join(r.URL.Path, strconv.FormatUint(id, 10)))
+}
+
+// parseID parses the record id in a request path.
 func parseID(s string) (uint64, error) {
-	id, err := strconv.Atoi(s)
+	id, err := strconv.ParseUint(s, 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("failed convert str to int: %w", err)
+		return 0, fmt.Errorf("record id %q: %w", s, userrecord.ErrInvalidID)
+	}
+
+	return id, nil
+}
+
+func parseListOptions(query url.Values) (cache.ListOptions, error) {
+	var opts cache.ListOptions
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid request: %w", errInvalidLimit)
+		}
+
+		opts.Limit = n
 	}
 
-	if id < 0 {
-		return 0, fmt.Errorf("invalid request: %w", errWrongID)
+	switch query.Get("sort") {
+	case "", "id":
+	case "-id":
+		opts.Descending = true
+	default:
+		return opts, fmt.Errorf("invalid request: %w", errInvalidSort)
 	}
 
-	return uint64(id), nil
+	opts.Cursor = query.Get("cursor")
+
+	fields := make([]string, 0, len(query))
+
+	for field := range query {
+		if field != "limit" && field != "cursor" && field != "sort" {
+			fields = append(fields, field)
+		}
+	}
+
+	slices.Sort(fields)
+
+	for _, field := range fields {
+		for _, value := range query[field] {
+			opts.Filters = append(opts.Filters, cache.Filter{Field: field, Value: value})
+		}
+	}
+
+	return opts, nil
+}
+
+// decodePatch decodes the request body as the kind of patch named by its Content-Type.
+func decodePatch(r *http.Request) (userrecord.Patch, error) {
+	mediaType := mergePatchType
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, fmt.Errorf("content type %q: %w", contentType, errUnsupportedPatch)
+		}
+	}
+
+	switch mediaType {
+	case mergePatchType, "application/json":
+		var patch userrecord.MergePatch
+
+		err := json.NewDecoder(r.Body).Decode(&patch)
+		if err != nil {
+			return nil, fmt.Errorf("%w: decoding merge patch: %w", errInvalidPayload, err)
+		}
+
+		return patch, nil
+	case jsonPatchType:
+		var patch userrecord.JSONPatch
+
+		err := json.NewDecoder(r.Body).Decode(&patch)
+		if err != nil {
+			return nil, fmt.Errorf("%w: decoding JSON patch: %w", errInvalidPayload, err)
+		}
+
+		return patch, nil
+	default:
+		return nil, fmt.Errorf("content type %q: %w", mediaType, errUnsupportedPatch)
+	}
 }
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -9,7 +10,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/mock"
+	"zabbix-technical-task/pkg/cache"
 	"zabbix-technical-task/pkg/cache/mocks"
+	"zabbix-technical-task/pkg/collection"
+	collectionmocks "zabbix-technical-task/pkg/collection/mocks"
+	"zabbix-technical-task/pkg/storage"
 	"zabbix-technical-task/pkg/userrecord"
 )
 
@@ -39,9 +44,10 @@ func TestPost(t *testing.T) {
 		expectedStatus int
 		expectedBody   string
 	}{
-		{"valid record", `{"id":1.0,"Name":"Alice","Age":30}`, nil, http.StatusCreated, "Record created\n"},
-		{"invalid JSON", `invalid-json`, nil, http.StatusBadRequest, "Invalid request payload\n"},
-		{"validation fail", `{"Name":"","Age":-1}`, nil, http.StatusBadRequest, "missing id\n"},
+		{"valid record", `{"id":1.0,"Name":"Alice","Age":30}`, nil, http.StatusCreated, `{"Age":30,"Name":"Alice","id":1}` + "\n"},
+		{"invalid JSON", `invalid-json`, nil, http.StatusBadRequest, problemBody(http.StatusBadRequest, "invalid request payload: invalid character 'i' looking for beginning of value", "invalid_payload")},
+		{"validation fail", `{"id":-1}`, nil, http.StatusBadRequest, problemBody(http.StatusBadRequest, "invalid id: must be a non-negative integer", "invalid_id")},
+		{"server-assigned id", `{"Name":"Alice"}`, nil, http.StatusCreated, `{"Name":"Alice","id":7}` + "\n"},
 	}
 
 	for _, tt := range tests {
@@ -51,7 +57,10 @@ func TestPost(t *testing.T) {
 			cache := new(mocks.Cache)
 			handler := New(cache)
 
-			cache.On("Add", mock.Anything, mock.Anything).Return(nil)
+			cache.On("Add", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			cache.On("Create", mock.Anything, mock.Anything).Return(uint64(7), nil).Run(func(args mock.Arguments) {
+				args.Get(1).(userrecord.Record)["id"] = uint64(7)
+			})
 
 			req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(tt.payload))
 			w := httptest.NewRecorder()
@@ -68,6 +77,53 @@ func TestPost(t *testing.T) {
 			if string(body) != tt.expectedBody {
 				t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
 			}
+
+			if res.StatusCode == http.StatusCreated && res.Header.Get("Location") == "" {
+				t.Error("expected Location header for created record")
+			}
+		})
+	}
+}
+
+func TestPostLocation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		payload          string
+		cacheErr         error
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"client id", `{"id":3}`, nil, http.StatusCreated, "/records/3"},
+		{"server id", `{"Name":"Alice"}`, nil, http.StatusCreated, "/records/7"},
+		{"server id failure", `{"Name":"Alice"}`, errors.New("disk full"), http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := new(mocks.Cache)
+			handler := New(cache)
+
+			cache.On("Add", mock.Anything, uint64(3), mock.Anything).Return(nil)
+			cache.On("Create", mock.Anything, mock.Anything).Return(uint64(7), tt.cacheErr)
+
+			req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			handler.Post(w, req)
+
+			res := w.Result()
+
+			if res.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, res.StatusCode)
+			}
+
+			if got := res.Header.Get("Location"); got != tt.expectedLocation {
+				t.Errorf("expected Location %q, got %q", tt.expectedLocation, got)
+			}
 		})
 	}
 }
@@ -95,9 +151,9 @@ func TestGet(t *testing.T) {
 			"nonexistent record",
 			"2",
 			userrecord.Record{},
-			errors.New("not found"),
+			fmt.Errorf("record with id 2: %w", cache.ErrRecordNotFound),
 			http.StatusNotFound,
-			"not found\n",
+			problemBody(http.StatusNotFound, "record with id 2: record not found", "record_not_found"),
 		},
 		{
 			"invalid ID",
@@ -105,7 +161,7 @@ func TestGet(t *testing.T) {
 			userrecord.Record{},
 			nil,
 			http.StatusBadRequest,
-			"failed convert str to int: strconv.Atoi: parsing \"abc\": invalid syntax\n",
+			problemBody(http.StatusBadRequest, `record id "abc": invalid id`, "invalid_id"),
 		},
 	}
 
@@ -116,7 +172,7 @@ func TestGet(t *testing.T) {
 			cache := new(mocks.Cache)
 			handler := New(cache)
 
-			cache.On("Get", mock.Anything).Return(tt.cacheRecord, tt.cacheErr)
+			cache.On("Get", mock.Anything, mock.Anything).Return(tt.cacheRecord, tt.cacheErr)
 
 			req := httptest.NewRequest(http.MethodGet, "/records/"+tt.recordID, nil)
 			w := httptest.NewRecorder()
@@ -154,14 +210,14 @@ func TestPut(t *testing.T) {
 			`{"id":1.0,"Name":"Alice","Age":31}`,
 			nil,
 			http.StatusOK,
-			"Record updated\n",
+			`{"Age":31,"Name":"Alice","id":1}` + "\n",
 		},
 		{
 			"invalid JSON",
 			"1", `invalid-json`,
 			nil,
 			http.StatusBadRequest,
-			"Invalid request payload\n",
+			problemBody(http.StatusBadRequest, "invalid request payload: invalid character 'i' looking for beginning of value", "invalid_payload"),
 		},
 		{
 			"validation fail",
@@ -169,15 +225,23 @@ func TestPut(t *testing.T) {
 			`{"Name":"","Age":-1}`,
 			nil,
 			http.StatusBadRequest,
-			"missing id\n",
+			problemBody(http.StatusBadRequest, "missing id", "missing_id"),
 		},
 		{
 			"nonexistent record",
 			"2",
 			`{"id":1.0,"Name":"Bob","Age":25}`,
-			errors.New("not found"),
+			fmt.Errorf("record with id 2: %w", cache.ErrRecordNotFound),
 			http.StatusNotFound,
-			"not found\n",
+			problemBody(http.StatusNotFound, "record with id 2: record not found", "record_not_found"),
+		},
+		{
+			"duplicate unique value",
+			"1",
+			`{"id":1.0,"Name":"Bob","Age":25}`,
+			cache.ErrDuplicateValue,
+			http.StatusConflict,
+			problemBody(http.StatusConflict, "duplicate value in unique field", "duplicate_value"),
 		},
 		{
 			"invalid ID",
@@ -185,7 +249,7 @@ func TestPut(t *testing.T) {
 			`{"Name":"Bob","Age":25}`,
 			nil,
 			http.StatusBadRequest,
-			"failed convert str to int: strconv.Atoi: parsing \"abc\": invalid syntax\n",
+			problemBody(http.StatusBadRequest, `record id "abc": invalid id`, "invalid_id"),
 		},
 	}
 
@@ -196,7 +260,7 @@ func TestPut(t *testing.T) {
 			cache := new(mocks.Cache)
 			handler := New(cache)
 
-			cache.On("Update", mock.Anything, mock.Anything).Return(tt.cacheErr)
+			cache.On("Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(tt.cacheErr)
 
 			req := httptest.NewRequest(http.MethodPut, "/records/"+tt.recordID, strings.NewReader(tt.payload))
 			w := httptest.NewRecorder()
@@ -237,16 +301,16 @@ func TestDelete(t *testing.T) {
 		{
 			"nonexistent record",
 			"2",
-			errors.New("not found"),
+			fmt.Errorf("record with id 2: %w", cache.ErrRecordNotFound),
 			http.StatusNotFound,
-			"not found\n",
+			problemBody(http.StatusNotFound, "record with id 2: record not found", "record_not_found"),
 		},
 		{
 			"invalid ID",
 			"abc",
 			nil,
 			http.StatusBadRequest,
-			"failed convert str to int: strconv.Atoi: parsing \"abc\": invalid syntax\n",
+			problemBody(http.StatusBadRequest, `record id "abc": invalid id`, "invalid_id"),
 		},
 	}
 
@@ -257,7 +321,7 @@ func TestDelete(t *testing.T) {
 			cache := new(mocks.Cache)
 			handler := New(cache)
 
-			cache.On("Delete", mock.Anything).Return(tt.cacheErr)
+			cache.On("Delete", mock.Anything, mock.Anything, mock.Anything).Return(tt.cacheErr)
 
 			req := httptest.NewRequest(http.MethodDelete, "/records/"+tt.recordID, nil)
 			w := httptest.NewRecorder()
@@ -277,3 +341,817 @@ func TestDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	page := cache.Page{
+		Records:    []userrecord.Record{{"id": uint64(1), "name": "Alice"}},
+		NextCursor: "1",
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedOpts   cache.ListOptions
+		cacheErr       error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			"defaults",
+			"",
+			cache.ListOptions{},
+			nil,
+			http.StatusOK,
+			`{"records":[{"id":1,"name":"Alice"}],"next_cursor":"1"}` + "\n",
+		},
+		{
+			"paging and filters",
+			"?limit=1&cursor=0&sort=-id&name=Alice&likes=apples&likes=pears",
+			cache.ListOptions{
+				Cursor:     "0",
+				Limit:      1,
+				Descending: true,
+				Filters: []cache.Filter{
+					{Field: "likes", Value: "apples"},
+					{Field: "likes", Value: "pears"},
+					{Field: "name", Value: "Alice"},
+				},
+			},
+			nil,
+			http.StatusOK,
+			`{"records":[{"id":1,"name":"Alice"}],"next_cursor":"1"}` + "\n",
+		},
+		{
+			"invalid limit",
+			"?limit=0",
+			cache.ListOptions{},
+			nil,
+			http.StatusBadRequest,
+			problemBody(http.StatusBadRequest, "invalid request: limit must be a positive integer", "invalid_parameter"),
+		},
+		{
+			"invalid sort",
+			"?sort=name",
+			cache.ListOptions{},
+			nil,
+			http.StatusBadRequest,
+			problemBody(http.StatusBadRequest, "invalid request: sort must be either id or -id", "invalid_parameter"),
+		},
+		{
+			"invalid cursor",
+			"?cursor=abc",
+			cache.ListOptions{Cursor: "abc"},
+			cache.ErrInvalidCursor,
+			http.StatusBadRequest,
+			problemBody(http.StatusBadRequest, "invalid cursor", "invalid_cursor"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			recordCache := new(mocks.Cache)
+			handler := New(recordCache)
+
+			recordCache.On("List", mock.Anything, tt.expectedOpts).Return(page, tt.cacheErr)
+
+			req := httptest.NewRequest(http.MethodGet, "/records"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			handler.List(w, req)
+
+			res := w.Result()
+			body, _ := io.ReadAll(res.Body)
+
+			if res.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, res.StatusCode)
+			}
+
+			if string(body) != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestPatch(t *testing.T) {
+	t.Parallel()
+
+	patched := userrecord.Record{"id": uint64(1), "Name": "Alice"}
+
+	tests := []struct {
+		name           string
+		recordID       string
+		contentType    string
+		payload        string
+		expectedPatch  userrecord.Patch
+		cacheErr       error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			"merge patch by default",
+			"1",
+			"",
+			`{"Name":"Alice","Age":null}`,
+			userrecord.MergePatch{"Name": "Alice", "Age": nil},
+			nil,
+			http.StatusOK,
+			`{"Name":"Alice","id":1}` + "\n",
+		},
+		{
+			"merge patch with charset",
+			"1",
+			"application/merge-patch+json; charset=utf-8",
+			`{"Name":"Alice"}`,
+			userrecord.MergePatch{"Name": "Alice"},
+			nil,
+			http.StatusOK,
+			`{"Name":"Alice","id":1}` + "\n",
+		},
+		{
+			"JSON patch",
+			"1",
+			"application/json-patch+json",
+			`[{"op":"replace","path":"/Name","value":"Alice"}]`,
+			userrecord.JSONPatch{{Op: "replace", Path: "/Name", Value: []byte(`"Alice"`)}},
+			nil,
+			http.StatusOK,
+			`{"Name":"Alice","id":1}` + "\n",
+		},
+		{
+			"unsupported media type",
+			"1",
+			"text/plain",
+			`Name=Alice`,
+			nil,
+			nil,
+			http.StatusUnsupportedMediaType,
+			problemBody(http.StatusUnsupportedMediaType, `content type "text/plain": unsupported patch media type`, "unsupported_media_type"),
+		},
+		{
+			"invalid JSON",
+			"1",
+			"application/merge-patch+json",
+			`["Name"]`,
+			nil,
+			nil,
+			http.StatusBadRequest,
+			problemBody(http.StatusBadRequest,
+				"invalid request payload: decoding merge patch: json: cannot unmarshal array into Go value of type userrecord.MergePatch",
+				"invalid_payload"),
+		},
+		{
+			"failed test operation",
+			"1",
+			"application/json-patch+json",
+			`[{"op":"test","path":"/Name","value":"Bob"}]`,
+			userrecord.JSONPatch{{Op: "test", Path: "/Name", Value: []byte(`"Bob"`)}},
+			userrecord.ErrPatchTestFailed,
+			http.StatusConflict,
+			problemBody(http.StatusConflict, "patch test failed", "patch_test_failed"),
+		},
+		{
+			"patch cannot be applied",
+			"1",
+			"application/json-patch+json",
+			`[{"op":"remove","path":"/Age"}]`,
+			userrecord.JSONPatch{{Op: "remove", Path: "/Age"}},
+			userrecord.ErrInvalidPatch,
+			http.StatusUnprocessableEntity,
+			problemBody(http.StatusUnprocessableEntity, "invalid patch", "invalid_patch"),
+		},
+		{
+			"nonexistent record",
+			"2",
+			"",
+			`{"Name":"Alice"}`,
+			userrecord.MergePatch{"Name": "Alice"},
+			fmt.Errorf("record with id 2: %w", cache.ErrRecordNotFound),
+			http.StatusNotFound,
+			problemBody(http.StatusNotFound, "record with id 2: record not found", "record_not_found"),
+		},
+		{
+			"invalid ID",
+			"abc",
+			"",
+			`{"Name":"Alice"}`,
+			nil,
+			nil,
+			http.StatusBadRequest,
+			problemBody(http.StatusBadRequest, `record id "abc": invalid id`, "invalid_id"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := new(mocks.Cache)
+			handler := New(cache)
+
+			cache.On("Patch", mock.Anything, mock.Anything, tt.expectedPatch, mock.Anything).Return(patched, tt.cacheErr)
+
+			req := httptest.NewRequest(http.MethodPatch, "/records/"+tt.recordID, strings.NewReader(tt.payload))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			w := httptest.NewRecorder()
+
+			handler.Patch(w, req)
+
+			res := w.Result()
+			body, _ := io.ReadAll(res.Body)
+
+			if res.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, res.StatusCode)
+			}
+
+			if string(body) != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	t.Parallel()
+
+	record := userrecord.Record{"id": uint64(1), "Name": "Alice"}
+
+	version, err := record.Version()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	etag := `"` + version + `"`
+
+	tests := []struct {
+		name             string
+		method           string
+		header           string
+		value            string
+		expectedVersions []string
+		cacheErr         error
+		expectedStatus   int
+		expectedETag     string
+	}{
+		{"get sets etag", http.MethodGet, "", "", nil, nil, http.StatusOK, etag},
+		{"get not modified", http.MethodGet, "If-None-Match", `"other", W/` + etag, nil, nil, http.StatusNotModified, etag},
+		{"get any not modified", http.MethodGet, "If-None-Match", "*", nil, nil, http.StatusNotModified, etag},
+		{"get modified", http.MethodGet, "If-None-Match", `"other"`, nil, nil, http.StatusOK, etag},
+		{"put unconditional", http.MethodPut, "", "", nil, nil, http.StatusOK, etag},
+		{"put any", http.MethodPut, "If-Match", "*", nil, nil, http.StatusOK, etag},
+		{
+			"put matching",
+			http.MethodPut,
+			"If-Match",
+			`"a", ` + etag + `, W/"b"`,
+			[]string{"a", version, `W/"b"`},
+			nil,
+			http.StatusOK,
+			etag,
+		},
+		{
+			"put mismatch",
+			http.MethodPut,
+			"If-Match",
+			`"a"`,
+			[]string{"a"},
+			cache.ErrVersionMismatch,
+			http.StatusPreconditionFailed,
+			"",
+		},
+		{
+			"patch mismatch",
+			http.MethodPatch,
+			"If-Match",
+			`"a"`,
+			[]string{"a"},
+			cache.ErrVersionMismatch,
+			http.StatusPreconditionFailed,
+			"",
+		},
+		{"patch matching", http.MethodPatch, "If-Match", etag, []string{version}, nil, http.StatusOK, etag},
+		{
+			"delete mismatch",
+			http.MethodDelete,
+			"If-Match",
+			`"a"`,
+			[]string{"a"},
+			cache.ErrVersionMismatch,
+			http.StatusPreconditionFailed,
+			"",
+		},
+		{"delete matching", http.MethodDelete, "If-Match", etag, []string{version}, nil, http.StatusNoContent, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			recordCache := new(mocks.Cache)
+			handler := New(recordCache)
+
+			recordCache.On("Get", mock.Anything, uint64(1)).Return(record, nil)
+			recordCache.On("Update", mock.Anything, uint64(1), mock.Anything, tt.expectedVersions).Return(tt.cacheErr)
+			recordCache.On("Patch", mock.Anything, uint64(1), mock.Anything, tt.expectedVersions).Return(record, tt.cacheErr)
+			recordCache.On("Delete", mock.Anything, uint64(1), tt.expectedVersions).Return(tt.cacheErr)
+
+			req := httptest.NewRequest(tt.method, "/records/1", strings.NewReader(`{"id":1,"Name":"Alice"}`))
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			w := httptest.NewRecorder()
+
+			map[string]http.HandlerFunc{
+				http.MethodGet:    handler.Get,
+				http.MethodPut:    handler.Put,
+				http.MethodPatch:  handler.Patch,
+				http.MethodDelete: handler.Delete,
+			}[tt.method](w, req)
+
+			res := w.Result()
+
+			if res.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, res.StatusCode)
+			}
+
+			if got := res.Header.Get("ETag"); got != tt.expectedETag {
+				t.Errorf("expected ETag %q, got %q", tt.expectedETag, got)
+			}
+		})
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	t.Parallel()
+
+	logErr := fmt.Errorf("logging record with id 1: %w: %w", cache.ErrLogRecord, storage.ErrWriteLog)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		payload        string
+		cacheErr       error
+		serve          func(h *RecordHandler, w http.ResponseWriter, r *http.Request)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"post exists", http.MethodPost, "/records", `{"id":1}`, cache.ErrRecordExists, (*RecordHandler).Post, http.StatusConflict, "record_exists"},
+		{"post storage failure", http.MethodPost, "/records", `{"id":1}`, logErr, (*RecordHandler).Post, http.StatusServiceUnavailable, "storage_unavailable"},
+		{"create ids exhausted", http.MethodPost, "/records", `{}`, cache.ErrIDsExhausted, (*RecordHandler).Post, http.StatusInsufficientStorage, "ids_exhausted"},
+		{"get missing", http.MethodGet, "/records/1", "", cache.ErrRecordNotFound, (*RecordHandler).Get, http.StatusNotFound, "record_not_found"},
+		{"put missing", http.MethodPut, "/records/1", `{"id":1}`, cache.ErrRecordNotFound, (*RecordHandler).Put, http.StatusNotFound, "record_not_found"},
+		{"put id change", http.MethodPut, "/records/1", `{"id":2}`, cache.ErrIDCannotChange, (*RecordHandler).Put, http.StatusBadRequest, "id_cannot_change"},
+		{"put stale", http.MethodPut, "/records/1", `{"id":1}`, cache.ErrVersionMismatch, (*RecordHandler).Put, http.StatusPreconditionFailed, "version_mismatch"},
+		{"put storage failure", http.MethodPut, "/records/1", `{"id":1}`, logErr, (*RecordHandler).Put, http.StatusServiceUnavailable, "storage_unavailable"},
+		{"patch invalid", http.MethodPatch, "/records/1", `{}`, userrecord.ErrInvalidPatch, (*RecordHandler).Patch, http.StatusUnprocessableEntity, "invalid_patch"},
+		{"patch storage failure", http.MethodPatch, "/records/1", `{}`, logErr, (*RecordHandler).Patch, http.StatusServiceUnavailable, "storage_unavailable"},
+		{"delete unknown failure", http.MethodDelete, "/records/1", "", errors.New("boom"), (*RecordHandler).Delete, http.StatusInternalServerError, "internal_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := new(mocks.Cache)
+			handler := New(cache)
+
+			cache.On("Add", mock.Anything, mock.Anything, mock.Anything).Return(tt.cacheErr)
+			cache.On("Create", mock.Anything, mock.Anything).Return(uint64(0), tt.cacheErr)
+			cache.On("Get", mock.Anything, mock.Anything).Return(nil, tt.cacheErr)
+			cache.On("Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(tt.cacheErr)
+			cache.On("Patch", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, tt.cacheErr)
+			cache.On("Delete", mock.Anything, mock.Anything, mock.Anything).Return(tt.cacheErr)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			tt.serve(handler, w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			if !strings.Contains(w.Body.String(), `"code":"`+tt.expectedCode+`"`) {
+				t.Errorf("expected body with code %q, got %q", tt.expectedCode, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSchemaViolations(t *testing.T) {
+	t.Parallel()
+
+	schemaErr := fmt.Errorf("validating record: %w", &userrecord.SchemaError{
+		Violations: []userrecord.Violation{
+			{Path: "/name", Message: "is required"},
+			{Path: "/age", Message: "must be >= 0"},
+		},
+	})
+	expectedBody := `{"title":"Bad Request","status":400,` +
+		`"detail":"validating record: record does not match schema: \"/name\" is required; \"/age\" must be >= 0",` +
+		`"code":"schema_violation","violations":[` +
+		`{"path":"/name","message":"is required"},{"path":"/age","message":"must be >= 0"}]}` + "\n"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		serve  func(h *RecordHandler, w http.ResponseWriter, r *http.Request)
+	}{
+		{"post without id", http.MethodPost, "/records", (*RecordHandler).Post},
+		{"patch", http.MethodPatch, "/records/1", (*RecordHandler).Patch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := new(mocks.Cache)
+			handler := New(cache)
+
+			cache.On("Create", mock.Anything, mock.Anything).Return(uint64(0), schemaErr)
+			cache.On("Patch", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, schemaErr)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"age":-1}`))
+			w := httptest.NewRecorder()
+
+			tt.serve(handler, w, req)
+
+			res := w.Result()
+			body, _ := io.ReadAll(res.Body)
+
+			if res.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d", http.StatusBadRequest, res.StatusCode)
+			}
+
+			if contentType := res.Header.Get("Content-Type"); contentType != problemType {
+				t.Errorf("expected problem content type, got %q", contentType)
+			}
+
+			if string(body) != expectedBody {
+				t.Errorf("expected body %q, got %q", expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestBulk(t *testing.T) {
+	t.Parallel()
+
+	body := `{"id":1}` + "\n" + `{"id":2}` + "\n\n" + `not-json` + "\n" + `{"name":"Carol"}` + "\n"
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			"fail on conflict",
+			"",
+			http.StatusOK,
+			`{"line":1,"id":1,"status":"created"}` + "\n" +
+				`{"line":2,"id":2,"status":"failed","error":"record with id 2: record already exists","code":"record_exists"}` + "\n",
+		},
+		{
+			"skip",
+			"?mode=skip",
+			http.StatusOK,
+			`{"line":1,"id":1,"status":"created"}` + "\n" +
+				`{"line":2,"id":2,"status":"skipped"}` + "\n" +
+				`{"line":4,"status":"failed","error":"invalid request payload: invalid character 'o' in literal null (expecting 'u')","code":"invalid_payload"}` + "\n" +
+				`{"line":5,"id":7,"status":"created"}` + "\n",
+		},
+		{
+			"upsert",
+			"?mode=upsert",
+			http.StatusOK,
+			`{"line":1,"id":1,"status":"created"}` + "\n" +
+				`{"line":2,"id":2,"status":"updated"}` + "\n" +
+				`{"line":4,"status":"failed","error":"invalid request payload: invalid character 'o' in literal null (expecting 'u')","code":"invalid_payload"}` + "\n" +
+				`{"line":5,"id":7,"status":"created"}` + "\n",
+		},
+		{
+			"invalid mode",
+			"?mode=merge",
+			http.StatusBadRequest,
+			problemBody(http.StatusBadRequest, "mode must be fail, skip or upsert", "invalid_parameter"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			recordCache := new(mocks.Cache)
+			handler := New(recordCache)
+
+			recordCache.On("Add", mock.Anything, uint64(1), mock.Anything).Return(nil)
+			recordCache.On("Add", mock.Anything, uint64(2), mock.Anything).
+				Return(fmt.Errorf("record with id 2: %w", cache.ErrRecordExists))
+			recordCache.On("Update", mock.Anything, uint64(2), mock.Anything, mock.Anything).Return(nil)
+			recordCache.On("Create", mock.Anything, mock.Anything).Return(uint64(7), nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/records:bulk"+tt.query, strings.NewReader(body))
+			w := httptest.NewRecorder()
+
+			handler.Bulk(w, req)
+
+			res := w.Result()
+			resBody, _ := io.ReadAll(res.Body)
+
+			if res.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, res.StatusCode)
+			}
+
+			if string(resBody) != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, string(resBody))
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	recordCache := new(mocks.Cache)
+	handler := New(recordCache)
+
+	filters := []cache.Filter{{Field: "name", Value: "Alice"}}
+
+	recordCache.On("List", mock.Anything, cache.ListOptions{Limit: 1000, Filters: filters}).Return(cache.Page{
+		Records:    []userrecord.Record{{"id": uint64(1), "name": "Alice"}},
+		NextCursor: "1",
+	}, nil)
+	recordCache.On("List", mock.Anything, cache.ListOptions{Cursor: "1", Limit: 1000, Filters: filters}).Return(cache.Page{
+		Records: []userrecord.Record{{"id": uint64(4), "name": "Alice"}},
+	}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/records:export?name=Alice&cursor=9&limit=1", nil)
+	w := httptest.NewRecorder()
+
+	handler.Export(w, req)
+
+	res := w.Result()
+	body, _ := io.ReadAll(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("expected NDJSON content type, got %q", contentType)
+	}
+
+	expectedBody := `{"id":1,"name":"Alice"}` + "\n" + `{"id":4,"name":"Alice"}` + "\n"
+	if string(body) != expectedBody {
+		t.Errorf("expected body %q, got %q", expectedBody, string(body))
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	t.Parallel()
+
+	exists := true
+
+	tests := []struct {
+		name           string
+		payload        string
+		expectedOps    []cache.Operation
+		results        []cache.Result
+		applyErr       error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			"committed",
+			`{"operations":[` +
+				`{"op":"create","record":{"name":"Carol"}},` +
+				`{"op":"update","id":1,"record":{"id":1,"name":"Alice"},"precondition":{"version":"\"abc\""}},` +
+				`{"op":"patch","id":1,"patch":[{"op":"remove","path":"/name"}]},` +
+				`{"op":"delete","id":2,"precondition":{"exists":true}}]}`,
+			[]cache.Operation{
+				{Kind: cache.OpCreate, Record: userrecord.Record{"name": "Carol"}},
+				{
+					Kind:         cache.OpUpdate,
+					ID:           1,
+					Record:       userrecord.Record{"id": uint64(1), "name": "Alice"},
+					Precondition: cache.Precondition{Version: "abc"},
+				},
+				{
+					Kind:  cache.OpPatch,
+					ID:    1,
+					Patch: userrecord.JSONPatch{{Op: "remove", Path: "/name"}},
+				},
+				{Kind: cache.OpDelete, ID: 2, Precondition: cache.Precondition{Exists: &exists}},
+			},
+			[]cache.Result{
+				{ID: 3, Record: userrecord.Record{"id": uint64(3), "name": "Carol"}},
+				{ID: 1, Record: userrecord.Record{"id": uint64(1), "name": "Alice"}},
+				{ID: 1, Record: userrecord.Record{"id": uint64(1)}},
+				{ID: 2},
+			},
+			nil,
+			http.StatusOK,
+			`{"results":[{"id":3,"record":{"id":3,"name":"Carol"}},{"id":1,"record":{"id":1,"name":"Alice"}},` +
+				`{"id":1,"record":{"id":1}},{"id":2}]}` + "\n",
+		},
+		{
+			"rolled back",
+			`{"operations":[{"op":"delete","id":2}]}`,
+			[]cache.Operation{{Kind: cache.OpDelete, ID: 2}},
+			nil,
+			&cache.TransactionError{Index: 0, Err: cache.ErrPreconditionFailed},
+			http.StatusPreconditionFailed,
+			`{"title":"Precondition Failed","status":412,"detail":"precondition failed","code":"precondition_failed","operation":0}` + "\n",
+		},
+		{
+			"storage failure",
+			`{"operations":[{"op":"delete","id":2}]}`,
+			[]cache.Operation{{Kind: cache.OpDelete, ID: 2}},
+			nil,
+			errors.New("disk full"),
+			http.StatusInternalServerError,
+			`{"title":"Internal Server Error","status":500,"code":"internal_error"}` + "\n",
+		},
+		{
+			"missing id",
+			`{"operations":[{"op":"create","record":{}},{"op":"delete"}]}`,
+			nil,
+			nil,
+			nil,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"detail":"missing id","code":"missing_id","operation":1}` + "\n",
+		},
+		{
+			"unknown operation",
+			`{"operations":[{"op":"rename","id":1}]}`,
+			nil,
+			nil,
+			nil,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"detail":"op must be create, update, patch or delete","code":"invalid_operation","operation":0}` + "\n",
+		},
+		{
+			"no operations",
+			`{"operations":[]}`,
+			nil,
+			nil,
+			nil,
+			http.StatusBadRequest,
+			problemBody(http.StatusBadRequest, "transaction has no operations", "invalid_transaction"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			recordCache := new(mocks.Cache)
+			handler := New(recordCache)
+
+			recordCache.On("Apply", mock.Anything, tt.expectedOps).Return(tt.results, tt.applyErr)
+
+			req := httptest.NewRequest(http.MethodPost, "/transactions", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			handler.Transaction(w, req)
+
+			res := w.Result()
+			body, _ := io.ReadAll(res.Body)
+
+			if res.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, res.StatusCode)
+			}
+
+			if string(body) != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestCollections(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		payload        string
+		registryErr    error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"create", http.MethodPost, "/collections", `{"name":"teams"}`, nil, http.StatusCreated, `{"name":"teams"}` + "\n"},
+		{
+			"create existing",
+			http.MethodPost,
+			"/collections",
+			`{"name":"teams"}`,
+			collection.ErrCollectionExists,
+			http.StatusConflict,
+			problemBody(http.StatusConflict, "collection already exists", "collection_exists"),
+		},
+		{
+			"create invalid",
+			http.MethodPost,
+			"/collections",
+			`{"name":"Teams"}`,
+			collection.ErrInvalidName,
+			http.StatusBadRequest,
+			problemBody(http.StatusBadRequest, "invalid collection name", "invalid_collection_name"),
+		},
+		{"list", http.MethodGet, "/collections", "", nil, http.StatusOK, `{"collections":["default","teams"]}` + "\n"},
+		{"drop", http.MethodDelete, "/collections/teams", "", nil, http.StatusNoContent, ""},
+		{
+			"drop missing",
+			http.MethodDelete,
+			"/collections/teams",
+			"",
+			collection.ErrCollectionNotFound,
+			http.StatusNotFound,
+			problemBody(http.StatusNotFound, "collection not found", "collection_not_found"),
+		},
+		{
+			"drop default",
+			http.MethodDelete,
+			"/collections/default",
+			"",
+			collection.ErrDropDefault,
+			http.StatusConflict,
+			problemBody(http.StatusConflict, "cannot drop the default collection", "drop_default"),
+		},
+		{
+			"get record",
+			http.MethodGet,
+			"/collections/teams/records/1",
+			"",
+			nil,
+			http.StatusOK,
+			`{"id":1,"name":"Alice"}` + "\n",
+		},
+		{
+			"get record of missing collection",
+			http.MethodGet,
+			"/collections/teams/records/1",
+			"",
+			collection.ErrCollectionNotFound,
+			http.StatusNotFound,
+			problemBody(http.StatusNotFound, "collection not found", "collection_not_found"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			recordCache := new(mocks.Cache)
+			registry := new(collectionmocks.Registry)
+			handler := NewCollectionHandler(registry)
+
+			recordCache.On("Get", mock.Anything, uint64(1)).Return(userrecord.Record{"id": uint64(1), "name": "Alice"}, nil)
+			registry.On("Create", mock.Anything, "teams").Return(tt.registryErr)
+			registry.On("Create", mock.Anything, "Teams").Return(tt.registryErr)
+			registry.On("List").Return([]string{"default", "teams"})
+			registry.On("Drop", mock.Anything).Return(tt.registryErr)
+			registry.On("Get", "teams").Return(recordCache, tt.registryErr)
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /collections", handler.Create)
+			mux.HandleFunc("GET /collections", handler.List)
+			mux.HandleFunc("DELETE /collections/{name}", handler.Drop)
+			mux.HandleFunc("GET /collections/{name}/records/{id}", handler.Records((*RecordHandler).Get))
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, req)
+
+			res := w.Result()
+			body, _ := io.ReadAll(res.Body)
+
+			if res.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, res.StatusCode)
+			}
+
+			if string(body) != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+// problemBody is the problem+json body written for status, detail and code.
+func problemBody(status int, detail, code string) string {
+	return fmt.Sprintf(`{"title":%q,"status":%d,"detail":%q,"code":%q}`, http.StatusText(status), status, detail, code) + "\n"
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/collection"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// problemType is the media type of error responses.
+const problemType = "application/problem+json"
+
+const (
+	// codeInternal is the code of errors that match no known sentinel.
+	codeInternal = "internal_error"
+	// codeStorage is the code of changes that could not be persisted.
+	codeStorage = "storage_unavailable"
+)
+
+var (
+	errInvalidPayload = errors.New("invalid request payload")
+
+	// ErrStarting is reported for requests that arrive while the records are loading.
+	ErrStarting = errors.New("server is starting")
+)
+
+// Problem is an RFC 7807 problem details document with a stable code.
+type Problem struct {
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Code identifies the kind of problem; unlike Detail it never changes wording.
+	Code string `json:"code"`
+	// Violations lists where a record does not match the schema.
+	Violations []userrecord.Violation `json:"violations,omitempty"`
+	// Operation is the index of the transaction operation that failed.
+	Operation *int `json:"operation,omitempty"`
+}
+
+// problemCode maps a sentinel error to the status and code it is reported with.
+type problemCode struct {
+	err    error
+	status int
+	code   string
+}
+
+// problemCodes is searched in order, so wrapped errors match their most specific sentinel.
+var problemCodes = []problemCode{
+	{ErrStarting, http.StatusServiceUnavailable, "starting"},
+	{errInvalidPayload, http.StatusBadRequest, "invalid_payload"},
+	{errUnsupportedPatch, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	{errInvalidLimit, http.StatusBadRequest, "invalid_parameter"},
+	{errInvalidSort, http.StatusBadRequest, "invalid_parameter"},
+	{errInvalidMode, http.StatusBadRequest, "invalid_parameter"},
+	{errNoOperations, http.StatusBadRequest, "invalid_transaction"},
+	{errTooManyOps, http.StatusBadRequest, "invalid_transaction"},
+	{errUnknownOperation, http.StatusBadRequest, "invalid_operation"},
+	{errMissingRecord, http.StatusBadRequest, "invalid_operation"},
+	{errMissingPatch, http.StatusBadRequest, "invalid_operation"},
+	{userrecord.ErrNoID, http.StatusBadRequest, "missing_id"},
+	{userrecord.ErrInvalidID, http.StatusBadRequest, "invalid_id"},
+	{userrecord.ErrSchemaViolation, http.StatusBadRequest, "schema_violation"},
+	{userrecord.ErrInvalidPatch, http.StatusUnprocessableEntity, "invalid_patch"},
+	{userrecord.ErrPatchTestFailed, http.StatusConflict, "patch_test_failed"},
+	{cache.ErrRecordNotFound, http.StatusNotFound, "record_not_found"},
+	{cache.ErrRecordExists, http.StatusConflict, "record_exists"},
+	{cache.ErrIDCannotChange, http.StatusBadRequest, "id_cannot_change"},
+	{cache.ErrPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed"},
+	{cache.ErrVersionMismatch, http.StatusPreconditionFailed, "version_mismatch"},
+	{cache.ErrInvalidCursor, http.StatusBadRequest, "invalid_cursor"},
+	{cache.ErrDuplicateValue, http.StatusConflict, "duplicate_value"},
+	{cache.ErrIDsExhausted, http.StatusInsufficientStorage, "ids_exhausted"},
+	{collection.ErrInvalidName, http.StatusBadRequest, "invalid_collection_name"},
+	{collection.ErrCollectionExists, http.StatusConflict, "collection_exists"},
+	{collection.ErrCollectionNotFound, http.StatusNotFound, "collection_not_found"},
+	{collection.ErrDropDefault, http.StatusConflict, "drop_default"},
+	{cache.ErrClosed, http.StatusServiceUnavailable, "cache_closed"},
+	{cache.ErrLogRecord, http.StatusServiceUnavailable, codeStorage},
+	{cache.ErrSaveRecords, http.StatusServiceUnavailable, codeStorage},
+	{cache.ErrReadRecord, http.StatusServiceUnavailable, codeStorage},
+}
+
+// statusFor maps err to the status and code it is reported with, falling back
+// to 500 Internal Server Error for errors that match no known sentinel.
+func statusFor(err error) (int, string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, "payload_too_large"
+	}
+
+	for _, c := range problemCodes {
+		if errors.Is(err, c.err) {
+			return c.status, c.code
+		}
+	}
+
+	return http.StatusInternalServerError, codeInternal
+}
+
+// problemFor describes err. Server faults get no detail, as it may name files
+// or other internals; WriteProblem logs it instead.
+func problemFor(err error) Problem {
+	status, code := statusFor(err)
+
+	problem := Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+	}
+
+	if !serverFault(code) {
+		problem.Detail = err.Error()
+	}
+
+	var schemaErr *userrecord.SchemaError
+	if errors.As(err, &schemaErr) {
+		problem.Violations = schemaErr.Violations
+	}
+
+	return problem
+}
+
+// serverFault reports whether code is a failure of the server rather than of the request.
+func serverFault(code string) bool {
+	return code == codeInternal || code == codeStorage
+}
+
+// reportProblem describes err like problemFor and logs it if it is a server fault.
+func reportProblem(ctx context.Context, err error) Problem {
+	problem := problemFor(err)
+	if serverFault(problem.Code) {
+		slog.ErrorContext(ctx, "request failed", "code", problem.Code, "err", err)
+	}
+
+	return problem
+}
+
+// WriteProblem responds with the problem details of err.
+func WriteProblem(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(r.Context(), w, reportProblem(r.Context(), err))
+}
+
+// writeProblem responds with problem as application/problem+json.
+func writeProblem(ctx context.Context, w http.ResponseWriter, problem Problem) {
+	w.Header().Set("Content-Type", problemType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	err := encoder.Encode(problem)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to write problem", "err", err)
+	}
+}
+
+// writeJSON responds with status and v encoded as JSON.
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to write response", "err", err)
+	}
+}
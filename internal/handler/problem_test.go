@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/storage"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+func TestProblemFor(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+		expectedDetail string
+	}{
+		{
+			"wrapped sentinel",
+			fmt.Errorf("record with id 1: %w", cache.ErrRecordExists),
+			http.StatusConflict,
+			"record_exists",
+			"record with id 1: record already exists",
+		},
+		{"exported sentinel", userrecord.ErrNoID, http.StatusBadRequest, "missing_id", "missing id"},
+		{
+			"invalid id",
+			fmt.Errorf("%w: must be a non-negative integer", userrecord.ErrInvalidID),
+			http.StatusBadRequest,
+			"invalid_id",
+			"invalid id: must be a non-negative integer",
+		},
+		{"id change", cache.ErrIDCannotChange, http.StatusBadRequest, "id_cannot_change", "cannot change record ID"},
+		{
+			"body too large",
+			fmt.Errorf("%w: %w", errInvalidPayload, &http.MaxBytesError{Limit: 1}),
+			http.StatusRequestEntityTooLarge,
+			"payload_too_large",
+			"invalid request payload: http: request body too large",
+		},
+		{
+			"log failure",
+			fmt.Errorf("logging record with id 1: %w: %w", cache.ErrLogRecord, storage.ErrWriteLog),
+			http.StatusServiceUnavailable,
+			codeStorage,
+			"",
+		},
+		{"save failure", cache.ErrSaveRecords, http.StatusServiceUnavailable, codeStorage, ""},
+		{"read failure", cache.ErrReadRecord, http.StatusServiceUnavailable, codeStorage, ""},
+		{"unknown", errors.New("disk full"), http.StatusInternalServerError, codeInternal, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			problem := problemFor(tt.err)
+
+			if problem.Status != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, problem.Status)
+			}
+
+			if problem.Code != tt.expectedCode {
+				t.Errorf("expected code %q, got %q", tt.expectedCode, problem.Code)
+			}
+
+			if problem.Title != http.StatusText(tt.expectedStatus) {
+				t.Errorf("expected title %q, got %q", http.StatusText(tt.expectedStatus), problem.Title)
+			}
+
+			if problem.Detail != tt.expectedDetail {
+				t.Errorf("expected detail %q, got %q", tt.expectedDetail, problem.Detail)
+			}
+		})
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodGet, "/records/1", nil)
+	w := httptest.NewRecorder()
+
+	WriteProblem(w, req, ErrStarting)
+
+	res := w.Result()
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+
+	if contentType := res.Header.Get("Content-Type"); contentType != problemType {
+		t.Errorf("expected content type %q, got %q", problemType, contentType)
+	}
+
+	expectedBody := problemBody(http.StatusServiceUnavailable, "server is starting", "starting")
+	if w.Body.String() != expectedBody {
+		t.Errorf("expected body %q, got %q", expectedBody, w.Body.String())
+	}
+}
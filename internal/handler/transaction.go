@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// maxTransactionOps is the largest number of operations accepted in one transaction.
+const maxTransactionOps = 1000
+
+var (
+	errNoOperations     = errors.New("transaction has no operations")
+	errTooManyOps       = errors.New("transaction has too many operations")
+	errUnknownOperation = errors.New("op must be create, update, patch or delete")
+	errMissingRecord    = errors.New("missing record")
+	errMissingPatch     = errors.New("missing patch")
+)
+
+// transactionRequest is the body of a POST /transactions request.
+type transactionRequest struct {
+	Operations []transactionOperation `json:"operations"`
+}
+
+// transactionOperation is a single operation of a transaction request.
+// A patch that is a JSON array is a JSON Patch, anything else a merge patch.
+type transactionOperation struct {
+	Op           string                   `json:"op"`
+	ID           *uint64                  `json:"id"`
+	Record       userrecord.Record        `json:"record"`
+	Patch        json.RawMessage          `json:"patch"`
+	Precondition *transactionPrecondition `json:"precondition"`
+}
+
+// transactionPrecondition is the precondition of a transaction operation.
+// Version is a record's ETag, with or without quotes.
+type transactionPrecondition struct {
+	Exists  *bool  `json:"exists"`
+	Version string `json:"version"`
+}
+
+// transactionResult is the outcome of one operation of a committed transaction.
+type transactionResult struct {
+	ID     uint64            `json:"id"`
+	Record userrecord.Record `json:"record,omitempty"`
+}
+
+// transactionResponse is the body of a successful POST /transactions response.
+type transactionResponse struct {
+	Results []transactionResult `json:"results"`
+}
+
+// Transaction handles POST /transactions requests that apply an ordered list
+// of create, update, patch and delete operations atomically.
+func (h *RecordHandler) Transaction(w http.ResponseWriter, r *http.Request) {
+	var req transactionRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		WriteProblem(w, r, fmt.Errorf("%w: %w", errInvalidPayload, err))
+
+		return
+	}
+
+	switch {
+	case len(req.Operations) == 0:
+		WriteProblem(w, r, errNoOperations)
+
+		return
+	case len(req.Operations) > maxTransactionOps:
+		WriteProblem(w, r, errTooManyOps)
+
+		return
+	}
+
+	ops := make([]cache.Operation, 0, len(req.Operations))
+
+	for i, op := range req.Operations {
+		converted, convertErr := op.toCache()
+		if convertErr != nil {
+			writeRollback(w, r, i, convertErr)
+
+			return
+		}
+
+		ops = append(ops, converted)
+	}
+
+	results, err := h.cache.Apply(r.Context(), ops)
+	if err != nil {
+		var txErr *cache.TransactionError
+		if !errors.As(err, &txErr) {
+			WriteProblem(w, r, err)
+
+			return
+		}
+
+		writeRollback(w, r, txErr.Index, txErr.Err)
+
+		return
+	}
+
+	resp := transactionResponse{Results: make([]transactionResult, 0, len(results))}
+	for _, result := range results {
+		resp.Results = append(resp.Results, transactionResult(result))
+	}
+
+	writeJSON(r.Context(), w, http.StatusOK, resp)
+}
+
+// toCache checks the operation and converts it for the cache.
+func (op transactionOperation) toCache() (cache.Operation, error) {
+	converted := cache.Operation{Kind: cache.OpKind(op.Op), Record: op.Record}
+
+	if op.ID != nil {
+		converted.ID = *op.ID
+	}
+
+	if op.Precondition != nil {
+		converted.Precondition = cache.Precondition{
+			Exists:  op.Precondition.Exists,
+			Version: strings.Trim(op.Precondition.Version, `"`),
+		}
+	}
+
+	switch converted.Kind {
+	case cache.OpCreate, cache.OpUpdate:
+		if op.Record == nil {
+			return converted, errMissingRecord
+		}
+
+		if _, hasID := op.Record["id"]; hasID || converted.Kind == cache.OpUpdate {
+			err := op.Record.Validate()
+			if err != nil {
+				return converted, fmt.Errorf("validating record: %w", err)
+			}
+		}
+	case cache.OpPatch:
+		patch, err := decodeTransactionPatch(op.Patch)
+		if err != nil {
+			return converted, err
+		}
+
+		converted.Patch = patch
+	case cache.OpDelete:
+	default:
+		return converted, errUnknownOperation
+	}
+
+	if op.ID == nil && converted.Kind != cache.OpCreate {
+		return converted, userrecord.ErrNoID
+	}
+
+	return converted, nil
+}
+
+// decodeTransactionPatch decodes a JSON array as a JSON Patch and an object as a merge patch.
+func decodeTransactionPatch(data json.RawMessage) (userrecord.Patch, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil, errMissingPatch
+	}
+
+	if data[0] == '[' {
+		var patch userrecord.JSONPatch
+
+		err := json.Unmarshal(data, &patch)
+		if err != nil {
+			return nil, fmt.Errorf("%w: decoding JSON patch: %w", errInvalidPayload, err)
+		}
+
+		return patch, nil
+	}
+
+	var patch userrecord.MergePatch
+
+	err := json.Unmarshal(data, &patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding merge patch: %w", errInvalidPayload, err)
+	}
+
+	return patch, nil
+}
+
+// writeRollback responds with the problem details of err, naming the operation that failed.
+func writeRollback(w http.ResponseWriter, r *http.Request, operation int, err error) {
+	problem := reportProblem(r.Context(), err)
+	problem.Operation = &operation
+
+	writeProblem(r.Context(), w, problem)
+}
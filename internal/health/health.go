@@ -0,0 +1,173 @@
+// Package health reports whether the server is alive and ready to serve requests.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Names of the checks every Checker runs.
+const (
+	CheckStorage  = "storage"
+	CheckShutdown = "shutdown"
+)
+
+// Check results as reported in the readiness response.
+const (
+	statusOK          = "ok"
+	statusFailing     = "failing"
+	statusUnavailable = "unavailable"
+)
+
+var (
+	errLoading      = errors.New("records are still loading")
+	errShuttingDown = errors.New("server is shutting down")
+	errNotWritable  = errors.New("directory is not writable")
+)
+
+// Checker tracks the state of the server and runs the readiness checks.
+type Checker struct {
+	mu           sync.Mutex
+	checks       []check
+	loaded       atomic.Bool
+	shuttingDown atomic.Bool
+}
+
+// check is a named readiness check.
+type check struct {
+	name string
+	run  func() error
+}
+
+// CheckResult is the outcome of a single readiness check.
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the body of a readiness response.
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// NewChecker creates a Checker that is not ready until MarkLoaded is called.
+func NewChecker() *Checker {
+	c := &Checker{}
+
+	c.checks = []check{
+		{name: CheckStorage, run: func() error {
+			if !c.loaded.Load() {
+				return errLoading
+			}
+
+			return nil
+		}},
+		{name: CheckShutdown, run: func() error {
+			if c.shuttingDown.Load() {
+				return errShuttingDown
+			}
+
+			return nil
+		}},
+	}
+
+	return c
+}
+
+// Add adds a readiness check that fails whenever run returns an error.
+func (c *Checker) Add(name string, run func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, check{name: name, run: run})
+}
+
+// MarkLoaded reports that the records have been loaded from storage.
+func (c *Checker) MarkLoaded() {
+	c.loaded.Store(true)
+}
+
+// Shutdown makes the server unready for good, so load balancers stop sending requests.
+func (c *Checker) Shutdown() {
+	c.shuttingDown.Store(true)
+}
+
+// Check runs all checks in the order they were added.
+func (c *Checker) Check() Report {
+	c.mu.Lock()
+	checks := c.checks
+	c.mu.Unlock()
+
+	report := Report{Status: statusOK, Checks: make([]CheckResult, 0, len(checks))}
+
+	for _, ch := range checks {
+		result := CheckResult{Name: ch.name, Status: statusOK}
+
+		err := ch.run()
+		if err != nil {
+			result.Status = statusFailing
+			result.Error = err.Error()
+			report.Status = statusUnavailable
+		}
+
+		report.Checks = append(report.Checks, result)
+	}
+
+	return report
+}
+
+// Live handles GET /healthz requests, which succeed as long as the process serves requests.
+func (*Checker) Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, Report{Status: statusOK, Checks: []CheckResult{}})
+}
+
+// Ready handles GET /readyz requests with the result of every check,
+// failing with 503 Service Unavailable if any of them fails.
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.Check()
+
+	status := http.StatusOK
+	if report.Status != statusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, r, status, report)
+}
+
+// Writable returns a check that fails unless a file can be created in dir.
+func Writable(dir string) func() error {
+	return func() error {
+		file, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return fmt.Errorf("%q: %w", dir, errNotWritable)
+		}
+
+		closeErr := file.Close()
+		removeErr := os.Remove(file.Name())
+
+		if closeErr != nil || removeErr != nil {
+			return fmt.Errorf("%q: %w", dir, errNotWritable)
+		}
+
+		return nil
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to write health report", "err", err)
+	}
+}
@@ -0,0 +1,122 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReady(t *testing.T) {
+	t.Parallel()
+
+	errDisk := errors.New("disk full")
+
+	tests := []struct {
+		name     string
+		setup    func(c *Checker)
+		status   int
+		expected Report
+	}{
+		{
+			name:   "loading",
+			status: http.StatusServiceUnavailable,
+			expected: Report{Status: statusUnavailable, Checks: []CheckResult{
+				{Name: CheckStorage, Status: statusFailing, Error: errLoading.Error()},
+				{Name: CheckShutdown, Status: statusOK},
+			}},
+		},
+		{
+			name:   "ready",
+			setup:  func(c *Checker) { c.MarkLoaded() },
+			status: http.StatusOK,
+			expected: Report{Status: statusOK, Checks: []CheckResult{
+				{Name: CheckStorage, Status: statusOK},
+				{Name: CheckShutdown, Status: statusOK},
+				{Name: "flush", Status: statusOK},
+			}},
+		},
+		{
+			name: "failing check",
+			setup: func(c *Checker) {
+				c.MarkLoaded()
+				c.Add("disk", func() error { return errDisk })
+			},
+			status: http.StatusServiceUnavailable,
+			expected: Report{Status: statusUnavailable, Checks: []CheckResult{
+				{Name: CheckStorage, Status: statusOK},
+				{Name: CheckShutdown, Status: statusOK},
+				{Name: "flush", Status: statusOK},
+				{Name: "disk", Status: statusFailing, Error: errDisk.Error()},
+			}},
+		},
+		{
+			name: "shutting down",
+			setup: func(c *Checker) {
+				c.MarkLoaded()
+				c.Shutdown()
+			},
+			status: http.StatusServiceUnavailable,
+			expected: Report{Status: statusUnavailable, Checks: []CheckResult{
+				{Name: CheckStorage, Status: statusOK},
+				{Name: CheckShutdown, Status: statusFailing, Error: errShuttingDown.Error()},
+				{Name: "flush", Status: statusOK},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := NewChecker()
+			if tt.setup != nil {
+				c.Add("flush", func() error { return nil })
+				tt.setup(c)
+			}
+
+			rec := httptest.NewRecorder()
+			c.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			var report Report
+
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.expected, report)
+		})
+	}
+}
+
+func TestLive(t *testing.T) {
+	t.Parallel()
+
+	c := NewChecker()
+	c.Shutdown()
+
+	rec := httptest.NewRecorder()
+	c.Live(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok","checks":[]}`, rec.Body.String())
+}
+
+func TestWritable(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	require.NoError(t, Writable(dir)())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the probe file must be removed")
+
+	require.ErrorIs(t, Writable(filepath.Join(dir, "missing"))(), errNotWritable)
+}
@@ -0,0 +1,84 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// Option configures the Routes created by New.
+type Option func(*limits)
+
+// limits bound the requests served by Routes.
+type limits struct {
+	maxBodyBytes int64
+	readTimeout  time.Duration
+}
+
+// WithMaxBodyBytes limits the body of every request except bulk imports,
+// which are read one line at a time, to n bytes. Zero means no limit.
+func WithMaxBodyBytes(n int64) Option {
+	return func(l *limits) {
+		l.maxBodyBytes = n
+	}
+}
+
+// WithReadTimeout sets how long a bulk import may wait for the next part of
+// its body. The server's read timeout bounds the whole body of every other
+// request, and is lifted for bulk imports so that they can upload for as long
+// as their body keeps arriving. Zero means no limit.
+func WithReadTimeout(d time.Duration) Option {
+	return func(l *limits) {
+		l.readTimeout = d
+	}
+}
+
+// limit limits the body of requests to next.
+func (l limits) limit(next http.HandlerFunc) http.HandlerFunc {
+	if l.maxBodyBytes <= 0 {
+		return next
+	}
+
+	return http.MaxBytesHandler(next, l.maxBodyBytes).ServeHTTP
+}
+
+// stream lifts the read deadline of requests to next and pushes it back by the
+// read timeout before every read of their body instead.
+func (l limits) stream(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := &deadlineBody{body: r.Body, rc: http.NewResponseController(w), timeout: l.readTimeout}
+		body.extend()
+
+		r.Body = body
+
+		next(w, r)
+	}
+}
+
+// deadlineBody is a request body whose read deadline is pushed back before every read.
+type deadlineBody struct {
+	body    io.ReadCloser
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	b.extend()
+
+	return b.body.Read(p)
+}
+
+func (b *deadlineBody) Close() error {
+	return b.body.Close()
+}
+
+// extend sets the read deadline to the timeout from now, or lifts it if there
+// is no timeout. Connections that do not support deadlines keep their own.
+func (b *deadlineBody) extend() {
+	var deadline time.Time
+	if b.timeout > 0 {
+		deadline = time.Now().Add(b.timeout)
+	}
+
+	_ = b.rc.SetReadDeadline(deadline)
+}
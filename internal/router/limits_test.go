@@ -0,0 +1,91 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zabbix-technical-task/internal/health"
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/collection"
+	"zabbix-technical-task/pkg/metrics"
+	"zabbix-technical-task/pkg/storage"
+)
+
+const (
+	testMaxBodyBytes = 1024
+	testReadTimeout  = 200 * time.Millisecond
+)
+
+// newLimitedServer serves the routes of an in-memory cache with small limits,
+// and the read timeout of the server set to testReadTimeout.
+func newLimitedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	records := cache.New(t.Context(), storage.NewMemoryStorage())
+	require.NotNil(t, records)
+
+	collections := collection.NewFileRegistry(t.TempDir(), collection.WithDefault(records),
+		collection.WithBackend(storage.BackendMemory))
+	routes := New(records, collections, metrics.NewRegistry(), health.NewChecker(),
+		WithMaxBodyBytes(testMaxBodyBytes), WithReadTimeout(testReadTimeout))
+
+	srv := httptest.NewUnstartedServer(routes.Handler)
+	srv.Config.ReadTimeout = testReadTimeout
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	t.Parallel()
+
+	srv := newLimitedServer(t)
+	body := fmt.Sprintf(`{"name":%q}`, strings.Repeat("x", testMaxBodyBytes))
+
+	res, err := http.Post(srv.URL+"/records", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
+
+func TestBulkImportIsNotLimited(t *testing.T) {
+	t.Parallel()
+
+	srv := newLimitedServer(t)
+	reader, writer := io.Pipe()
+
+	// The body is larger than testMaxBodyBytes and takes longer than
+	// testReadTimeout to arrive, but no part of it is late.
+	const lines = 8
+
+	go func() {
+		for i := range lines {
+			time.Sleep(testReadTimeout / 4)
+
+			_, _ = fmt.Fprintf(writer, "{\"name\":%q}\n", strings.Repeat("x", testMaxBodyBytes/2+i))
+		}
+
+		_ = writer.Close()
+	}()
+
+	res, err := http.Post(srv.URL+"/records:bulk", "application/x-ndjson", reader)
+	require.NoError(t, err)
+
+	defer res.Body.Close()
+
+	results, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, lines, strings.Count(string(results), `"status":"created"`), string(results))
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"zabbix-technical-task/pkg/metrics"
+)
+
+// unmatchedRoute labels requests that match no route.
+const unmatchedRoute = "unmatched"
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int
+}
+
+// instrument counts the requests served by next and measures their latency,
+// labelled by the method and path of the matched route and the response status.
+func instrument(reg *metrics.Registry, next http.Handler) http.Handler {
+	requests := reg.Counter("http_requests_total", "HTTP requests served.", "method", "route", "status")
+	latency := reg.Histogram("http_request_duration_seconds", "Latency of HTTP requests.",
+		metrics.DefaultBuckets, "method", "route")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		// The mux sets the pattern of the matched route on the request.
+		route := unmatchedRoute
+		if r.Pattern != "" {
+			_, path, _ := strings.Cut(r.Pattern, " ")
+			route = path
+		}
+
+		requests.Inc(r.Method, route, strconv.Itoa(rec.status))
+		latency.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
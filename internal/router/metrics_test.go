@@ -0,0 +1,40 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zabbix-technical-task/pkg/metrics"
+)
+
+func TestInstrument(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /records/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "1" {
+			http.NotFound(w, r)
+		}
+	})
+
+	reg := metrics.NewRegistry()
+	handler := instrument(reg, mux)
+
+	for _, target := range []string{"/records/1", "/records/1", "/records/2", "/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	var out strings.Builder
+
+	_, err := reg.WriteTo(&out)
+	require.NoError(t, err)
+
+	assert.Contains(t, out.String(), `http_requests_total{method="GET",route="/records/{id}",status="200"} 2`+"\n")
+	assert.Contains(t, out.String(), `http_requests_total{method="GET",route="/records/{id}",status="404"} 1`+"\n")
+	assert.Contains(t, out.String(), `http_requests_total{method="GET",route="unmatched",status="404"} 1`+"\n")
+	assert.Contains(t, out.String(), `http_request_duration_seconds_count{method="GET",route="/records/{id}"} 3`+"\n")
+}
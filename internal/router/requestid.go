@@ -0,0 +1,57 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"zabbix-technical-task/pkg/logging"
+)
+
+const (
+	// requestIDHeader carries the request ID in requests and responses.
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength is the longest request ID accepted from a client.
+	maxRequestIDLength = 128
+)
+
+// withRequestID passes the X-Request-ID of every request, or a new one if the
+// request has none or an unusable one, to next in the request's context and
+// echoes it in the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID reports whether id is short and made of printable ASCII
+// characters only, so it can be logged and echoed safely.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := range len(id) {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns a random 128-bit request ID in hex.
+func newRequestID() string {
+	var b [16]byte
+
+	_, _ = rand.Read(b[:])
+
+	return hex.EncodeToString(b[:])
+}
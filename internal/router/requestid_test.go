@@ -0,0 +1,55 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"zabbix-technical-task/pkg/logging"
+)
+
+func TestWithRequestID(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		header   string
+		expected string // empty if a new ID must be generated
+	}{
+		{name: "propagated", header: "abc-123", expected: "abc-123"},
+		{name: "missing", header: ""},
+		{name: "too long", header: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "unprintable", header: "abc\x01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var seen string
+
+			handler := withRequestID(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				seen = logging.RequestID(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/records/1", nil)
+			if tt.header != "" {
+				req.Header.Set(requestIDHeader, tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, seen, rec.Header().Get(requestIDHeader))
+
+			if tt.expected != "" {
+				assert.Equal(t, tt.expected, seen)
+			} else {
+				assert.Len(t, seen, 32)
+				assert.NotEqual(t, tt.header, seen)
+			}
+		})
+	}
+}
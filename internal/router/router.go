@@ -4,26 +4,90 @@ import (
 	"net/http"
 
 	"zabbix-technical-task/internal/handler"
+	"zabbix-technical-task/internal/health"
 	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/collection"
+	"zabbix-technical-task/pkg/metrics"
 )
 
 // Routes holds the HTTP request multiplexer.
 type Routes struct {
 	Mux *http.ServeMux
+	// Handler serves the Mux, records metrics of every request and
+	// tags it with a request ID.
+	Handler http.Handler
 }
 
-// New creates a new Routes instance with the given record cache.
-func New(records cache.Cache) Routes {
+// New creates a new Routes instance serving records from the default
+// collection under /records, every collection under /collections,
+// the metrics in reg under /metrics and the health probes of checker.
+func New(records cache.Cache, collections collection.Registry, reg *metrics.Registry, checker *health.Checker,
+	opts ...Option,
+) Routes {
+	var l limits
+	for _, opt := range opts {
+		opt(&l)
+	}
+
 	mux := http.NewServeMux()
 
 	recordHandler := handler.New(records)
 
-	mux.HandleFunc("POST /records", recordHandler.Post)
-	mux.HandleFunc("GET /records/", recordHandler.Get)
-	mux.HandleFunc("PUT /records/", recordHandler.Put)
-	mux.HandleFunc("DELETE /records/", recordHandler.Delete)
+	mux.HandleFunc("POST /records", l.limit(recordHandler.Post))
+	mux.HandleFunc("GET /records", recordHandler.List)
+	mux.HandleFunc("POST /records:bulk", l.stream(recordHandler.Bulk))
+	mux.HandleFunc("GET /records:export", recordHandler.Export)
+	mux.HandleFunc("GET /records/{id}", recordHandler.Get)
+	mux.HandleFunc("PUT /records/{id}", l.limit(recordHandler.Put))
+	mux.HandleFunc("PATCH /records/{id}", l.limit(recordHandler.Patch))
+	mux.HandleFunc("DELETE /records/{id}", recordHandler.Delete)
+	mux.HandleFunc("POST /transactions", l.limit(recordHandler.Transaction))
+
+	collectionHandler := handler.NewCollectionHandler(collections)
+	inCollection := collectionHandler.Records
+
+	mux.HandleFunc("POST /collections", l.limit(collectionHandler.Create))
+	mux.HandleFunc("GET /collections", collectionHandler.List)
+	mux.HandleFunc("DELETE /collections/{name}", collectionHandler.Drop)
+	mux.HandleFunc("POST /collections/{name}/records", l.limit(inCollection((*handler.RecordHandler).Post)))
+	mux.HandleFunc("GET /collections/{name}/records", inCollection((*handler.RecordHandler).List))
+	mux.HandleFunc("POST /collections/{name}/records:bulk", l.stream(inCollection((*handler.RecordHandler).Bulk)))
+	mux.HandleFunc("GET /collections/{name}/records:export", inCollection((*handler.RecordHandler).Export))
+	mux.HandleFunc("GET /collections/{name}/records/{id}", inCollection((*handler.RecordHandler).Get))
+	mux.HandleFunc("PUT /collections/{name}/records/{id}", l.limit(inCollection((*handler.RecordHandler).Put)))
+	mux.HandleFunc("PATCH /collections/{name}/records/{id}", l.limit(inCollection((*handler.RecordHandler).Patch)))
+	mux.HandleFunc("DELETE /collections/{name}/records/{id}", inCollection((*handler.RecordHandler).Delete))
+	mux.HandleFunc("POST /collections/{name}/transactions", l.limit(inCollection((*handler.RecordHandler).Transaction)))
+
+	handleProbes(mux, reg, checker)
 
 	return Routes{
-		Mux: mux,
+		Mux:     mux,
+		Handler: withRequestID(instrument(reg, mux)),
 	}
 }
+
+// Starting creates the Routes served while the records are loading: the
+// health probes and metrics, with every other request refused as unavailable.
+func Starting(reg *metrics.Registry, checker *health.Checker) Routes {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		handler.WriteProblem(w, r, handler.ErrStarting)
+	})
+
+	handleProbes(mux, reg, checker)
+
+	return Routes{
+		Mux:     mux,
+		Handler: withRequestID(instrument(reg, mux)),
+	}
+}
+
+// handleProbes registers the health probes and metrics.
+func handleProbes(mux *http.ServeMux, reg *metrics.Registry, checker *health.Checker) {
+	mux.HandleFunc("GET /healthz", checker.Live)
+	mux.HandleFunc("GET /readyz", checker.Ready)
+	mux.Handle("GET /metrics", reg)
+}
@@ -0,0 +1,29 @@
+package router
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Switch serves every request with the handler it was last set to,
+// letting the server start listening before all of its routes exist.
+type Switch struct {
+	current atomic.Pointer[http.Handler]
+}
+
+// NewSwitch creates a Switch serving handler.
+func NewSwitch(handler http.Handler) *Switch {
+	s := &Switch{}
+	s.Set(handler)
+
+	return s
+}
+
+// Set makes handler serve all further requests.
+func (s *Switch) Set(handler http.Handler) {
+	s.current.Store(&handler)
+}
+
+func (s *Switch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.current.Load()).ServeHTTP(w, r)
+}
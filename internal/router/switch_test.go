@@ -0,0 +1,36 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"zabbix-technical-task/internal/health"
+	"zabbix-technical-task/pkg/metrics"
+)
+
+func TestSwitch(t *testing.T) {
+	t.Parallel()
+
+	checker := health.NewChecker()
+	sw := NewSwitch(Starting(metrics.NewRegistry(), checker).Handler)
+
+	serve := func(target string) int {
+		rec := httptest.NewRecorder()
+		sw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve("/records/1"))
+	assert.Equal(t, http.StatusOK, serve("/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve("/readyz"))
+
+	sw.Set(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	checker.MarkLoaded()
+
+	assert.Equal(t, http.StatusNoContent, serve("/records/1"))
+}
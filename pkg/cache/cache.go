@@ -1,10 +1,17 @@
 package cache
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"zabbix-technical-task/pkg/metrics"
 	"zabbix-technical-task/pkg/storage"
 	"zabbix-technical-task/pkg/userrecord"
 )
@@ -12,83 +19,252 @@ import (
 var _ Cache = (*RecordCache)(nil)
 
 // RecordCache provides thread-safe access to a cache of records.
+// Changes are persisted in the background by a flusher goroutine
+// that is stopped by Close.
 type RecordCache struct {
 	mu      sync.RWMutex
 	records map[uint64]userrecord.Record
-	counter uint8
+	ids     []uint64 // keys of records in ascending order
+	lastID  uint64   // highest id ever stored, the base for new ids
 	storage storage.Storage
+	indexes map[string]*index // secondary indexes by field name
+	closed  bool              // writes are refused once the cache is closed or discarded
+
+	// resident holds the records in memory if the cache is bounded, in which
+	// case records is unused and the other records are read from engine.
+	resident   *resident
+	engine     storage.Engine
+	maxRecords int
+	eviction   EvictionPolicy
+
+	// flushMu serializes flushes, which run without holding mu for long.
+	flushMu        sync.Mutex
+	dirty          atomic.Int64
+	flushErr       atomic.Pointer[error] // error of the last flush, nil if it succeeded
+	flushInterval  time.Duration
+	flushThreshold int
+	flushNow       chan struct{}
+	done           chan struct{}
+	closeOnce      sync.Once
+	wg             sync.WaitGroup
+
+	metrics    *metrics.Registry
+	collection string // label of the cache's metrics
+	// hits, misses and evictions count the lookups of a bounded cache; nil if metrics are disabled.
+	hits      *metrics.Counter
+	misses    *metrics.Counter
+	evictions *metrics.Counter
 }
 
-// New creates a new RecordCache instance.
-func New(recordsStorage storage.Storage) *RecordCache {
-	records := make(map[uint64]userrecord.Record)
+// Option configures a RecordCache.
+type Option func(*RecordCache)
 
-	err := recordsStorage.Init(records)
+// WithFlushInterval sets how often dirty records are persisted.
+// Zero disables time-based flushing.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(r *RecordCache) {
+		r.flushInterval = interval
+	}
+}
+
+// WithFlushThreshold sets how many unsaved changes trigger a flush.
+// Zero disables count-based flushing.
+func WithFlushThreshold(threshold int) Option {
+	return func(r *RecordCache) {
+		r.flushThreshold = threshold
+	}
+}
+
+// New creates a new RecordCache instance and starts its background flusher.
+func New(ctx context.Context, recordsStorage storage.Storage, opts ...Option) *RecordCache {
+	r := &RecordCache{
+		records:        make(map[uint64]userrecord.Record),
+		storage:        recordsStorage,
+		indexes:        make(map[string]*index),
+		flushInterval:  defaultFlushInterval,
+		flushThreshold: defaultFlushThreshold,
+		flushNow:       make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.maxRecords > 0 {
+		engine, ok := recordsStorage.(storage.Engine)
+		if ok {
+			r.engine = engine
+			r.resident = newResident(r.maxRecords, r.eviction)
+		} else {
+			slog.WarnContext(ctx, "storage cannot read single records, keeping all of them in memory",
+				"maxRecords", r.maxRecords)
+		}
+	}
+
+	err := r.load(ctx)
 	if err != nil {
-		log.Printf("error initializing storage: %v\n", err)
+		slog.ErrorContext(ctx, "failed to load records", "err", err)
 
 		return nil
 	}
 
-	return &RecordCache{
-		records: records,
-		storage: recordsStorage,
-		counter: 0,
+	if sequencer, ok := recordsStorage.(storage.Sequencer); ok {
+		r.lastID = sequencer.Sequence()
 	}
+
+	if len(r.ids) > 0 {
+		r.lastID = max(r.lastID, r.ids[len(r.ids)-1])
+	}
+
+	r.registerMetrics()
+
+	r.wg.Add(1)
+
+	go r.runFlusher()
+
+	return r
+}
+
+// load reads the ids of all records and indexes them. An unbounded cache
+// keeps all records; a bounded one opens its engine and keeps as many
+// records as it may hold.
+func (r *RecordCache) load(ctx context.Context) error {
+	if r.engine == nil {
+		err := r.storage.Init(ctx, r.records)
+		if err != nil {
+			return fmt.Errorf("initializing storage: %w", err)
+		}
+
+		r.ids = slices.Sorted(maps.Keys(r.records))
+
+		return r.buildIndexes()
+	}
+
+	err := r.engine.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("opening storage: %w", err)
+	}
+
+	// The engine scans in ascending id order, so the ids end up sorted, and
+	// the records with the lowest ids are kept.
+	return r.engine.Scan(ctx, func(id uint64, record userrecord.Record) error {
+		r.ids = append(r.ids, id)
+		if len(r.ids) <= r.maxRecords {
+			r.resident.put(id, record, false)
+		}
+
+		return r.addToIndexes(id, record)
+	})
 }
 
 // Add adds a new record to the cache.
-func (r *RecordCache) Add(id uint64, record userrecord.Record) error {
+func (r *RecordCache) Add(ctx context.Context, id uint64, record userrecord.Record) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	_, exists := r.records[id]
-	if exists {
-		return fmt.Errorf("record with id %d: %w", id, errRecordExists)
+	if r.closed {
+		return ErrClosed
 	}
 
-	if r.counter >= maxUnbackedRecords {
-		log.Println("cache limit reached")
+	if r.exists(id) {
+		return fmt.Errorf("record with id %d: %w", id, ErrRecordExists)
+	}
 
-		err := r.storage.Save(r.records)
-		if err != nil {
-			return fmt.Errorf("writing records to file: %w", errSaveRecords)
-		}
+	err := r.checkUnique(id, record)
+	if err != nil {
+		return err
+	}
 
-		r.counter = 0
+	err = r.storage.Append(ctx, storage.Entry{Op: storage.OpAdd, ID: id, Record: record})
+	if err != nil {
+		return fmt.Errorf("logging record with id %d: %w: %w", id, ErrLogRecord, err)
+	}
 
-		r.records[id] = record
+	r.store(id, record)
+	r.insertID(id)
+	r.indexRecord(id, record)
+	r.lastID = max(r.lastID, id)
+	r.markDirty()
 
-		return nil
+	return nil
+}
+
+// Create adds a new record under the next unused id, sets it as the record's
+// id and returns it. A record that is not added is left without an id. Ids are
+// never handed out twice, even after deletion.
+func (r *RecordCache) Create(ctx context.Context, record userrecord.Record) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, ErrClosed
 	}
 
-	r.records[id] = record
-	r.counter++
+	if r.lastID == math.MaxUint64 {
+		return 0, ErrIDsExhausted
+	}
 
-	return nil
+	id := r.lastID + 1
+	record["id"] = id
+
+	err := record.ValidateSchema()
+	if err != nil {
+		delete(record, "id")
+
+		return 0, fmt.Errorf("validating record: %w", err)
+	}
+
+	err = r.checkUnique(id, record)
+	if err != nil {
+		delete(record, "id")
+
+		return 0, err
+	}
+
+	err = r.storage.Append(ctx, storage.Entry{Op: storage.OpAdd, ID: id, Record: record})
+	if err != nil {
+		delete(record, "id")
+
+		return 0, fmt.Errorf("logging record with id %d: %w: %w", id, ErrLogRecord, err)
+	}
+
+	r.store(id, record)
+	r.insertID(id)
+	r.indexRecord(id, record)
+	r.lastID = id
+	r.markDirty()
+
+	return id, nil
 }
 
-// Get retrieves a record by ID from the cache.
-func (r *RecordCache) Get(id uint64) (userrecord.Record, error) {
+// Get retrieves a record by ID from the cache, or from storage if the cache
+// is bounded and does not hold it.
+func (r *RecordCache) Get(ctx context.Context, id uint64) (userrecord.Record, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	record, exists := r.records[id]
-	if !exists {
-		return nil, fmt.Errorf("record with id %d: %w", id, errRecordNotFound)
-	}
-
-	return record, nil
+	return r.record(ctx, id)
 }
 
-// Update updates an existing record in the cache.
-func (r *RecordCache) Update(id uint64, record userrecord.Record) error {
+// Update updates an existing record in the cache. If versions is not empty,
+// the record is only updated if its current version is one of them.
+func (r *RecordCache) Update(ctx context.Context, id uint64, record userrecord.Record, versions []string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	_, exists := r.records[id]
-	if !exists {
-		return fmt.Errorf("record with id %d: %w", id, errRecordNotFound)
+	if r.closed {
+		return ErrClosed
+	}
+
+	current, err := r.record(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = checkVersion(id, current, versions)
+	if err != nil {
+		return err
 	}
 
 	baseID, err := record.ID()
@@ -97,37 +273,298 @@ func (r *RecordCache) Update(id uint64, record userrecord.Record) error {
 	}
 
 	if id != baseID {
-		return fmt.Errorf("cannot change record's id from %d to %d: %w", id, baseID, errIDCannotChange)
+		return fmt.Errorf("cannot change record's id from %d to %d: %w", id, baseID, ErrIDCannotChange)
+	}
+
+	err = r.checkUnique(id, record)
+	if err != nil {
+		return err
+	}
+
+	err = r.storage.Append(ctx, storage.Entry{Op: storage.OpUpdate, ID: id, Record: record})
+	if err != nil {
+		return fmt.Errorf("logging record with id %d: %w: %w", id, ErrLogRecord, err)
 	}
 
-	r.records[id] = record
+	r.unindexRecord(id, current)
+	r.store(id, record)
+	r.indexRecord(id, record)
+	r.markDirty()
 
 	return nil
 }
 
-// Delete removes a record by ID from the cache.
-func (r *RecordCache) Delete(id uint64) error {
+// Patch atomically applies patch to an existing record and returns the result.
+// Like Update, it refuses to change or remove the record's id and honours versions.
+func (r *RecordCache) Patch(ctx context.Context, id uint64, patch userrecord.Patch, versions []string) (userrecord.Record, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	_, exists := r.records[id]
-	if !exists {
-		return fmt.Errorf("record with id %d: %w", id, errRecordNotFound)
+	if r.closed {
+		return nil, ErrClosed
+	}
+
+	current, err := r.record(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkVersion(id, current, versions)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := applyPatch(id, current, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.checkUnique(id, record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.storage.Append(ctx, storage.Entry{Op: storage.OpUpdate, ID: id, Record: record})
+	if err != nil {
+		return nil, fmt.Errorf("logging record with id %d: %w: %w", id, ErrLogRecord, err)
 	}
 
-	delete(r.records, id)
+	r.unindexRecord(id, current)
+	r.store(id, record)
+	r.indexRecord(id, record)
+	r.markDirty()
+
+	return record, nil
+}
+
+// Delete removes a record by ID from the cache. If versions is not empty,
+// the record is only deleted if its current version is one of them.
+func (r *RecordCache) Delete(ctx context.Context, id uint64, versions []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return ErrClosed
+	}
+
+	current, err := r.record(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = checkVersion(id, current, versions)
+	if err != nil {
+		return err
+	}
+
+	err = r.storage.Append(ctx, storage.Entry{Op: storage.OpDelete, ID: id})
+	if err != nil {
+		return fmt.Errorf("logging deletion of record with id %d: %w: %w", id, ErrLogRecord, err)
+	}
+
+	r.drop(id)
+	r.removeID(id)
+	r.unindexRecord(id, current)
+	r.markDirty()
 
 	return nil
 }
 
 // SaveRecords saves all records from the cache to persistent storage.
-func (r *RecordCache) SaveRecords() error {
+func (r *RecordCache) SaveRecords(ctx context.Context) error {
+	return r.flush(ctx, true)
+}
+
+// Close stops the background flusher, refuses further writes with ErrClosed
+// and performs a final flush, which is abandoned if ctx is done first. It is
+// safe to call Close more than once.
+func (r *RecordCache) Close(ctx context.Context) error {
+	r.stop()
+
+	return r.SaveRecords(ctx)
+}
+
+// Discard stops the background flusher and refuses further writes with
+// ErrClosed, like Close, but does not save the records, for a cache whose
+// storage is about to be deleted. It returns once the writes in progress
+// are done.
+func (r *RecordCache) Discard() {
+	r.stop()
+}
+
+// stop stops the background flusher and waits for the writes in progress
+// before refusing any more.
+func (r *RecordCache) stop() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.unregisterMetrics()
+	})
+
+	r.wg.Wait()
+
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+}
+
+// FlushErr returns the error of the last flush, or nil if it succeeded.
+func (r *RecordCache) FlushErr() error {
+	err := r.flushErr.Load()
+	if err == nil {
+		return nil
+	}
+
+	return *err
+}
+
+// markDirty counts an unsaved change and wakes the flusher once the threshold
+// is reached. The caller must hold the write lock.
+func (r *RecordCache) markDirty() {
+	dirty := r.dirty.Add(1)
+
+	if r.flushThreshold > 0 && dirty >= int64(r.flushThreshold) {
+		select {
+		case r.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runFlusher persists dirty records on every tick or threshold signal until Close.
+func (r *RecordCache) runFlusher() {
+	defer r.wg.Done()
+
+	var tick <-chan time.Time
+
+	if r.flushInterval > 0 {
+		ticker := time.NewTicker(r.flushInterval)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-tick:
+		case <-r.flushNow:
+		}
+
+		ctx := context.Background()
+
+		err := r.flush(ctx, false)
+		if err != nil {
+			slog.ErrorContext(ctx, "background flush failed", "err", err)
+		}
+	}
+}
+
+// flush saves all records to storage. Unless forced, it does nothing when there
+// are no unsaved changes. Changes made while the records are being saved stay
+// counted as unsaved.
+func (r *RecordCache) flush(ctx context.Context, force bool) error {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+
+	dirty := r.dirty.Load()
+	if !force && dirty == 0 {
+		return nil
+	}
+
+	err := r.persist(ctx)
+	if err != nil {
+		err = fmt.Errorf("saving records to file: %w: %w", ErrSaveRecords, err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = fmt.Errorf("saving records to file: %w", ctxErr)
+		}
+
+		r.flushErr.Store(&err)
+
+		return err
+	}
+
+	r.dirty.Add(-dirty)
+	r.flushErr.Store(nil)
+
+	return nil
+}
+
+// persist writes the records to storage. A storage that can take a checkpoint
+// is saved from a copy of the records, so that writers are only blocked while
+// it is made; any other storage is saved while holding the read lock.
+func (r *RecordCache) persist(ctx context.Context) error {
+	// A bounded cache has written every change through to its engine already.
+	if r.engine != nil {
+		return r.engine.Flush(ctx)
+	}
+
+	checkpointer, ok := r.storage.(storage.Checkpointer)
+	if !ok {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		return r.storage.Save(ctx, r.records)
+	}
+
+	// Writers append to storage under the write lock, so the copy holds
+	// exactly the changes logged before the checkpoint.
 	r.mu.RLock()
-	defer r.mu.RUnlock()
+	records := maps.Clone(r.records)
+	checkpoint, err := checkpointer.Checkpoint(ctx)
+	r.mu.RUnlock()
+
+	if err != nil {
+		return fmt.Errorf("checkpointing storage: %w", err)
+	}
+
+	return checkpointer.SaveCheckpoint(ctx, records, checkpoint)
+}
+
+// applyPatch returns current patched by patch, refusing results that
+// change or remove the id or do not validate.
+func applyPatch(id uint64, current userrecord.Record, patch userrecord.Patch) (userrecord.Record, error) {
+	record, err := patch.Apply(current)
+	if err != nil {
+		return nil, fmt.Errorf("patching record with id %d: %w", id, err)
+	}
+
+	_, hasID := record["id"]
+	if !hasID {
+		return nil, fmt.Errorf("cannot remove record's id %d: %w", id, ErrIDCannotChange)
+	}
+
+	err = record.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("validating patched record: %w", err)
+	}
+
+	baseID, err := record.ID()
+	if err != nil {
+		return nil, fmt.Errorf("getting record ID: %w", err)
+	}
+
+	if id != baseID {
+		return nil, fmt.Errorf("cannot change record's id from %d to %d: %w", id, baseID, ErrIDCannotChange)
+	}
+
+	return record, nil
+}
+
+// checkVersion returns ErrVersionMismatch unless versions is empty
+// or contains the version of record.
+func checkVersion(id uint64, record userrecord.Record, versions []string) error {
+	if len(versions) == 0 {
+		return nil
+	}
 
-	err := r.storage.Save(r.records)
+	version, err := record.Version()
 	if err != nil {
-		return fmt.Errorf("saving records to file: %w", errSaveRecords)
+		return fmt.Errorf("getting version of record with id %d: %w", id, err)
+	}
+
+	if !slices.Contains(versions, version) {
+		return fmt.Errorf("record with id %d is at version %s: %w", id, version, ErrVersionMismatch)
 	}
 
 	return nil
@@ -1,12 +1,21 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
+	"zabbix-technical-task/pkg/metrics"
+	"zabbix-technical-task/pkg/storage"
 	"zabbix-technical-task/pkg/storage/mocks"
 	"zabbix-technical-task/pkg/userrecord"
 )
@@ -16,9 +25,9 @@ func TestNew(t *testing.T) {
 
 	mockStorage := new(mocks.Storage)
 
-	mockStorage.On("Init", mock.Anything).Return(nil).Once()
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil).Once()
 
-	cache := New(mockStorage)
+	cache := New(t.Context(), mockStorage)
 
 	if cache == nil {
 		t.Fatal("expected non-nil cache")
@@ -28,15 +37,15 @@ func TestNew(t *testing.T) {
 		t.Fatalf("expected empty records map, got %d records", len(cache.records))
 	}
 
-	mockStorage.On("Init", mock.Anything).Return(errors.New("Some Init error")).Once()
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(errors.New("Some Init error")).Once()
 
-	cache = New(mockStorage)
+	cache = New(t.Context(), mockStorage)
 
 	if cache != nil {
 		t.Fatal("expected non-nil cache even on Init error")
 	}
 
-	mockStorage.AssertCalled(t, "Init", mock.Anything)
+	mockStorage.AssertCalled(t, "Init", mock.Anything, mock.Anything)
 }
 
 func TestAdd(t *testing.T) {
@@ -44,11 +53,10 @@ func TestAdd(t *testing.T) {
 
 	mockStorage := new(mocks.Storage)
 
-	mockStorage.On("Init", mock.Anything).Return(nil)
-	mockStorage.On("Save", mock.Anything).Return(nil).Once()
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
 
-	cache := New(mockStorage)
-	cache.counter = 48 // Set counter close to limit for testing
+	cache := New(t.Context(), mockStorage, WithFlushInterval(0), WithFlushThreshold(0))
 
 	record := userrecord.Record{
 		"id":    1,
@@ -57,32 +65,27 @@ func TestAdd(t *testing.T) {
 		"Age":   30,
 	}
 
-	err := cache.Add(1, record)
+	err := cache.Add(t.Context(), 1, record)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	err = cache.Add(1, record)
+	err = cache.Add(t.Context(), 1, record)
 	if err == nil {
 		t.Fatalf("expected error for duplicate record, got nil")
 	}
 
-	err = cache.Add(2, record)
+	err = cache.Add(t.Context(), 2, record)
 	if err != nil {
-		t.Fatalf("expected no error when adding record after save, got %v", err)
+		t.Fatalf("expected no error when adding second record, got %v", err)
 	}
 
-	mockStorage.On("Save", mock.Anything).Return(errors.New("disk full")).Once()
-
-	cache.counter = 49
-
-	err = cache.Add(3, record)
-	if err == nil {
-		t.Fatalf("expected error due to storage save failure, got nil")
+	if cache.dirty.Load() != 2 {
+		t.Errorf("expected 2 unsaved changes, got %d", cache.dirty.Load())
 	}
 
-	mockStorage.AssertCalled(t, "Init", mock.Anything)
-	mockStorage.AssertNumberOfCalls(t, "Save", 2)
+	mockStorage.AssertCalled(t, "Init", mock.Anything, mock.Anything)
+	mockStorage.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
 }
 
 func TestGet(t *testing.T) {
@@ -90,9 +93,10 @@ func TestGet(t *testing.T) {
 
 	mockStorage := new(mocks.Storage)
 
-	mockStorage.On("Init", mock.Anything).Return(nil)
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
 
-	cache := New(mockStorage)
+	cache := New(t.Context(), mockStorage)
 
 	record := userrecord.Record{
 		"id":    1,
@@ -101,12 +105,12 @@ func TestGet(t *testing.T) {
 		"Age":   30,
 	}
 
-	err := cache.Add(1, record)
+	err := cache.Add(t.Context(), 1, record)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	got, err := cache.Get(1)
+	got, err := cache.Get(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -115,12 +119,12 @@ func TestGet(t *testing.T) {
 		t.Errorf("expected Name to be 'John Doe', got %v", got["Name"])
 	}
 
-	_, err = cache.Get(2)
+	_, err = cache.Get(t.Context(), 2)
 	if err == nil {
 		t.Fatalf("expected error for non-existent record, got nil")
 	}
 
-	mockStorage.AssertCalled(t, "Init", mock.Anything)
+	mockStorage.AssertCalled(t, "Init", mock.Anything, mock.Anything)
 }
 
 func TestUpdate(t *testing.T) {
@@ -128,9 +132,10 @@ func TestUpdate(t *testing.T) {
 
 	mockStorage := new(mocks.Storage)
 
-	mockStorage.On("Init", mock.Anything).Return(nil)
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
 
-	cache := New(mockStorage)
+	cache := New(t.Context(), mockStorage)
 
 	record := userrecord.Record{
 		"id":    uint64(1),
@@ -139,7 +144,7 @@ func TestUpdate(t *testing.T) {
 		"Age":   30,
 	}
 
-	err := cache.Add(1, record)
+	err := cache.Add(t.Context(), 1, record)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -153,12 +158,12 @@ func TestUpdate(t *testing.T) {
 
 	log.Println(updatedRecord.ID())
 
-	err = cache.Update(1, updatedRecord)
+	err = cache.Update(t.Context(), 1, updatedRecord, nil)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	got, _ := cache.Get(1)
+	got, _ := cache.Get(t.Context(), 1)
 
 	if got["Name"] != "James Doe" {
 		t.Errorf("expected Name to be 'James Doe', got %v", got["Name"])
@@ -172,28 +177,85 @@ func TestUpdate(t *testing.T) {
 		t.Errorf("expected Age to be 25, got %v", got["Age"])
 	}
 
-	err = cache.Update(2, updatedRecord)
+	err = cache.Update(t.Context(), 2, updatedRecord, nil)
 	if err == nil {
 		t.Fatalf("expected error for non-existent record, got nil")
 	}
 
-	err = cache.Update(1, userrecord.Record{
+	err = cache.Update(t.Context(), 1, userrecord.Record{
 		"id": uint64(2),
-	})
+	}, nil)
 	if err == nil {
 		t.Fatalf("expected error for changing record ID, got nil")
 	}
 
-	err = cache.Update(1, userrecord.Record{
+	err = cache.Update(t.Context(), 1, userrecord.Record{
 		"Name":  "James Doe",
 		"Phone": "123-456-7890",
 		"Age":   25,
-	})
+	}, nil)
 	if err == nil {
 		t.Fatalf("expected error for missing record ID, got nil")
 	}
 
-	mockStorage.AssertCalled(t, "Init", mock.Anything)
+	mockStorage.AssertCalled(t, "Init", mock.Anything, mock.Anything)
+}
+
+func TestPatch(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+
+	cache := New(t.Context(), mockStorage, WithFlushInterval(0), WithFlushThreshold(0))
+
+	original := userrecord.Record{"id": uint64(1), "Name": "John Doe", "Age": 30.0}
+	cache.records[1] = original
+
+	got, err := cache.Patch(t.Context(), 1, userrecord.MergePatch{"Name": "James Doe", "Age": nil}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if got["Name"] != "James Doe" || got["Age"] != nil {
+		t.Errorf("expected patched record, got %v", got)
+	}
+
+	if stored, _ := cache.Get(t.Context(), 1); stored["Name"] != "James Doe" {
+		t.Errorf("expected patched record to be stored, got %v", stored)
+	}
+
+	if original["Name"] != "John Doe" {
+		t.Errorf("expected original record to stay unchanged, got %v", original)
+	}
+
+	tests := []struct {
+		name  string
+		id    uint64
+		patch userrecord.Patch
+		err   error
+	}{
+		{"nonexistent record", 2, userrecord.MergePatch{"Name": "Bob"}, ErrRecordNotFound},
+		{"change id", 1, userrecord.MergePatch{"id": 2.0}, ErrIDCannotChange},
+		{"remove id", 1, userrecord.MergePatch{"id": nil}, ErrIDCannotChange},
+		{"invalid id", 1, userrecord.MergePatch{"id": "abc"}, nil},
+		{"failed test", 1, userrecord.JSONPatch{{Op: "test", Path: "/Name", Value: []byte(`"Bob"`)}}, nil},
+	}
+
+	for _, tt := range tests {
+		_, err = cache.Patch(t.Context(), tt.id, tt.patch, nil)
+		if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if stored, _ := cache.Get(t.Context(), 1); stored["Name"] != "James Doe" || stored["id"] != uint64(1) {
+		t.Errorf("expected failed patches to leave the record unchanged, got %v", stored)
+	}
+
+	mockStorage.AssertNumberOfCalls(t, "Append", 1)
 }
 
 func TestDelete(t *testing.T) {
@@ -201,28 +263,74 @@ func TestDelete(t *testing.T) {
 
 	mockStorage := new(mocks.Storage)
 
-	mockStorage.On("Init", mock.Anything).Return(nil)
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
 
-	cache := New(mockStorage)
+	cache := New(t.Context(), mockStorage)
 
 	cache.records[1] = userrecord.Record{"id": 1}
 
-	err := cache.Delete(1)
+	err := cache.Delete(t.Context(), 1, nil)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	_, err = cache.Get(1)
+	_, err = cache.Get(t.Context(), 1)
 	if err == nil {
 		t.Fatalf("expected error for deleted record, got nil")
 	}
 
-	err = cache.Delete(2)
+	err = cache.Delete(t.Context(), 2, nil)
 	if err == nil {
 		t.Fatalf("expected error for non-existent record, got nil")
 	}
 
-	mockStorage.AssertCalled(t, "Init", mock.Anything)
+	mockStorage.AssertCalled(t, "Init", mock.Anything, mock.Anything)
+}
+
+func TestAppendFailure(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(storage.ErrWriteLog)
+
+	cache := New(t.Context(), mockStorage)
+
+	cache.records[1] = userrecord.Record{"id": uint64(1)}
+
+	err := cache.Add(t.Context(), 2, userrecord.Record{"id": uint64(2)})
+	if !errors.Is(err, ErrLogRecord) || !errors.Is(err, storage.ErrWriteLog) {
+		t.Fatalf("expected Add to wrap the log failure, got %v", err)
+	}
+
+	err = cache.Update(t.Context(), 1, userrecord.Record{"id": uint64(1), "Name": "John Doe"}, nil)
+	if err == nil {
+		t.Fatalf("expected error from Update, got nil")
+	}
+
+	err = cache.Delete(t.Context(), 1, nil)
+	if err == nil {
+		t.Fatalf("expected error from Delete, got nil")
+	}
+
+	record := userrecord.Record{"Name": "Jane Doe"}
+
+	_, err = cache.Create(t.Context(), record)
+	if err == nil {
+		t.Fatalf("expected error from Create, got nil")
+	}
+
+	if _, hasID := record["id"]; hasID {
+		t.Errorf("expected the record not created to be left without id, got %v", record)
+	}
+
+	if len(cache.records) != 1 || cache.records[1]["Name"] != nil {
+		t.Errorf("expected records to stay unchanged, got %v", cache.records)
+	}
+
+	mockStorage.AssertNumberOfCalls(t, "Append", 4)
 }
 
 func TestSaveRecords(t *testing.T) {
@@ -230,34 +338,307 @@ func TestSaveRecords(t *testing.T) {
 
 	mockStorage := new(mocks.Storage)
 
-	mockStorage.On("Init", mock.Anything).Return(nil)
-	mockStorage.On("Save", mock.Anything).Return(errors.New("disk full")).Once()
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Save", mock.Anything, mock.Anything).Return(errors.New("disk full")).Once()
 
-	cache := New(mockStorage)
+	cache := New(t.Context(), mockStorage)
 
-	err := cache.SaveRecords()
+	err := cache.SaveRecords(t.Context())
 	if err == nil {
 		t.Fatalf("expected error from SaveRecords, got nil")
 	}
 
-	mockStorage.On("Save", mock.Anything).Return(nil).Once()
+	if !errors.Is(cache.FlushErr(), ErrSaveRecords) {
+		t.Errorf("expected FlushErr to report the failed save, got %v", cache.FlushErr())
+	}
+
+	mockStorage.On("Save", mock.Anything, mock.Anything).Return(nil).Once()
 
-	err = cache.SaveRecords()
+	err = cache.SaveRecords(t.Context())
 	if err != nil {
 		t.Fatalf("expected no error from SaveRecords, got %v", err)
 	}
 
+	if cache.FlushErr() != nil {
+		t.Errorf("expected FlushErr to be cleared by a successful save, got %v", cache.FlushErr())
+	}
+
 	mockStorage.AssertNumberOfCalls(t, "Save", 2)
 }
 
+func TestFlushThreshold(t *testing.T) {
+	t.Parallel()
+
+	saved := make(chan int, 1)
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Save", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		saved <- len(args.Get(1).(map[uint64]userrecord.Record))
+	})
+
+	cache := New(t.Context(), mockStorage, WithFlushInterval(0), WithFlushThreshold(3))
+
+	cache.records[1] = userrecord.Record{"id": uint64(1)}
+
+	err := cache.Add(t.Context(), 2, userrecord.Record{"id": uint64(2)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = cache.Update(t.Context(), 1, userrecord.Record{"id": uint64(1), "Name": "John Doe"}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	select {
+	case <-saved:
+		t.Fatal("expected no flush below threshold")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	err = cache.Delete(t.Context(), 2, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	select {
+	case n := <-saved:
+		if n != 1 {
+			t.Errorf("expected 1 record to be saved, got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected flush once threshold was reached")
+	}
+
+	err = cache.Close(t.Context())
+	if err != nil {
+		t.Fatalf("expected no error from Close, got %v", err)
+	}
+
+	mockStorage.AssertNumberOfCalls(t, "Save", 2)
+}
+
+func TestFlushInterval(t *testing.T) {
+	t.Parallel()
+
+	saved := make(chan struct{}, 1)
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Save", mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		saved <- struct{}{}
+	})
+
+	cache := New(t.Context(), mockStorage, WithFlushInterval(10*time.Millisecond), WithFlushThreshold(0))
+
+	err := cache.Add(t.Context(), 1, userrecord.Record{"id": uint64(1)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	select {
+	case <-saved:
+	case <-time.After(time.Second):
+		t.Fatal("expected flush after interval")
+	}
+
+	// Nothing changed since the last flush, so ticks must not save again.
+	time.Sleep(50 * time.Millisecond)
+
+	mockStorage.AssertNumberOfCalls(t, "Save", 1)
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Save", mock.Anything, mock.Anything).Return(errors.New("disk full")).Once()
+	mockStorage.On("Save", mock.Anything, mock.Anything).Return(nil).Once()
+
+	cache := New(t.Context(), mockStorage, WithFlushInterval(time.Hour))
+
+	err := cache.Add(t.Context(), 1, userrecord.Record{"id": uint64(1)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = cache.Close(t.Context())
+	if err == nil {
+		t.Fatal("expected error from failed final flush, got nil")
+	}
+
+	err = cache.Close(t.Context())
+	if err != nil {
+		t.Fatalf("expected no error from second Close, got %v", err)
+	}
+
+	if cache.dirty.Load() != 0 {
+		t.Errorf("expected no unsaved changes after Close, got %d", cache.dirty.Load())
+	}
+
+	mockStorage.AssertNumberOfCalls(t, "Save", 2)
+}
+
+func TestDiscard(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+
+	cache := New(t.Context(), mockStorage, WithFlushInterval(time.Hour))
+
+	err := cache.Add(t.Context(), 1, userrecord.Record{"id": uint64(1)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	cache.Discard()
+
+	err = cache.Add(t.Context(), 2, userrecord.Record{"id": uint64(2)})
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	_, err = cache.Get(t.Context(), 1)
+	if err != nil {
+		t.Errorf("expected reads to go on, got %v", err)
+	}
+
+	mockStorage.AssertNumberOfCalls(t, "Append", 1)
+	mockStorage.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+}
+
+func TestCloseCanceled(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Save", mock.Anything, mock.Anything).Return(context.Canceled)
+
+	cache := New(t.Context(), mockStorage, WithFlushInterval(0), WithFlushThreshold(0))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	err := cache.Close(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if cache.FlushErr() == nil {
+		t.Error("expected the abandoned flush to be reported")
+	}
+}
+
+// blockingStorage is a file storage whose checkpointed saves wait until released.
+type blockingStorage struct {
+	*storage.FileStorage
+
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (s blockingStorage) SaveCheckpoint(ctx context.Context, records map[uint64]userrecord.Record,
+	checkpoint storage.Checkpoint,
+) error {
+	s.saving <- struct{}{}
+	<-s.release
+
+	return s.FileStorage.SaveCheckpoint(ctx, records, checkpoint)
+}
+
+func TestWritesDuringSave(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	filename := filepath.Join(t.TempDir(), "data.txt")
+
+	err := os.WriteFile(filename, nil, 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store := blockingStorage{
+		FileStorage: storage.NewFileStorage(filename),
+		saving:      make(chan struct{}, 1),
+		release:     make(chan struct{}),
+	}
+
+	cache := New(ctx, store, WithFlushInterval(0), WithFlushThreshold(0))
+
+	err = cache.Add(ctx, 1, userrecord.Record{"id": uint64(1)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	saved := make(chan error, 1)
+
+	go func() {
+		saved <- cache.SaveRecords(ctx)
+	}()
+
+	<-store.saving
+
+	// Neither readers nor writers wait for the save.
+	err = cache.Add(ctx, 2, userrecord.Record{"id": uint64(2)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	_, err = cache.Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	close(store.release)
+
+	err = <-saved
+	if err != nil {
+		t.Fatalf("expected no error from SaveRecords, got %v", err)
+	}
+
+	if cache.dirty.Load() != 1 {
+		t.Errorf("expected the change made during the save to stay unsaved, got %d", cache.dirty.Load())
+	}
+
+	// The change made during the save must survive the compaction of the log.
+	records := make(map[uint64]userrecord.Record)
+
+	err = storage.NewFileStorage(filename).Init(ctx, records)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(records) != 2 {
+		t.Errorf("expected records 1 and 2 to be stored, got %v", records)
+	}
+
+	err = cache.Close(ctx)
+	if err != nil {
+		t.Fatalf("expected no error from Close, got %v", err)
+	}
+}
+
 func TestRecordCache_Race(t *testing.T) {
 	t.Parallel()
 
 	mockStorage := new(mocks.Storage)
-	mockStorage.On("Init", mock.Anything).Return(nil)
-	mockStorage.On("Save", mock.Anything).Return(nil)
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Save", mock.Anything, mock.Anything).Return(nil)
 
-	cache := New(mockStorage)
+	cache := New(t.Context(), mockStorage)
 
 	var wg sync.WaitGroup
 
@@ -276,10 +657,700 @@ func TestRecordCache_Race(t *testing.T) {
 				"Age":   30,
 			}
 
-			_ = cache.Add(id, rec)
-			_, _ = cache.Get(id)
+			_ = cache.Add(t.Context(), id, rec)
+			_, _ = cache.Get(t.Context(), id)
 		}(id)
 	}
 
 	wg.Wait()
 }
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		records := args.Get(1).(map[uint64]userrecord.Record)
+		records[5] = userrecord.Record{"id": uint64(5), "name": "Alice", "likes": []any{"apples"}}
+		records[1] = userrecord.Record{"id": uint64(1), "name": "Bob", "likes": []any{"apples", "bananas"}}
+	})
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+
+	cache := New(t.Context(), mockStorage, WithFlushInterval(0), WithFlushThreshold(0))
+
+	for _, id := range []uint64{3, 0} {
+		err := cache.Add(t.Context(), id, userrecord.Record{"id": id, "name": "Alice", "age": 30.0})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	err := cache.Delete(t.Context(), 3, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		opts       ListOptions
+		wantIDs    []uint64
+		wantCursor string
+	}{
+		{"all", ListOptions{}, []uint64{0, 1, 5}, ""},
+		{"first page", ListOptions{Limit: 2}, []uint64{0, 1}, "1"},
+		{"second page", ListOptions{Limit: 2, Cursor: "1"}, []uint64{5}, ""},
+		{"descending", ListOptions{Limit: 2, Descending: true}, []uint64{5, 1}, "1"},
+		{"descending second page", ListOptions{Limit: 2, Cursor: "1", Descending: true}, []uint64{0}, ""},
+		{"cursor of deleted record", ListOptions{Cursor: "3"}, []uint64{5}, ""},
+		{"filter by name", ListOptions{Filters: []Filter{{"name", "Alice"}}}, []uint64{0, 5}, ""},
+		{"filter by number", ListOptions{Filters: []Filter{{"age", "30"}}}, []uint64{0}, ""},
+		{"filter by array", ListOptions{Filters: []Filter{{"likes", "apples"}}}, []uint64{1, 5}, ""},
+		{
+			"filters combined",
+			ListOptions{Filters: []Filter{{"likes", "apples"}, {"name", "Alice"}}},
+			[]uint64{5},
+			"",
+		},
+		{"filtered page", ListOptions{Limit: 1, Filters: []Filter{{"name", "Alice"}}}, []uint64{0}, "0"},
+		{"no match", ListOptions{Filters: []Filter{{"name", "Carol"}}}, []uint64{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			page, err := cache.List(t.Context(), tt.opts)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			ids := make([]uint64, 0, len(page.Records))
+			for _, rec := range page.Records {
+				id, _ := rec.ID()
+				ids = append(ids, id)
+			}
+
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("expected ids %v, got %v", tt.wantIDs, ids)
+			}
+
+			if page.NextCursor != tt.wantCursor {
+				t.Errorf("expected cursor %q, got %q", tt.wantCursor, page.NextCursor)
+			}
+		})
+	}
+
+	_, err = cache.List(t.Context(), ListOptions{Cursor: "abc"})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+
+	cache := New(t.Context(), mockStorage, WithFlushInterval(0), WithFlushThreshold(0))
+
+	cache.records[1] = userrecord.Record{"id": uint64(1), "Name": "John Doe"}
+
+	version, err := cache.records[1].Version()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	stale := []string{"stale"}
+
+	err = cache.Update(t.Context(), 1, userrecord.Record{"id": uint64(1), "Name": "James Doe"}, stale)
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch from Update, got %v", err)
+	}
+
+	_, err = cache.Patch(t.Context(), 1, userrecord.MergePatch{"Name": "James Doe"}, stale)
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch from Patch, got %v", err)
+	}
+
+	err = cache.Delete(t.Context(), 1, stale)
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch from Delete, got %v", err)
+	}
+
+	err = cache.Update(t.Context(), 1, userrecord.Record{"id": uint64(1), "Name": "James Doe"}, []string{"stale", version})
+	if err != nil {
+		t.Fatalf("expected no error for matching version, got %v", err)
+	}
+
+	// The update changed the version, so the old one no longer matches.
+	err = cache.Delete(t.Context(), 1, []string{version})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch for outdated version, got %v", err)
+	}
+
+	mockStorage.AssertNumberOfCalls(t, "Append", 1)
+}
+
+// sequencedStorage is a storage mock that also remembers issued ids.
+type sequencedStorage struct {
+	*mocks.Storage
+
+	seq uint64
+}
+
+func (s sequencedStorage) Sequence() uint64 {
+	return s.seq
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(1).(map[uint64]userrecord.Record)[4] = userrecord.Record{"id": uint64(4)}
+	})
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+
+	// Ids up to 10 were issued before, so the next one is 11 even though 4 is the highest left.
+	cache := New(t.Context(), sequencedStorage{Storage: mockStorage, seq: 10}, WithFlushInterval(0), WithFlushThreshold(0))
+
+	record := userrecord.Record{"Name": "John Doe"}
+
+	id, err := cache.Create(t.Context(), record)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if id != 11 || record["id"] != uint64(11) {
+		t.Fatalf("expected id 11 to be assigned, got %d and record %v", id, record)
+	}
+
+	err = cache.Add(t.Context(), 20, userrecord.Record{"id": uint64(20)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = cache.Delete(t.Context(), 20, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	id, err = cache.Create(t.Context(), userrecord.Record{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if id != 21 {
+		t.Errorf("expected deleted id 20 not to be reused, got %d", id)
+	}
+
+	cache.lastID = math.MaxUint64
+
+	_, err = cache.Create(t.Context(), userrecord.Record{})
+	if err == nil {
+		t.Fatal("expected error once ids are exhausted, got nil")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		records := args.Get(1).(map[uint64]userrecord.Record)
+		records[1] = userrecord.Record{"id": uint64(1), "email": "a@x", "likes": []any{"apples"}}
+		records[2] = userrecord.Record{"id": uint64(2), "email": "b@x", "likes": []any{"apples", "pears"}}
+	})
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+
+	cache := New(t.Context(), mockStorage,
+		WithFlushInterval(0),
+		WithFlushThreshold(0),
+		WithUniqueIndex("email"),
+		WithIndex("likes"),
+	)
+
+	listIDs := func(filters ...Filter) []uint64 {
+		t.Helper()
+
+		page, err := cache.List(t.Context(), ListOptions{Filters: filters})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		ids := make([]uint64, 0, len(page.Records))
+		for _, rec := range page.Records {
+			id, _ := rec.ID()
+			ids = append(ids, id)
+		}
+
+		return ids
+	}
+
+	err := cache.Add(t.Context(), 3, userrecord.Record{"id": uint64(3), "email": "a@x"})
+	if !errors.Is(err, ErrDuplicateValue) {
+		t.Fatalf("expected ErrDuplicateValue on Add, got %v", err)
+	}
+
+	record := userrecord.Record{"email": "b@x"}
+
+	_, err = cache.Create(t.Context(), record)
+	if !errors.Is(err, ErrDuplicateValue) {
+		t.Fatalf("expected ErrDuplicateValue on Create, got %v", err)
+	}
+
+	if _, hasID := record["id"]; hasID {
+		t.Errorf("expected rejected record to be left without id, got %v", record)
+	}
+
+	err = cache.Update(t.Context(), 2, userrecord.Record{"id": uint64(2), "email": "a@x"}, nil)
+	if !errors.Is(err, ErrDuplicateValue) {
+		t.Fatalf("expected ErrDuplicateValue on Update, got %v", err)
+	}
+
+	_, err = cache.Patch(t.Context(), 2, userrecord.MergePatch{"email": "a@x"}, nil)
+	if !errors.Is(err, ErrDuplicateValue) {
+		t.Fatalf("expected ErrDuplicateValue on Patch, got %v", err)
+	}
+
+	// Keeping its own value is not a violation.
+	_, err = cache.Patch(t.Context(), 1, userrecord.MergePatch{"likes": []any{"pears"}}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if ids := listIDs(Filter{"likes", "apples"}); !slices.Equal(ids, []uint64{2}) {
+		t.Errorf("expected ids [2] after patch, got %v", ids)
+	}
+
+	if ids := listIDs(Filter{"likes", "pears"}, Filter{"email", "a@x"}); !slices.Equal(ids, []uint64{1}) {
+		t.Errorf("expected ids [1], got %v", ids)
+	}
+
+	err = cache.Delete(t.Context(), 1, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if ids := listIDs(Filter{"email", "a@x"}); len(ids) != 0 {
+		t.Errorf("expected no ids after delete, got %v", ids)
+	}
+
+	err = cache.Add(t.Context(), 1, userrecord.Record{"id": uint64(1), "email": "a@x"})
+	if err != nil {
+		t.Fatalf("expected freed value to be reusable, got %v", err)
+	}
+}
+
+func TestNewUniqueIndexViolation(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		records := args.Get(1).(map[uint64]userrecord.Record)
+		records[1] = userrecord.Record{"id": uint64(1), "email": "a@x"}
+		records[2] = userrecord.Record{"id": uint64(2), "email": "a@x"}
+	})
+
+	if cache := New(t.Context(), mockStorage, WithUniqueIndex("email")); cache != nil {
+		t.Fatal("expected nil cache for records violating a unique index")
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	newCache := func(appendErr error) (*RecordCache, *mocks.Storage) {
+		mockStorage := new(mocks.Storage)
+
+		mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			records := args.Get(1).(map[uint64]userrecord.Record)
+			records[1] = userrecord.Record{"id": uint64(1), "email": "a@x", "balance": 10.0}
+			records[2] = userrecord.Record{"id": uint64(2), "email": "b@x", "balance": 0.0}
+		})
+		mockStorage.On("Append", mock.Anything, mock.Anything).Return(appendErr)
+
+		return New(t.Context(), mockStorage, WithFlushInterval(0), WithFlushThreshold(0), WithUniqueIndex("email")), mockStorage
+	}
+
+	version, _ := userrecord.Record{"id": uint64(1), "email": "a@x", "balance": 10.0}.Version()
+	exists := true
+
+	transfer := []Operation{
+		{Kind: OpPatch, ID: 1, Patch: userrecord.MergePatch{"balance": 5.0}, Precondition: Precondition{Version: version}},
+		{Kind: OpPatch, ID: 2, Patch: userrecord.MergePatch{"balance": 5.0}},
+		{Kind: OpCreate, Record: userrecord.Record{"email": "c@x"}},
+		{Kind: OpDelete, ID: 2, Precondition: Precondition{Exists: &exists}},
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		t.Parallel()
+
+		cache, mockStorage := newCache(nil)
+
+		results, err := cache.Apply(t.Context(), transfer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(results) != 4 || results[2].ID != 3 || results[3].Record != nil {
+			t.Fatalf("unexpected results %v", results)
+		}
+
+		if cache.records[1]["balance"] != 5.0 || cache.records[2] != nil || cache.records[3] == nil {
+			t.Errorf("unexpected records %v", cache.records)
+		}
+
+		if !slices.Equal(cache.ids, []uint64{1, 3}) {
+			t.Errorf("expected ids [1 3], got %v", cache.ids)
+		}
+
+		mockStorage.AssertNumberOfCalls(t, "Append", 1)
+		mockStorage.AssertCalled(t, "Append", mock.Anything, mock.MatchedBy(func(entry storage.Entry) bool {
+			return entry.Op == storage.OpBatch && len(entry.Entries) == 4
+		}))
+	})
+
+	rollbacks := []struct {
+		name      string
+		ops       []Operation
+		appendErr error
+		index     int
+		err       error
+	}{
+		{
+			"version mismatch",
+			append(slices.Clone(transfer[1:]), Operation{
+				Kind: OpUpdate, ID: 1, Record: userrecord.Record{"id": uint64(1)}, Precondition: Precondition{Version: "x"},
+			}),
+			nil,
+			3,
+			ErrVersionMismatch,
+		},
+		{
+			"record exists",
+			[]Operation{transfer[3], {Kind: OpCreate, Record: userrecord.Record{"id": uint64(1)}}},
+			nil,
+			1,
+			ErrRecordExists,
+		},
+		{
+			"unique value",
+			[]Operation{transfer[0], {Kind: OpPatch, ID: 2, Patch: userrecord.MergePatch{"email": "a@x"}}},
+			nil,
+			1,
+			ErrDuplicateValue,
+		},
+		{
+			"deleted in the same transaction",
+			[]Operation{transfer[3], transfer[1]},
+			nil,
+			1,
+			ErrRecordNotFound,
+		},
+		{"storage failure", transfer, errors.New("disk full"), -1, ErrLogRecord},
+	}
+
+	for _, tt := range rollbacks {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache, _ := newCache(tt.appendErr)
+
+			_, err := cache.Apply(t.Context(), tt.ops)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+
+			var txErr *TransactionError
+			if tt.index >= 0 && (!errors.As(err, &txErr) || txErr.Index != tt.index) {
+				t.Errorf("expected failure of operation %d, got %v", tt.index, err)
+			}
+
+			if len(cache.records) != 2 || cache.records[1]["balance"] != 10.0 || cache.records[2]["balance"] != 0.0 {
+				t.Errorf("expected records to be rolled back, got %v", cache.records)
+			}
+
+			if !slices.Equal(cache.ids, []uint64{1, 2}) || cache.lastID != 2 {
+				t.Errorf("expected ids [1 2] and last id 2, got %v and %d", cache.ids, cache.lastID)
+			}
+
+			// The index must be rolled back too: a@x is taken by 1 and c@x is free again.
+			err = cache.Add(t.Context(), 4, userrecord.Record{"id": uint64(4), "email": "a@x"})
+			if !errors.Is(err, ErrDuplicateValue) {
+				t.Errorf("expected ErrDuplicateValue, got %v", err)
+			}
+
+			err = cache.Add(t.Context(), 4, userrecord.Record{"id": uint64(4), "email": "c@x"})
+			if tt.appendErr == nil && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		cache, mockStorage := newCache(nil)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		_, err := cache.Apply(ctx, transfer)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+
+		if len(cache.records) != 2 || cache.records[1]["balance"] != 10.0 || cache.lastID != 2 {
+			t.Errorf("expected nothing to be applied, got %v", cache.records)
+		}
+
+		mockStorage.AssertNotCalled(t, "Append", mock.Anything, mock.Anything)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	mockStorage := new(mocks.Storage)
+
+	mockStorage.On("Init", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Append", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Save", mock.Anything, mock.Anything).Return(nil)
+
+	reg := metrics.NewRegistry()
+	cache := New(t.Context(), mockStorage, WithFlushInterval(0), WithFlushThreshold(0), WithMetrics(reg, "users"))
+
+	for id := uint64(1); id <= 3; id++ {
+		err := cache.Add(t.Context(), id, userrecord.Record{"id": id})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := "# HELP cache_dirty_records Number of changes not yet saved to the snapshot.\n" +
+		"# TYPE cache_dirty_records gauge\n" +
+		"cache_dirty_records{collection=\"users\"} 3\n" +
+		"# HELP cache_records Number of records stored, whether in memory or not.\n" +
+		"# TYPE cache_records gauge\n" +
+		"cache_records{collection=\"users\"} 3\n"
+
+	var out strings.Builder
+
+	_, err := reg.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("expected metrics:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	err = cache.Close(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out.Reset()
+
+	_, err = reg.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Contains(out.String(), "users") {
+		t.Errorf("expected no metrics of a closed cache, got:\n%s", out.String())
+	}
+}
+
+func TestEviction(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy EvictionPolicy
+		// hits tells whether each Get after adding records 1 to 3 is served from memory.
+		gets      []uint64
+		hits      []bool
+		evictions uint64
+	}{
+		{"lru", EvictLRU, []uint64{2, 1, 3, 2}, []bool{true, false, false, false}, 4},
+		{"lfu", EvictLFU, []uint64{2, 1, 3, 2}, []bool{true, false, false, true}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := New(t.Context(), storage.NewLSMStorage(t.TempDir()),
+				WithFlushInterval(0), WithFlushThreshold(0), WithMaxRecords(2), WithEviction(tt.policy))
+			if cache == nil {
+				t.Fatal("expected cache to be created")
+			}
+
+			for id := uint64(1); id <= 3; id++ {
+				err := cache.Add(t.Context(), id, userrecord.Record{"id": id})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			for i, id := range tt.gets {
+				before := cache.Stats()
+
+				record, err := cache.Get(t.Context(), id)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if got, _ := record.ID(); got != id {
+					t.Errorf("expected record %d, got %v", id, record)
+				}
+
+				if hit := cache.Stats().Hits > before.Hits; hit != tt.hits[i] {
+					t.Errorf("get %d of record %d: expected hit %t, got %t", i, id, tt.hits[i], hit)
+				}
+			}
+
+			stats := cache.Stats()
+			if stats.Resident != 2 || stats.Evictions != tt.evictions {
+				t.Errorf("expected 2 resident records and %d evictions, got %+v", tt.evictions, stats)
+			}
+		})
+	}
+}
+
+func TestBoundedCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	engine := storage.NewLSMStorage(dir)
+	reg := metrics.NewRegistry()
+
+	cache := New(t.Context(), engine, WithFlushInterval(0), WithFlushThreshold(0),
+		WithMaxRecords(2), WithUniqueIndex("email"), WithMetrics(reg, "users"))
+	if cache == nil {
+		t.Fatal("expected cache to be created")
+	}
+
+	for id := uint64(1); id <= 5; id++ {
+		_, err := cache.Create(t.Context(), userrecord.Record{"email": strings.Repeat("a", int(id))})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Record 1 was evicted, but the unique index still knows its email.
+	_, err := cache.Create(t.Context(), userrecord.Record{"email": "a"})
+	if !errors.Is(err, ErrDuplicateValue) {
+		t.Errorf("expected ErrDuplicateValue, got %v", err)
+	}
+
+	// A transaction changing more records than fit keeps them until it is logged.
+	results, err := cache.Apply(t.Context(), []Operation{
+		{Kind: OpPatch, ID: 1, Patch: userrecord.MergePatch{"name": "one"}},
+		{Kind: OpPatch, ID: 2, Patch: userrecord.MergePatch{"name": "two"}},
+		{Kind: OpPatch, ID: 3, Patch: userrecord.MergePatch{"name": "three"}},
+		{Kind: OpDelete, ID: 4},
+	})
+	if err != nil || len(results) != 4 {
+		t.Fatalf("expected 4 results, got %v and %v", results, err)
+	}
+
+	if stats := cache.Stats(); stats.Resident > 2 {
+		t.Errorf("expected at most 2 resident records after the transaction, got %+v", stats)
+	}
+
+	_, err = cache.Apply(t.Context(), []Operation{
+		{Kind: OpPatch, ID: 5, Patch: userrecord.MergePatch{"name": "five"}},
+		{Kind: OpPatch, ID: 1, Patch: userrecord.MergePatch{"email": "aaaaa"}},
+	})
+	if !errors.Is(err, ErrDuplicateValue) {
+		t.Errorf("expected ErrDuplicateValue, got %v", err)
+	}
+
+	for range 2 {
+		_, err = cache.Get(t.Context(), 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var gauges strings.Builder
+
+	_, err = reg.WriteTo(&gauges)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Four records are stored, of which only two fit in memory.
+	for _, gauge := range []string{`cache_records{collection="users"} 4`, `cache_resident_records{collection="users"} 2`} {
+		if !strings.Contains(gauges.String(), gauge+"\n") {
+			t.Errorf("expected %s, got:\n%s", gauge, gauges.String())
+		}
+	}
+
+	err = cache.Close(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = engine.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var out strings.Builder
+
+	_, err = reg.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, metric := range []string{"cache_hits_total", "cache_misses_total", "cache_evictions_total"} {
+		if !strings.Contains(out.String(), metric+`{collection="users"}`) {
+			t.Errorf("expected metric %s, got:\n%s", metric, out.String())
+		}
+	}
+
+	// After a restart, only the ids are loaded and the records are read on demand.
+	reopened := New(t.Context(), storage.NewLSMStorage(dir), WithFlushInterval(0), WithFlushThreshold(0), WithMaxRecords(2))
+	if reopened == nil {
+		t.Fatal("expected cache to be reopened")
+	}
+
+	page, err := reopened.List(t.Context(), ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []any
+	for _, record := range page.Records {
+		names = append(names, record["name"])
+	}
+
+	if expected := []any{"one", "two", "three", nil}; !slices.Equal(names, expected) {
+		t.Errorf("expected names %v, got %v", expected, names)
+	}
+
+	if stats := reopened.Stats(); stats.Resident != 2 || stats.Misses != 2 {
+		t.Errorf("expected 2 resident records and 2 misses, got %+v", stats)
+	}
+
+	_, err = reopened.Get(t.Context(), 4)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	id, err := reopened.Create(t.Context(), userrecord.Record{})
+	if err != nil || id != 6 {
+		t.Errorf("expected id 6, got %d and %v", id, err)
+	}
+}
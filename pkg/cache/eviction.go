@@ -0,0 +1,379 @@
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// Eviction policies of a bounded cache.
+const (
+	// EvictLRU evicts the record that was used least recently.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the record that was used least often, and the least
+	// recently used one among records used equally often.
+	EvictLFU
+)
+
+// EvictionPolicy chooses the record a bounded cache evicts when it is full.
+type EvictionPolicy int
+
+// Stats describes the lookups of a cache. Only a bounded cache misses and evicts.
+type Stats struct {
+	// Resident is the number of records held in memory.
+	Resident  int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// WithMaxRecords bounds the cache to n records in memory. Records that are
+// not resident are read from the storage when they are needed, which must be
+// a storage.Engine; with any other storage the cache keeps all records, as
+// it must pass all of them to Save. Zero, the default, keeps all records.
+func WithMaxRecords(n int) Option {
+	return func(r *RecordCache) {
+		r.maxRecords = max(n, 0)
+	}
+}
+
+// WithEviction sets the policy of a bounded cache. The default is EvictLRU.
+func WithEviction(policy EvictionPolicy) Option {
+	return func(r *RecordCache) {
+		r.eviction = policy
+	}
+}
+
+// resident holds the records of a bounded cache that are in memory. Records
+// are written through to the storage before they are stored here, so they
+// are clean and can be evicted at any time, except for the pending records
+// of a transaction until the transaction is logged or rolled back. Its own
+// mutex lets readers holding the cache's read lock load and evict records.
+type resident struct {
+	mu      sync.Mutex
+	max     int
+	entries map[uint64]*residentEntry
+	order   residentHeap
+	// clock orders the uses of records.
+	clock   uint64
+	pending []uint64
+	stats   Stats
+}
+
+// residentEntry is a record in memory and its uses.
+type residentEntry struct {
+	id      uint64
+	record  userrecord.Record
+	uses    uint64
+	lastUse uint64
+	pending bool
+	pos     int // position in the heap
+}
+
+// residentHeap orders entries with the next one to evict first.
+type residentHeap struct {
+	entries []*residentEntry
+	policy  EvictionPolicy
+}
+
+func newResident(maxRecords int, policy EvictionPolicy) *resident {
+	return &resident{
+		max:     maxRecords,
+		entries: make(map[uint64]*residentEntry),
+		order:   residentHeap{policy: policy},
+	}
+}
+
+// get returns the record with id if it is in memory, counting a hit or a miss.
+func (c *resident) get(id uint64) (userrecord.Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[id]
+	if !exists {
+		c.stats.Misses++
+
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.touch(entry)
+
+	return entry.record, true
+}
+
+// peek returns the record with id if it is in memory, without counting a use.
+func (c *resident) peek(id uint64) (userrecord.Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[id]
+	if !exists {
+		return nil, false
+	}
+
+	return entry.record, true
+}
+
+// put stores record under id and returns the number of records evicted to
+// make room for it. A pending record is not evicted until settle is called.
+func (c *resident) put(id uint64, record userrecord.Record, pending bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var evicted int
+
+	entry, exists := c.entries[id]
+	if !exists {
+		// Room is made first, so that a new record is never the one evicted.
+		evicted = c.evict(c.max - 1)
+		entry = &residentEntry{id: id}
+		c.entries[id] = entry
+		heap.Push(&c.order, entry)
+	}
+
+	entry.record = record
+
+	if pending && !entry.pending {
+		entry.pending = true
+		c.pending = append(c.pending, id)
+	}
+
+	c.touch(entry)
+
+	return evicted
+}
+
+// remove drops the record with id from memory.
+func (c *resident) remove(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[id]
+	if !exists {
+		return
+	}
+
+	heap.Remove(&c.order, entry.pos)
+	delete(c.entries, id)
+}
+
+// settle makes the pending records evictable and returns the number of
+// records evicted to get back within the bound.
+func (c *resident) settle() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range c.pending {
+		if entry, exists := c.entries[id]; exists {
+			entry.pending = false
+		}
+	}
+
+	c.pending = c.pending[:0]
+
+	return c.evict(c.max)
+}
+
+// snapshot returns the stats of the cache.
+func (c *resident) snapshot() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Resident = len(c.entries)
+
+	return stats
+}
+
+// touch counts a use of entry. The caller must hold the lock.
+func (c *resident) touch(entry *residentEntry) {
+	c.clock++
+	entry.uses++
+	entry.lastUse = c.clock
+	heap.Fix(&c.order, entry.pos)
+}
+
+// evict removes the records next in line until at most limit are left,
+// skipping pending ones, and returns how many it removed. The caller must hold the lock.
+func (c *resident) evict(limit int) int {
+	var (
+		kept    []*residentEntry
+		evicted int
+	)
+
+	for len(c.entries) > limit && c.order.Len() > 0 {
+		entry, _ := heap.Pop(&c.order).(*residentEntry)
+		if entry.pending {
+			kept = append(kept, entry)
+
+			continue
+		}
+
+		delete(c.entries, entry.id)
+		evicted++
+	}
+
+	for _, entry := range kept {
+		heap.Push(&c.order, entry)
+	}
+
+	c.stats.Evictions += uint64(evicted)
+
+	return evicted
+}
+
+func (h *residentHeap) Len() int {
+	return len(h.entries)
+}
+
+func (h *residentHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if h.policy == EvictLFU && a.uses != b.uses {
+		return a.uses < b.uses
+	}
+
+	return a.lastUse < b.lastUse
+}
+
+func (h *residentHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].pos = i
+	h.entries[j].pos = j
+}
+
+func (h *residentHeap) Push(x any) {
+	entry, _ := x.(*residentEntry)
+	entry.pos = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *residentHeap) Pop() any {
+	last := len(h.entries) - 1
+	entry := h.entries[last]
+	h.entries[last] = nil
+	h.entries = h.entries[:last]
+
+	return entry
+}
+
+// Stats returns the number of records in memory and the lookups of a bounded cache.
+func (r *RecordCache) Stats() Stats {
+	if r.resident != nil {
+		return r.resident.snapshot()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return Stats{Resident: len(r.records)}
+}
+
+// record returns the record with id, reading it from the engine if the cache
+// is bounded and does not hold it. The caller must hold the lock.
+func (r *RecordCache) record(ctx context.Context, id uint64) (userrecord.Record, error) {
+	if r.resident == nil {
+		record, exists := r.records[id]
+		if !exists {
+			return nil, fmt.Errorf("record with id %d: %w", id, ErrRecordNotFound)
+		}
+
+		return record, nil
+	}
+
+	if !r.exists(id) {
+		return nil, fmt.Errorf("record with id %d: %w", id, ErrRecordNotFound)
+	}
+
+	record, ok := r.resident.get(id)
+	if ok {
+		r.hits.Inc(r.collection)
+
+		return record, nil
+	}
+
+	r.misses.Inc(r.collection)
+
+	record, err := r.engine.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("reading record with id %d: %w: %w", id, ErrReadRecord, err)
+	}
+
+	r.evictions.Add(float64(r.resident.put(id, record, false)), r.collection)
+
+	return record, nil
+}
+
+// exists reports whether a record with id is stored. The caller must hold the lock.
+func (r *RecordCache) exists(id uint64) bool {
+	if r.resident == nil {
+		_, exists := r.records[id]
+
+		return exists
+	}
+
+	_, found := slices.BinarySearch(r.ids, id)
+
+	return found
+}
+
+// cached returns the record with id if it is in memory, without reading the
+// engine or counting a use. The caller must hold the lock.
+func (r *RecordCache) cached(id uint64) (userrecord.Record, bool) {
+	if r.resident == nil {
+		record, exists := r.records[id]
+
+		return record, exists
+	}
+
+	return r.resident.peek(id)
+}
+
+// store keeps record, which is already written to storage, under id. The
+// caller must hold the write lock.
+func (r *RecordCache) store(id uint64, record userrecord.Record) {
+	if r.resident == nil {
+		r.records[id] = record
+
+		return
+	}
+
+	r.evictions.Add(float64(r.resident.put(id, record, false)), r.collection)
+}
+
+// storePending keeps record, which is not yet written to storage, under id
+// until settle is called. The caller must hold the write lock.
+func (r *RecordCache) storePending(id uint64, record userrecord.Record) {
+	if r.resident == nil {
+		r.records[id] = record
+
+		return
+	}
+
+	r.evictions.Add(float64(r.resident.put(id, record, true)), r.collection)
+}
+
+// settle lets the records kept by storePending be evicted again. The caller
+// must hold the write lock.
+func (r *RecordCache) settle() {
+	if r.resident == nil {
+		return
+	}
+
+	r.evictions.Add(float64(r.resident.settle()), r.collection)
+}
+
+// drop forgets the record with id. The caller must hold the write lock.
+func (r *RecordCache) drop(id uint64) {
+	if r.resident == nil {
+		delete(r.records, id)
+
+		return
+	}
+
+	r.resident.remove(id)
+}
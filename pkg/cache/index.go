@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"fmt"
+	"slices"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// index maps the values of a top-level field, in the text form compared by
+// filters, to the ids of the records holding them. A record with an array
+// field is indexed under each of its elements.
+type index struct {
+	unique bool
+	ids    map[string][]uint64 // ids in ascending order
+}
+
+// WithIndex maintains a secondary index on a top-level field,
+// which List uses to serve filters on that field.
+func WithIndex(field string) Option {
+	return func(r *RecordCache) {
+		r.indexes[field] = &index{ids: make(map[string][]uint64)}
+	}
+}
+
+// WithUniqueIndex maintains a secondary index on a top-level field and
+// rejects writes that would give two records the same value in it.
+func WithUniqueIndex(field string) Option {
+	return func(r *RecordCache) {
+		r.indexes[field] = &index{unique: true, ids: make(map[string][]uint64)}
+	}
+}
+
+// buildIndexes indexes all records, failing if a unique index is violated.
+func (r *RecordCache) buildIndexes() error {
+	for _, id := range r.ids {
+		err := r.addToIndexes(id, r.records[id])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addToIndexes indexes a loaded record, failing if it violates a unique index.
+func (r *RecordCache) addToIndexes(id uint64, record userrecord.Record) error {
+	err := r.checkUnique(id, record)
+	if err != nil {
+		return fmt.Errorf("building indexes: %w", err)
+	}
+
+	r.indexRecord(id, record)
+
+	return nil
+}
+
+// checkUnique returns ErrDuplicateValue if record would share a value of a
+// unique index with a record other than id. The caller must hold the lock.
+func (r *RecordCache) checkUnique(id uint64, record userrecord.Record) error {
+	for field, idx := range r.indexes {
+		if !idx.unique {
+			continue
+		}
+
+		for _, value := range record.FieldValues(field) {
+			for _, other := range idx.ids[value] {
+				if other != id {
+					return fmt.Errorf("%s %q is used by record with id %d: %w", field, value, other, ErrDuplicateValue)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// indexRecord adds record to all indexes. The caller must hold the write lock.
+func (r *RecordCache) indexRecord(id uint64, record userrecord.Record) {
+	for field, idx := range r.indexes {
+		for _, value := range record.FieldValues(field) {
+			idx.ids[value] = insertSorted(idx.ids[value], id)
+		}
+	}
+}
+
+// unindexRecord removes record from all indexes. The caller must hold the write lock.
+func (r *RecordCache) unindexRecord(id uint64, record userrecord.Record) {
+	for field, idx := range r.indexes {
+		for _, value := range record.FieldValues(field) {
+			ids := removeSorted(idx.ids[value], id)
+			if len(ids) == 0 {
+				delete(idx.ids, value)
+
+				continue
+			}
+
+			idx.ids[value] = ids
+		}
+	}
+}
+
+// candidates returns the ascending ids that may match filters: the shortest
+// index entry among the indexed filter fields, or all ids if none is indexed.
+func (r *RecordCache) candidates(filters []Filter) []uint64 {
+	ids := r.ids
+	indexed := false
+
+	for _, f := range filters {
+		idx, ok := r.indexes[f.Field]
+		if !ok {
+			continue
+		}
+
+		if entry := idx.ids[f.Value]; !indexed || len(entry) < len(ids) {
+			ids = entry
+			indexed = true
+		}
+	}
+
+	return ids
+}
+
+// insertSorted adds id to the ascending ids unless it is already there.
+func insertSorted(ids []uint64, id uint64) []uint64 {
+	pos, found := slices.BinarySearch(ids, id)
+	if found {
+		return ids
+	}
+
+	return slices.Insert(ids, pos, id)
+}
+
+// removeSorted removes id from the ascending ids.
+func removeSorted(ids []uint64, id uint64) []uint64 {
+	pos, found := slices.BinarySearch(ids, id)
+	if !found {
+		return ids
+	}
+
+	return slices.Delete(ids, pos, pos+1)
+}
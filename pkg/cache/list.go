@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// List returns a page of records ordered by id that match all filters.
+func (r *RecordCache) List(ctx context.Context, opts ListOptions) (Page, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := r.candidates(opts.Filters)
+
+	start, err := startIndex(ids, opts)
+	if err != nil {
+		return Page{}, err
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	limit = min(limit, maxListLimit)
+
+	step := 1
+	if opts.Descending {
+		step = -1
+	}
+
+	page := Page{Records: make([]userrecord.Record, 0, min(limit, len(ids)))}
+
+	var lastID uint64
+
+	for i := start; i >= 0 && i < len(ids); i += step {
+		record, err := r.record(ctx, ids[i])
+		if err != nil {
+			return Page{}, err
+		}
+
+		if !matches(record, opts.Filters) {
+			continue
+		}
+
+		if len(page.Records) == limit {
+			page.NextCursor = strconv.FormatUint(lastID, 10)
+
+			break
+		}
+
+		page.Records = append(page.Records, record)
+		lastID = ids[i]
+	}
+
+	return page, nil
+}
+
+// startIndex returns the position in the ascending ids of the first candidate for the page.
+func startIndex(ids []uint64, opts ListOptions) (int, error) {
+	if opts.Cursor == "" {
+		if opts.Descending {
+			return len(ids) - 1, nil
+		}
+
+		return 0, nil
+	}
+
+	cursor, err := strconv.ParseUint(opts.Cursor, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cursor %q: %w", opts.Cursor, ErrInvalidCursor)
+	}
+
+	pos, found := slices.BinarySearch(ids, cursor)
+	if opts.Descending {
+		return pos - 1, nil
+	}
+
+	if found {
+		pos++
+	}
+
+	return pos, nil
+}
+
+// insertID adds id to the ordered ids. The caller must hold the write lock.
+func (r *RecordCache) insertID(id uint64) {
+	r.ids = insertSorted(r.ids, id)
+}
+
+// removeID removes id from the ordered ids. The caller must hold the write lock.
+func (r *RecordCache) removeID(id uint64) {
+	r.ids = removeSorted(r.ids, id)
+}
+
+// matches reports whether record satisfies every filter.
+func matches(record userrecord.Record, filters []Filter) bool {
+	for _, f := range filters {
+		if !record.Matches(f.Field, f.Value) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"zabbix-technical-task/pkg/metrics"
+)
+
+// Names of the metrics reported by WithMetrics.
+const (
+	recordsMetric   = "cache_records"
+	residentMetric  = "cache_resident_records"
+	dirtyMetric     = "cache_dirty_records"
+	hitsMetric      = "cache_hits_total"
+	missesMetric    = "cache_misses_total"
+	evictionsMetric = "cache_evictions_total"
+)
+
+// WithMetrics reports the number of records stored and of changes not yet
+// saved in reg, labelled with collection, until the cache is closed. A bounded
+// cache also reports how many records it holds in memory and counts its hits,
+// misses and evictions.
+func WithMetrics(reg *metrics.Registry, collection string) Option {
+	return func(r *RecordCache) {
+		r.metrics = reg
+		r.collection = collection
+	}
+}
+
+// registerMetrics starts reporting the cache's gauges, if metrics are enabled.
+func (r *RecordCache) registerMetrics() {
+	if r.metrics == nil {
+		return
+	}
+
+	r.recordsGauge().Set(func() float64 {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		return float64(len(r.ids))
+	}, r.collection)
+
+	r.dirtyGauge().Set(func() float64 {
+		return float64(r.dirty.Load())
+	}, r.collection)
+
+	if r.resident == nil {
+		return
+	}
+
+	r.residentGauge().Set(func() float64 {
+		return float64(r.Stats().Resident)
+	}, r.collection)
+
+	r.hits = r.metrics.Counter(hitsMetric, "Lookups of a bounded cache served from memory.", "collection")
+	r.misses = r.metrics.Counter(missesMetric, "Lookups of a bounded cache read from storage.", "collection")
+	r.evictions = r.metrics.Counter(evictionsMetric, "Records evicted from a bounded cache.", "collection")
+}
+
+// unregisterMetrics stops reporting the cache's gauges.
+func (r *RecordCache) unregisterMetrics() {
+	if r.metrics == nil {
+		return
+	}
+
+	r.recordsGauge().Delete(r.collection)
+	r.dirtyGauge().Delete(r.collection)
+
+	if r.resident != nil {
+		r.residentGauge().Delete(r.collection)
+	}
+}
+
+func (r *RecordCache) recordsGauge() *metrics.GaugeFunc {
+	return r.metrics.GaugeFunc(recordsMetric, "Number of records stored, whether in memory or not.", "collection")
+}
+
+func (r *RecordCache) residentGauge() *metrics.GaugeFunc {
+	return r.metrics.GaugeFunc(residentMetric, "Number of records a bounded cache holds in memory.", "collection")
+}
+
+func (r *RecordCache) dirtyGauge() *metrics.GaugeFunc {
+	return r.metrics.GaugeFunc(dirtyMetric, "Number of changes not yet saved to the snapshot.", "collection")
+}
@@ -3,7 +3,12 @@
 package mocks
 
 import (
+	context "context"
+
+	cache "zabbix-technical-task/pkg/cache"
+
 	mock "github.com/stretchr/testify/mock"
+
 	userrecord "zabbix-technical-task/pkg/userrecord"
 )
 
@@ -12,17 +17,17 @@ type Cache struct {
 	mock.Mock
 }
 
-// Add provides a mock function with given fields: id, record
-func (_m *Cache) Add(id uint64, record userrecord.Record) error {
-	ret := _m.Called(id, record)
+// Add provides a mock function with given fields: ctx, id, record
+func (_m *Cache) Add(ctx context.Context, id uint64, record userrecord.Record) error {
+	ret := _m.Called(ctx, id, record)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Add")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(uint64, userrecord.Record) error); ok {
-		r0 = rf(id, record)
+	if rf, ok := ret.Get(0).(func(context.Context, uint64, userrecord.Record) error); ok {
+		r0 = rf(ctx, id, record)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -30,17 +35,75 @@ func (_m *Cache) Add(id uint64, record userrecord.Record) error {
 	return r0
 }
 
-// Delete provides a mock function with given fields: id
-func (_m *Cache) Delete(id uint64) error {
-	ret := _m.Called(id)
+// Apply provides a mock function with given fields: ctx, ops
+func (_m *Cache) Apply(ctx context.Context, ops []cache.Operation) ([]cache.Result, error) {
+	ret := _m.Called(ctx, ops)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Apply")
+	}
+
+	var r0 []cache.Result
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []cache.Operation) ([]cache.Result, error)); ok {
+		return rf(ctx, ops)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []cache.Operation) []cache.Result); ok {
+		r0 = rf(ctx, ops)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]cache.Result)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []cache.Operation) error); ok {
+		r1 = rf(ctx, ops)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Create provides a mock function with given fields: ctx, record
+func (_m *Cache) Create(ctx context.Context, record userrecord.Record) (uint64, error) {
+	ret := _m.Called(ctx, record)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Create")
+	}
+
+	var r0 uint64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, userrecord.Record) (uint64, error)); ok {
+		return rf(ctx, record)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, userrecord.Record) uint64); ok {
+		r0 = rf(ctx, record)
+	} else {
+		r0 = ret.Get(0).(uint64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, userrecord.Record) error); ok {
+		r1 = rf(ctx, record)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Delete provides a mock function with given fields: ctx, id, versions
+func (_m *Cache) Delete(ctx context.Context, id uint64, versions []string) error {
+	ret := _m.Called(ctx, id, versions)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Delete")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(uint64) error); ok {
-		r0 = rf(id)
+	if rf, ok := ret.Get(0).(func(context.Context, uint64, []string) error); ok {
+		r0 = rf(ctx, id, versions)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -48,9 +111,9 @@ func (_m *Cache) Delete(id uint64) error {
 	return r0
 }
 
-// Get provides a mock function with given fields: id
-func (_m *Cache) Get(id uint64) (userrecord.Record, error) {
-	ret := _m.Called(id)
+// Get provides a mock function with given fields: ctx, id
+func (_m *Cache) Get(ctx context.Context, id uint64) (userrecord.Record, error) {
+	ret := _m.Called(ctx, id)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Get")
@@ -58,19 +121,77 @@ func (_m *Cache) Get(id uint64) (userrecord.Record, error) {
 
 	var r0 userrecord.Record
 	var r1 error
-	if rf, ok := ret.Get(0).(func(uint64) (userrecord.Record, error)); ok {
-		return rf(id)
+	if rf, ok := ret.Get(0).(func(context.Context, uint64) (userrecord.Record, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uint64) userrecord.Record); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(userrecord.Record)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uint64) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// List provides a mock function with given fields: ctx, opts
+func (_m *Cache) List(ctx context.Context, opts cache.ListOptions) (cache.Page, error) {
+	ret := _m.Called(ctx, opts)
+
+	if len(ret) == 0 {
+		panic("no return value specified for List")
+	}
+
+	var r0 cache.Page
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, cache.ListOptions) (cache.Page, error)); ok {
+		return rf(ctx, opts)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, cache.ListOptions) cache.Page); ok {
+		r0 = rf(ctx, opts)
+	} else {
+		r0 = ret.Get(0).(cache.Page)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, cache.ListOptions) error); ok {
+		r1 = rf(ctx, opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Patch provides a mock function with given fields: ctx, id, patch, versions
+func (_m *Cache) Patch(ctx context.Context, id uint64, patch userrecord.Patch, versions []string) (userrecord.Record, error) {
+	ret := _m.Called(ctx, id, patch, versions)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Patch")
+	}
+
+	var r0 userrecord.Record
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uint64, userrecord.Patch, []string) (userrecord.Record, error)); ok {
+		return rf(ctx, id, patch, versions)
 	}
-	if rf, ok := ret.Get(0).(func(uint64) userrecord.Record); ok {
-		r0 = rf(id)
+	if rf, ok := ret.Get(0).(func(context.Context, uint64, userrecord.Patch, []string) userrecord.Record); ok {
+		r0 = rf(ctx, id, patch, versions)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(userrecord.Record)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(uint64) error); ok {
-		r1 = rf(id)
+	if rf, ok := ret.Get(1).(func(context.Context, uint64, userrecord.Patch, []string) error); ok {
+		r1 = rf(ctx, id, patch, versions)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -78,17 +199,17 @@ func (_m *Cache) Get(id uint64) (userrecord.Record, error) {
 	return r0, r1
 }
 
-// SaveRecords provides a mock function with no fields
-func (_m *Cache) SaveRecords() error {
-	ret := _m.Called()
+// SaveRecords provides a mock function with given fields: ctx
+func (_m *Cache) SaveRecords(ctx context.Context) error {
+	ret := _m.Called(ctx)
 
 	if len(ret) == 0 {
 		panic("no return value specified for SaveRecords")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -96,17 +217,17 @@ func (_m *Cache) SaveRecords() error {
 	return r0
 }
 
-// Update provides a mock function with given fields: id, record
-func (_m *Cache) Update(id uint64, record userrecord.Record) error {
-	ret := _m.Called(id, record)
+// Update provides a mock function with given fields: ctx, id, record, versions
+func (_m *Cache) Update(ctx context.Context, id uint64, record userrecord.Record, versions []string) error {
+	ret := _m.Called(ctx, id, record, versions)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Update")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(uint64, userrecord.Record) error); ok {
-		r0 = rf(id, record)
+	if rf, ok := ret.Get(0).(func(context.Context, uint64, userrecord.Record, []string) error); ok {
+		r0 = rf(ctx, id, record, versions)
 	} else {
 		r0 = ret.Error(0)
 	}
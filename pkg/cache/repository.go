@@ -1,25 +1,149 @@
 package cache
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"zabbix-technical-task/pkg/userrecord"
 )
 
-const maxUnbackedRecords = 49
+// Kinds of transaction operations.
+const (
+	OpCreate OpKind = "create"
+	OpUpdate OpKind = "update"
+	OpPatch  OpKind = "patch"
+	OpDelete OpKind = "delete"
+)
+
+const (
+	defaultFlushInterval  = 5 * time.Second
+	defaultFlushThreshold = 50
+	defaultListLimit      = 100
+	maxListLimit          = 1000
+)
 
 var (
-	errRecordExists   = errors.New("record already exists")
-	errRecordNotFound = errors.New("record not found")
-	errIDCannotChange = errors.New("cannot change record ID")
-	errSaveRecords    = errors.New("failed to write records to file")
+	errUnknownOp = errors.New("unknown transaction operation")
+
+	// ErrSaveRecords is returned when the records cannot be saved to storage.
+	ErrSaveRecords = errors.New("failed to write records to file")
+	// ErrLogRecord is returned by writes whose change cannot be logged; the change is not applied.
+	ErrLogRecord = errors.New("failed to log record change")
+	// ErrReadRecord is returned when a bounded cache cannot read a record it does not hold from storage.
+	ErrReadRecord = errors.New("failed to read record from storage")
+
+	// ErrRecordNotFound is returned when no record has the requested id.
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrIDCannotChange is returned by writes that would change or remove a record's id.
+	ErrIDCannotChange = errors.New("cannot change record ID")
+	// ErrPreconditionFailed is returned by transactions whose precondition does not hold.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrRecordExists is returned by Add when a record with the same id is already stored.
+	ErrRecordExists = errors.New("record already exists")
+	// ErrInvalidCursor is returned by List when the cursor was not produced by a previous page.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrVersionMismatch is returned by conditional writes when the record has changed.
+	ErrVersionMismatch = errors.New("record version mismatch")
+	// ErrIDsExhausted is returned when every record id has been handed out.
+	ErrIDsExhausted = errors.New("no record IDs left")
+	// ErrDuplicateValue is returned by writes that would violate a unique index.
+	ErrDuplicateValue = errors.New("duplicate value in unique field")
+	// ErrClosed is returned by writes to a cache that has been closed or discarded.
+	ErrClosed = errors.New("cache is closed")
 )
 
+// Filter matches records whose Field equals Value, or contains it if the field is an array.
+type Filter struct {
+	Field string
+	Value string
+}
+
+// ListOptions selects a page of records ordered by id.
+type ListOptions struct {
+	// Cursor is the NextCursor of the previous page; empty starts from the first record.
+	Cursor string
+	// Limit is the maximum number of records in the page, capped at 1000. Defaults to 100.
+	Limit int
+	// Descending orders the records from the highest id to the lowest.
+	Descending bool
+	// Filters must all match for a record to be listed.
+	Filters []Filter
+}
+
+// Page is a single page of listed records.
+type Page struct {
+	Records []userrecord.Record
+	// NextCursor continues the listing after this page; empty if there are no more records.
+	NextCursor string
+}
+
+// OpKind identifies what a transaction operation does.
+type OpKind string
+
+// Operation is a single step of a transaction.
+type Operation struct {
+	Kind OpKind
+	// ID is the record the operation applies to. It is ignored by OpCreate,
+	// which takes the id from Record or assigns the next free one.
+	ID     uint64
+	Record userrecord.Record // for OpCreate and OpUpdate
+	Patch  userrecord.Patch  // for OpPatch
+	// Precondition must hold before the operation is applied.
+	Precondition Precondition
+}
+
+// Precondition constrains the record an operation applies to.
+type Precondition struct {
+	// Exists, if set, requires the record to exist or not to exist.
+	Exists *bool
+	// Version, if set, requires the record's current version to equal it.
+	Version string
+}
+
+// Result is the outcome of a single transaction operation.
+type Result struct {
+	ID uint64
+	// Record is the record after the operation, nil if it was deleted.
+	Record userrecord.Record
+}
+
+// TransactionError reports the operation that made a transaction roll back.
+type TransactionError struct {
+	Index int
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *TransactionError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the error of the failed operation.
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+// Reader defines the read operations of a cache.
+type Reader interface {
+	Get(ctx context.Context, id uint64) (userrecord.Record, error)
+	List(ctx context.Context, opts ListOptions) (Page, error)
+}
+
+// Writer defines the write operations of a cache.
+type Writer interface {
+	Add(ctx context.Context, id uint64, record userrecord.Record) error
+	Create(ctx context.Context, record userrecord.Record) (uint64, error)
+	Update(ctx context.Context, id uint64, record userrecord.Record, versions []string) error
+	Patch(ctx context.Context, id uint64, patch userrecord.Patch, versions []string) (userrecord.Record, error)
+	Delete(ctx context.Context, id uint64, versions []string) error
+	Apply(ctx context.Context, ops []Operation) ([]Result, error)
+}
+
 // Cache defines the interface for cache operations.
 type Cache interface {
-	Add(id uint64, record userrecord.Record) error
-	Get(id uint64) (userrecord.Record, error)
-	Update(id uint64, record userrecord.Record) error
-	Delete(id uint64) error
-	SaveRecords() error
+	Reader
+	Writer
+	SaveRecords(ctx context.Context) error
 }
@@ -0,0 +1,266 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"zabbix-technical-task/pkg/storage"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// transaction applies operations to the cache while remembering how to undo them.
+type transaction struct {
+	cache   *RecordCache
+	lastID  uint64 // lastID of the cache before the transaction
+	undo    []undoStep
+	entries []storage.Entry
+}
+
+// undoStep restores a record to its state before a transaction changed it.
+type undoStep struct {
+	id       uint64
+	previous userrecord.Record // nil if the record did not exist
+}
+
+// Apply runs ops in order under a single lock acquisition. Either all of them
+// take effect and are persisted as one storage entry, or none does and a
+// *TransactionError names the operation that failed. If ctx is done before
+// all operations are applied, none takes effect and the context's error is returned.
+func (r *RecordCache) Apply(ctx context.Context, ops []Operation) ([]Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, ErrClosed
+	}
+
+	// The changes of a bounded cache cannot be evicted until they are logged or rolled back.
+	defer r.settle()
+
+	tx := &transaction{cache: r, lastID: r.lastID}
+	results := make([]Result, 0, len(ops))
+
+	for i, op := range ops {
+		err := ctx.Err()
+		if err != nil {
+			tx.rollback()
+
+			return nil, fmt.Errorf("applying operation %d: %w", i, err)
+		}
+
+		result, err := tx.apply(ctx, op)
+		if err != nil {
+			tx.rollback()
+
+			return nil, &TransactionError{Index: i, Err: err}
+		}
+
+		results = append(results, result)
+	}
+
+	if len(tx.entries) == 0 {
+		return results, nil
+	}
+
+	err := r.storage.Append(ctx, storage.Entry{Op: storage.OpBatch, Entries: tx.entries})
+	if err != nil {
+		tx.rollback()
+
+		return nil, fmt.Errorf("logging transaction: %w: %w", ErrLogRecord, err)
+	}
+
+	for range tx.entries {
+		r.markDirty()
+	}
+
+	return results, nil
+}
+
+// apply checks the precondition of op and applies it.
+func (t *transaction) apply(ctx context.Context, op Operation) (Result, error) {
+	id := op.ID
+
+	if op.Kind == OpCreate {
+		var err error
+
+		id, err = t.createID(op.Record)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	current, err := t.cache.record(ctx, id)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return Result{}, err
+	}
+
+	exists := err == nil
+
+	err = checkPrecondition(id, current, exists, op.Precondition)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if !exists && op.Kind != OpCreate {
+		return Result{}, fmt.Errorf("record with id %d: %w", id, ErrRecordNotFound)
+	}
+
+	switch op.Kind {
+	case OpCreate:
+		return t.put(storage.OpAdd, id, nil, op.Record)
+	case OpUpdate:
+		return t.update(id, current, op.Record)
+	case OpPatch:
+		record, patchErr := applyPatch(id, current, op.Patch)
+		if patchErr != nil {
+			return Result{}, patchErr
+		}
+
+		return t.put(storage.OpUpdate, id, current, record)
+	case OpDelete:
+		t.remove(id, current)
+
+		return Result{ID: id}, nil
+	default:
+		return Result{}, fmt.Errorf("operation %q: %w", op.Kind, errUnknownOp)
+	}
+}
+
+// createID returns the id a created record is stored under: its own id, which
+// must be unused, or the next free one, which is then set on the record.
+func (t *transaction) createID(record userrecord.Record) (uint64, error) {
+	if _, hasID := record["id"]; hasID {
+		err := record.Validate()
+		if err != nil {
+			return 0, fmt.Errorf("validating record: %w", err)
+		}
+
+		id, err := record.ID()
+		if err != nil {
+			return 0, fmt.Errorf("getting record ID: %w", err)
+		}
+
+		if t.cache.exists(id) {
+			return 0, fmt.Errorf("record with id %d: %w", id, ErrRecordExists)
+		}
+
+		return id, nil
+	}
+
+	if t.cache.lastID == math.MaxUint64 {
+		return 0, ErrIDsExhausted
+	}
+
+	id := t.cache.lastID + 1
+	record["id"] = id
+
+	err := record.ValidateSchema()
+	if err != nil {
+		return 0, fmt.Errorf("validating record: %w", err)
+	}
+
+	return id, nil
+}
+
+// update replaces the current record with id by record, which must keep the id.
+func (t *transaction) update(id uint64, current, record userrecord.Record) (Result, error) {
+	err := record.Validate()
+	if err != nil {
+		return Result{}, fmt.Errorf("validating record: %w", err)
+	}
+
+	baseID, err := record.ID()
+	if err != nil {
+		return Result{}, fmt.Errorf("getting record ID: %w", err)
+	}
+
+	if id != baseID {
+		return Result{}, fmt.Errorf("cannot change record's id from %d to %d: %w", id, baseID, ErrIDCannotChange)
+	}
+
+	return t.put(storage.OpUpdate, id, current, record)
+}
+
+// put replaces previous, the current record with id or nil if there is none,
+// by record, keeping the ids and indexes up to date.
+func (t *transaction) put(op storage.Operation, id uint64, previous, record userrecord.Record) (Result, error) {
+	r := t.cache
+
+	err := r.checkUnique(id, record)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if previous != nil {
+		r.unindexRecord(id, previous)
+	}
+
+	t.undo = append(t.undo, undoStep{id: id, previous: previous})
+	t.entries = append(t.entries, storage.Entry{Op: op, ID: id, Record: record})
+
+	r.storePending(id, record)
+	r.insertID(id)
+	r.indexRecord(id, record)
+	r.lastID = max(r.lastID, id)
+
+	return Result{ID: id, Record: record}, nil
+}
+
+// remove deletes the current record with id.
+func (t *transaction) remove(id uint64, current userrecord.Record) {
+	r := t.cache
+
+	t.undo = append(t.undo, undoStep{id: id, previous: current})
+	t.entries = append(t.entries, storage.Entry{Op: storage.OpDelete, ID: id})
+
+	r.unindexRecord(id, current)
+	r.drop(id)
+	r.removeID(id)
+}
+
+// rollback undoes all changes made by the transaction, newest first.
+func (t *transaction) rollback() {
+	r := t.cache
+
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		step := t.undo[i]
+
+		// Records changed by the transaction are pending, so they are still in memory.
+		if current, exists := r.cached(step.id); exists {
+			r.unindexRecord(step.id, current)
+		}
+
+		if step.previous == nil {
+			r.drop(step.id)
+			r.removeID(step.id)
+
+			continue
+		}
+
+		r.store(step.id, step.previous)
+		r.insertID(step.id)
+		r.indexRecord(step.id, step.previous)
+	}
+
+	r.lastID = t.lastID
+}
+
+// checkPrecondition returns ErrPreconditionFailed or ErrVersionMismatch
+// if the record with id does not satisfy p.
+func checkPrecondition(id uint64, current userrecord.Record, exists bool, p Precondition) error {
+	if p.Exists != nil && *p.Exists != exists {
+		return fmt.Errorf("record with id %d exists: %t: %w", id, exists, ErrPreconditionFailed)
+	}
+
+	if p.Version == "" {
+		return nil
+	}
+
+	if !exists {
+		return fmt.Errorf("record with id %d has no version: %w", id, ErrPreconditionFailed)
+	}
+
+	return checkVersion(id, current, []string{p.Version})
+}
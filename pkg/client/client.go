@@ -0,0 +1,530 @@
+// Package client calls the records HTTP API with methods that mirror those of
+// cache.Cache, retrying requests the server could not serve for the moment.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+const (
+	defaultRetries = 3
+	defaultBackoff = 100 * time.Millisecond
+	// maxBackoff caps the wait between two attempts, including a Retry-After.
+	maxBackoff = 10 * time.Second
+)
+
+var (
+	_ cache.Reader = (*Client)(nil)
+
+	errInvalidURL = errors.New("invalid server URL")
+	// ErrUnexpectedResponse is returned when a response cannot be decoded.
+	ErrUnexpectedResponse = errors.New("unexpected response")
+)
+
+// codeErrors maps the codes of problem responses to the errors the server
+// reported them for, so that errors.Is works as it does against a local cache.
+var codeErrors = map[string]error{
+	"missing_id":          userrecord.ErrNoID,
+	"invalid_id":          userrecord.ErrInvalidID,
+	"schema_violation":    userrecord.ErrSchemaViolation,
+	"record_not_found":    cache.ErrRecordNotFound,
+	"record_exists":       cache.ErrRecordExists,
+	"id_cannot_change":    cache.ErrIDCannotChange,
+	"precondition_failed": cache.ErrPreconditionFailed,
+	"version_mismatch":    cache.ErrVersionMismatch,
+	"invalid_cursor":      cache.ErrInvalidCursor,
+	"duplicate_value":     cache.ErrDuplicateValue,
+	"ids_exhausted":       cache.ErrIDsExhausted,
+}
+
+// Client calls the records API of a server.
+type Client struct {
+	// records is the URL of the records of the collection the client works on.
+	records    *url.URL
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	collection string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// Error is a problem response of the server. It unwraps to the error of the
+// cache or userrecord package the server reported, if its code names one.
+type Error struct {
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+	// Violations lists where a record does not match the schema.
+	Violations []userrecord.Violation `json:"violations,omitempty"`
+}
+
+// request is a call of the API.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// response is a response of the API other than a problem.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// listResponse is the body of a GET /records response.
+type listResponse struct {
+	Records    []userrecord.Record `json:"records"`
+	NextCursor string              `json:"next_cursor"`
+}
+
+// WithHTTPClient sets the HTTP client requests are sent with. The default is
+// http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a request is repeated after it was answered
+// with 429 or 503 Service Unavailable with Retry-After, or, for requests other
+// than POST, after it was answered with 502, 503 or 504 or could not be
+// reached. A POST answered by a proxy may have been applied, so repeating it
+// could create the record twice. The default is 3.
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.retries = max(n, 0)
+	}
+}
+
+// WithBackoff sets the wait before the first retry, which doubles with every
+// further one unless the server asks for another with Retry-After. The default is 100ms.
+func WithBackoff(d time.Duration) Option {
+	return func(c *Client) {
+		c.backoff = d
+	}
+}
+
+// WithCollection makes the client work on the named collection instead of
+// the default one.
+func WithCollection(name string) Option {
+	return func(c *Client) {
+		c.collection = name
+	}
+}
+
+// New creates a client of the server at baseURL, such as http://localhost:8080.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("%q: %w", baseURL, errInvalidURL)
+	}
+
+	c := &Client{
+		httpClient: http.DefaultClient,
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	records := "records"
+	if c.collection != "" {
+		records = "collections/" + url.PathEscape(c.collection) + "/records"
+	}
+
+	c.records = base.JoinPath(records)
+
+	return c, nil
+}
+
+// Add stores record under id, which must not be taken. A record without an id
+// gets id; one with another id is refused with cache.ErrIDCannotChange.
+func (c *Client) Add(ctx context.Context, id uint64, record userrecord.Record) error {
+	record, err := withID(record, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.post(ctx, record)
+
+	return err
+}
+
+// Create stores record under its id, or under the next free one if it has
+// none, and returns the id.
+func (c *Client) Create(ctx context.Context, record userrecord.Record) (uint64, error) {
+	created, err := c.post(ctx, record)
+	if err != nil {
+		return 0, err
+	}
+
+	return created.ID()
+}
+
+// Get returns the record with id.
+func (c *Client) Get(ctx context.Context, id uint64) (userrecord.Record, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: formatID(id)})
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRecord(resp.body)
+}
+
+// Update replaces the record with id. If versions are given, the current
+// version of the record must be one of them.
+func (c *Client) Update(ctx context.Context, id uint64, record userrecord.Record, versions []string) error {
+	record, err := withID(record, id)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding record: %w", err)
+	}
+
+	_, err = c.do(ctx, request{method: http.MethodPut, path: formatID(id), header: ifMatch(versions), body: body})
+
+	return err
+}
+
+// Delete deletes the record with id. If versions are given, the current
+// version of the record must be one of them.
+func (c *Client) Delete(ctx context.Context, id uint64, versions []string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: formatID(id), header: ifMatch(versions)})
+
+	return err
+}
+
+// List returns a page of records ordered by id, selected by opts as by cache.Cache.List.
+func (c *Client) List(ctx context.Context, opts cache.ListOptions) (cache.Page, error) {
+	query := url.Values{}
+
+	for _, filter := range opts.Filters {
+		query.Add(filter.Field, filter.Value)
+	}
+
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	if opts.Cursor != "" {
+		query.Set("cursor", opts.Cursor)
+	}
+
+	if opts.Descending {
+		query.Set("sort", "-id")
+	}
+
+	resp, err := c.do(ctx, request{method: http.MethodGet, query: query})
+	if err != nil {
+		return cache.Page{}, err
+	}
+
+	var list listResponse
+
+	err = json.Unmarshal(resp.body, &list)
+	if err != nil {
+		return cache.Page{}, fmt.Errorf("decoding page: %w: %w", ErrUnexpectedResponse, err)
+	}
+
+	for _, record := range list.Records {
+		err = record.ValidateID()
+		if err != nil {
+			return cache.Page{}, fmt.Errorf("decoding page: %w: %w", ErrUnexpectedResponse, err)
+		}
+	}
+
+	return cache.Page{Records: list.Records, NextCursor: list.NextCursor}, nil
+}
+
+// Watch polls the record with id every interval and calls fn with it when it
+// first appears and whenever it changes, and with nil when it is deleted. The
+// server is only asked whether the record changed, using its version as an
+// ETag. Watch returns the first error of fn or of a request, or the context's
+// error once ctx is done.
+func (c *Client) Watch(ctx context.Context, id uint64, interval time.Duration, fn func(userrecord.Record) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		version string
+		seen    bool // whether fn was called, so that a missing record is reported once
+	)
+
+	for {
+		header := http.Header{}
+		if version != "" {
+			header.Set("If-None-Match", `"`+version+`"`)
+		}
+
+		resp, err := c.do(ctx, request{method: http.MethodGet, path: formatID(id), header: header})
+
+		switch {
+		case errors.Is(err, cache.ErrRecordNotFound):
+			if version != "" || !seen {
+				version, seen = "", true
+
+				err = fn(nil)
+			} else {
+				err = nil
+			}
+		case err != nil:
+		case resp.status == http.StatusNotModified:
+		default:
+			var record userrecord.Record
+
+			record, err = decodeRecord(resp.body)
+			if err == nil {
+				version, seen = strings.Trim(resp.header.Get("ETag"), `"`), true
+
+				err = fn(record)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("watching record with id %d: %w", id, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// post adds record to the collection and returns the stored record.
+func (c *Client) post(ctx context.Context, record userrecord.Record) (userrecord.Record, error) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("encoding record: %w", err)
+	}
+
+	resp, err := c.do(ctx, request{method: http.MethodPost, body: body})
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRecord(resp.body)
+}
+
+// do sends req, repeating it while the server is unavailable and retries are
+// left, and returns the response. Problem responses are returned as *Error.
+func (c *Client) do(ctx context.Context, req request) (*response, error) {
+	target := c.records.JoinPath(req.path)
+	target.RawQuery = req.query.Encode()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req, target.String())
+		if err != nil {
+			// A POST that got no answer may have been applied, so it is not repeated.
+			if ctx.Err() != nil || req.method == http.MethodPost || attempt == c.retries {
+				return nil, err
+			}
+
+			err = c.wait(ctx, attempt, "")
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if retryable(req.method, resp) && attempt < c.retries {
+			err = c.wait(ctx, attempt, resp.header.Get("Retry-After"))
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if resp.status >= http.StatusBadRequest {
+			return nil, decodeProblem(resp)
+		}
+
+		return resp, nil
+	}
+}
+
+// send makes a single attempt of req at target.
+func (c *Client) send(ctx context.Context, req request, target string) (*response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(req.body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	maps.Copy(httpReq.Header, req.header)
+	httpReq.Header.Set("Accept", "application/json")
+
+	if req.body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", req.method, target, err)
+	}
+
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: reading response: %w", req.method, target, err)
+	}
+
+	return &response{status: httpResp.StatusCode, header: httpResp.Header, body: body}, nil
+}
+
+// wait sleeps before the retry after attempt, for retryAfter seconds if the
+// server sent them and for the doubled backoff otherwise.
+func (c *Client) wait(ctx context.Context, attempt int, retryAfter string) error {
+	delay := c.backoff << attempt
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+
+	timer := time.NewTimer(min(delay, maxBackoff))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("waiting to retry: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%d %s (%s)", e.Status, e.Title, e.Code)
+	}
+
+	return fmt.Sprintf("%d %s (%s): %s", e.Status, e.Title, e.Code, e.Detail)
+}
+
+// Unwrap returns the error the server reported, or nil if the code names none.
+func (e *Error) Unwrap() error {
+	return codeErrors[e.Code]
+}
+
+// retryable reports whether a request with method, answered with resp, may
+// succeed if repeated. A POST is only repeated if it cannot have been applied:
+// if it was rate limited, or if the server refused it with Retry-After, as it
+// does while it is starting. A gateway error may follow a POST the server applied.
+func retryable(method string, resp *response) bool {
+	switch resp.status {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return method != http.MethodPost || resp.header.Get("Retry-After") != ""
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return method != http.MethodPost
+	default:
+		return false
+	}
+}
+
+// decodeProblem returns the problem in resp. Bodies that are not problems,
+// such as those of proxies, keep the status with its text as title.
+func decodeProblem(resp *response) *Error {
+	problem := &Error{}
+
+	err := json.Unmarshal(resp.body, problem)
+	if err != nil || problem.Title == "" {
+		problem = &Error{Title: http.StatusText(resp.status), Detail: strings.TrimSpace(string(resp.body))}
+	}
+
+	problem.Status = resp.status
+
+	return problem
+}
+
+// decodeRecord decodes a record and turns its id into a uint64.
+func decodeRecord(body []byte) (userrecord.Record, error) {
+	var record userrecord.Record
+
+	err := json.Unmarshal(body, &record)
+	if err != nil {
+		return nil, fmt.Errorf("decoding record: %w: %w", ErrUnexpectedResponse, err)
+	}
+
+	err = record.ValidateID()
+	if err != nil {
+		return nil, fmt.Errorf("decoding record: %w: %w", ErrUnexpectedResponse, err)
+	}
+
+	return record, nil
+}
+
+// ifMatch returns the If-Match header that requires one of versions, or no header without any.
+func ifMatch(versions []string) http.Header {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	tags := make([]string, 0, len(versions))
+	for _, version := range versions {
+		tags = append(tags, `"`+version+`"`)
+	}
+
+	return http.Header{"If-Match": {strings.Join(tags, ", ")}}
+}
+
+// withID returns a copy of record with id, which it gets if it has no id.
+// Another id is refused with cache.ErrIDCannotChange.
+func withID(record userrecord.Record, id uint64) (userrecord.Record, error) {
+	record = maps.Clone(record)
+	if record == nil {
+		record = userrecord.Record{}
+	}
+
+	if _, ok := record["id"]; !ok {
+		record["id"] = id
+
+		return record, nil
+	}
+
+	err := record.ValidateID()
+	if err != nil {
+		return nil, fmt.Errorf("validating record: %w", err)
+	}
+
+	recordID, _ := record.ID()
+	if recordID != id {
+		return nil, fmt.Errorf("cannot store record with id %d under id %d: %w", recordID, id, cache.ErrIDCannotChange)
+	}
+
+	return record, nil
+}
+
+// formatID returns id as a path segment.
+func formatID(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
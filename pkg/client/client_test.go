@@ -0,0 +1,330 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zabbix-technical-task/internal/health"
+	"zabbix-technical-task/internal/router"
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/collection"
+	"zabbix-technical-task/pkg/metrics"
+	"zabbix-technical-task/pkg/storage"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// newServer serves the records API of an in-memory cache.
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	records := cache.New(t.Context(), storage.NewMemoryStorage())
+	if records == nil {
+		t.Fatal("failed to create cache")
+	}
+
+	collections := collection.NewFileRegistry(t.TempDir(), collection.WithDefault(records),
+		collection.WithBackend(storage.BackendMemory))
+	routes := router.New(records, collections, metrics.NewRegistry(), health.NewChecker())
+
+	srv := httptest.NewServer(routes.Handler)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	c, err := New(newServer(t).URL, WithBackoff(time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := c.Create(ctx, userrecord.Record{"name": "a", "tag": "x"})
+	if err != nil || id != 1 {
+		t.Fatalf("expected record 1 to be created, got %d, %v", id, err)
+	}
+
+	err = c.Add(ctx, 5, userrecord.Record{"name": "b", "tag": "x"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = c.Add(ctx, 5, userrecord.Record{"name": "c"})
+	if !errors.Is(err, cache.ErrRecordExists) {
+		t.Errorf("expected ErrRecordExists, got %v", err)
+	}
+
+	var problem *Error
+	if !errors.As(err, &problem) || problem.Status != http.StatusConflict || problem.Code != "record_exists" {
+		t.Errorf("expected a 409 record_exists problem, got %#v", problem)
+	}
+
+	record, err := c.Get(ctx, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := userrecord.Record{"id": uint64(5), "name": "b", "tag": "x"}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("expected %v, got %v", want, record)
+	}
+
+	_, err = c.Get(ctx, 2)
+	if !errors.Is(err, cache.ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	version, err := record.Version()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = c.Update(ctx, 5, userrecord.Record{"name": "B", "tag": "x"}, []string{version})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = c.Update(ctx, 5, userrecord.Record{"name": "C"}, []string{version})
+	if !errors.Is(err, cache.ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+
+	opts := cache.ListOptions{Limit: 1, Descending: true, Filters: []cache.Filter{{Field: "tag", Value: "x"}}}
+
+	page, err := c.List(ctx, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(page.Records) != 1 || page.Records[0]["name"] != "B" || page.NextCursor == "" {
+		t.Errorf("expected record 5 and a cursor, got %+v", page)
+	}
+
+	opts.Cursor = page.NextCursor
+
+	page, err = c.List(ctx, opts)
+	if err != nil || len(page.Records) != 1 || page.Records[0]["name"] != "a" || page.NextCursor != "" {
+		t.Errorf("expected record 1 and no cursor, got %+v, %v", page, err)
+	}
+
+	page, err = c.List(ctx, cache.ListOptions{})
+	if err != nil || len(page.Records) != 2 || page.Records[1]["name"] != "B" {
+		t.Errorf("expected records 1 and 5, got %+v, %v", page, err)
+	}
+
+	err = c.Delete(ctx, 5, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = c.Delete(ctx, 5, nil)
+	if !errors.Is(err, cache.ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestAddWithOtherID(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	c, err := New(newServer(t).URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = c.Add(ctx, 5, userrecord.Record{"id": uint64(7), "name": "a"})
+	if !errors.Is(err, cache.ErrIDCannotChange) {
+		t.Errorf("expected ErrIDCannotChange, got %v", err)
+	}
+
+	_, err = c.Get(ctx, 7)
+	if !errors.Is(err, cache.ErrRecordNotFound) {
+		t.Errorf("expected record 7 not to be created, got %v", err)
+	}
+
+	// Ids decoded from JSON are accepted as well.
+	err = c.Add(ctx, 5, userrecord.Record{"id": float64(5), "name": "a"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCollection(t *testing.T) {
+	t.Parallel()
+
+	srv := newServer(t)
+
+	c, err := New(srv.URL, WithCollection("users"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = c.Create(t.Context(), userrecord.Record{"name": "a"})
+
+	var problem *Error
+	if !errors.As(err, &problem) || problem.Code != "collection_not_found" {
+		t.Errorf("expected collection_not_found, got %v", err)
+	}
+
+	_, err = New("localhost:8080")
+	if !errors.Is(err, errInvalidURL) {
+		t.Errorf("expected errInvalidURL, got %v", err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"title":"Service Unavailable","status":503,"code":"starting"}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name    string
+		retries int
+		calls   int32
+		wantErr bool
+	}{
+		{"recovers", 3, 3, false},
+		{"gives up", 1, 2, true},
+	}
+
+	for _, tt := range tests {
+		calls.Store(0)
+
+		c, err := New(srv.URL, WithRetries(tt.retries), WithBackoff(time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = c.Get(t.Context(), 1)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+
+		if got := calls.Load(); got != tt.calls {
+			t.Errorf("%s: expected %d calls, got %d", tt.name, tt.calls, got)
+		}
+	}
+}
+
+func TestPostRetries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		calls      int32
+	}{
+		{"rate limited", http.StatusTooManyRequests, "", 2},
+		{"starting", http.StatusServiceUnavailable, "0", 2},
+		{"proxy unavailable", http.StatusServiceUnavailable, "", 1},
+		{"bad gateway", http.StatusBadGateway, "", 1},
+		{"gateway timeout", http.StatusGatewayTimeout, "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if calls.Add(1) == 1 {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+
+					w.WriteHeader(tt.status)
+
+					return
+				}
+
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"id":1}`))
+			}))
+			t.Cleanup(srv.Close)
+
+			c, err := New(srv.URL, WithBackoff(time.Millisecond))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = c.Create(t.Context(), userrecord.Record{})
+			if (err == nil) != (tt.calls > 1) {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if got := calls.Load(); got != tt.calls {
+				t.Errorf("expected %d calls, got %d", tt.calls, got)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	srv := newServer(t)
+
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	// Every step runs when the watch reports the state left by the previous one.
+	steps := []func() error{
+		func() error { return c.Add(ctx, 1, userrecord.Record{"name": "a"}) },
+		func() error { return c.Update(ctx, 1, userrecord.Record{"name": "b"}, nil) },
+		func() error { return c.Delete(ctx, 1, nil) },
+		func() error { cancel(); return nil },
+	}
+
+	var seen []any
+
+	err = c.Watch(ctx, 1, time.Millisecond, func(record userrecord.Record) error {
+		if record == nil {
+			seen = append(seen, nil)
+		} else {
+			seen = append(seen, record["name"])
+		}
+
+		step := steps[0]
+		steps = steps[1:]
+
+		return step()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	want := []any{nil, "a", "b", nil}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("expected changes %v, got %v", want, seen)
+	}
+}
@@ -0,0 +1,356 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/metrics"
+	"zabbix-technical-task/pkg/storage"
+)
+
+var (
+	_ Registry = (*FileRegistry)(nil)
+
+	validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+)
+
+// droppedPrefix starts the name a collection's directory is renamed to before
+// it is removed. Such names are not valid collection names, so Open skips them.
+const droppedPrefix = ".dropped-"
+
+// FileRegistry keeps every collection in its own directory, named after the
+// collection, below a common directory.
+type FileRegistry struct {
+	mu          sync.RWMutex
+	dir         string
+	collections map[string]*collection
+	// dropping holds the names of collections whose directories are being removed.
+	dropping     map[string]bool
+	defaultCache cache.Cache
+	cacheOpts    []cache.Option
+	backend      storage.Backend
+	storageOpts  []storage.Option
+	metrics      *metrics.Registry
+}
+
+// collection is an open collection owned by the registry.
+type collection struct {
+	cache   *cache.RecordCache
+	storage storage.Store
+}
+
+// Option configures a FileRegistry.
+type Option func(*FileRegistry)
+
+// WithDefault serves records, which the registry neither closes nor drops,
+// as the default collection.
+func WithDefault(records cache.Cache) Option {
+	return func(f *FileRegistry) {
+		f.defaultCache = records
+	}
+}
+
+// WithCacheOptions sets the options of the caches of all collections.
+func WithCacheOptions(opts ...cache.Option) Option {
+	return func(f *FileRegistry) {
+		f.cacheOpts = opts
+	}
+}
+
+// WithBackend sets the storage backend of all collections. The default is storage.BackendFile.
+func WithBackend(backend storage.Backend) Option {
+	return func(f *FileRegistry) {
+		f.backend = backend
+	}
+}
+
+// WithStorageOptions sets the options of the storages of all collections.
+func WithStorageOptions(opts ...storage.Option) Option {
+	return func(f *FileRegistry) {
+		f.storageOpts = opts
+	}
+}
+
+// WithMetrics reports the metrics of every collection's cache in reg,
+// labelled with the collection name.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(f *FileRegistry) {
+		f.metrics = reg
+	}
+}
+
+// NewFileRegistry creates a registry of the collections stored below dir.
+// Existing collections are opened by Open.
+func NewFileRegistry(dir string, opts ...Option) *FileRegistry {
+	f := &FileRegistry{
+		dir:         dir,
+		backend:     storage.BackendFile,
+		collections: make(map[string]*collection),
+		dropping:    make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// Open opens all collections found in the registry's directory.
+func (f *FileRegistry) Open(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := os.ReadDir(f.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("reading %q: %w", f.dir, errReadDir)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !validName.MatchString(entry.Name()) || entry.Name() == DefaultName {
+			continue
+		}
+
+		c, err := f.open(ctx, entry.Name())
+		if err != nil {
+			return err
+		}
+
+		f.collections[entry.Name()] = c
+	}
+
+	return nil
+}
+
+// Create creates an empty collection.
+func (f *FileRegistry) Create(ctx context.Context, name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("collection %q: %w", name, ErrInvalidName)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.collections[name]; exists || f.dropping[name] || name == DefaultName {
+		return fmt.Errorf("collection %q: %w", name, ErrCollectionExists)
+	}
+
+	dir := filepath.Join(f.dir, name)
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating %q: %w", dir, errCreateDir)
+	}
+
+	err = storage.Create(f.backend, dir)
+	if err != nil {
+		return fmt.Errorf("creating %q: %w: %w", dir, errCreateDir, err)
+	}
+
+	c, err := f.open(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	f.collections[name] = c
+
+	return nil
+}
+
+// Get returns the cache of the named collection.
+func (f *FileRegistry) Get(name string) (cache.Cache, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if name == DefaultName && f.defaultCache != nil {
+		return f.defaultCache, nil
+	}
+
+	c, exists := f.collections[name]
+	if !exists {
+		return nil, fmt.Errorf("collection %q: %w", name, ErrCollectionNotFound)
+	}
+
+	return c.cache, nil
+}
+
+// List returns the names of all collections in ascending order.
+func (f *FileRegistry) List() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	names := make([]string, 0, len(f.collections)+1)
+	if f.defaultCache != nil {
+		names = append(names, DefaultName)
+	}
+
+	for name := range f.collections {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// Drop deletes the named collection and all of its records. The collection
+// is unlinked first, so that other collections are not held up while its
+// writes in progress finish and its directory is removed. If the directory
+// cannot be moved out of the way, the collection is opened again.
+func (f *FileRegistry) Drop(name string) error {
+	if name == DefaultName {
+		return ErrDropDefault
+	}
+
+	f.mu.Lock()
+
+	c, exists := f.collections[name]
+	if !exists {
+		f.mu.Unlock()
+
+		return fmt.Errorf("collection %q: %w", name, ErrCollectionNotFound)
+	}
+
+	delete(f.collections, name)
+	f.dropping[name] = true
+
+	f.mu.Unlock()
+
+	err := f.drop(name, c)
+
+	f.mu.Lock()
+	delete(f.dropping, name)
+	f.mu.Unlock()
+
+	return err
+}
+
+// drop discards the unlinked collection and removes its directory. The
+// directory is renamed before it is removed, so that a removal that fails
+// half-way does not leave a collection with part of its records behind.
+func (f *FileRegistry) drop(name string, c *collection) error {
+	// The records are about to be deleted, so they are not flushed.
+	c.cache.Discard()
+
+	err := c.storage.Close()
+	if err != nil {
+		slog.Warn("failed to close storage of dropped collection", "collection", name, "err", err)
+	}
+
+	dir := filepath.Join(f.dir, name)
+	dropped := filepath.Join(f.dir, droppedPrefix+name)
+
+	// A directory left over by an earlier drop would make the rename fail.
+	err = os.RemoveAll(dropped)
+	if err == nil {
+		err = os.Rename(dir, dropped)
+	}
+
+	if err != nil {
+		return f.restore(name, fmt.Errorf("removing %q: %w", dir, errRemoveDir))
+	}
+
+	err = os.RemoveAll(dropped)
+	if err != nil {
+		return fmt.Errorf("collection %q is dropped, but removing %q failed: %w", name, dropped, errRemoveDir)
+	}
+
+	return nil
+}
+
+// restore opens the named collection again after it could not be dropped
+// and returns err, joined with the error of opening it if that fails too.
+func (f *FileRegistry) restore(name string, err error) error {
+	c, openErr := f.open(context.Background(), name)
+	if openErr != nil {
+		return errors.Join(err, fmt.Errorf("collection %q is closed: %w", name, openErr))
+	}
+
+	f.mu.Lock()
+	f.collections[name] = c
+	f.mu.Unlock()
+
+	return err
+}
+
+// Close flushes and closes all collections except the default one,
+// abandoning the flushes still running when ctx is done.
+func (f *FileRegistry) Close(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var errs []error
+
+	for name, c := range f.collections {
+		errs = append(errs, c.close(ctx))
+
+		delete(f.collections, name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// FlushErr returns the errors of the last flush of every collection
+// except the default one, or nil if all of them succeeded.
+func (f *FileRegistry) FlushErr() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var errs []error
+
+	for name, c := range f.collections {
+		err := c.cache.FlushErr()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("collection %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// open loads the named collection from its directory.
+func (f *FileRegistry) open(ctx context.Context, name string) (*collection, error) {
+	store, err := storage.New(f.backend, filepath.Join(f.dir, name), f.storageOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("collection %q: %w: %w", name, errOpenCollection, err)
+	}
+
+	cacheOpts := f.cacheOpts
+	if f.metrics != nil {
+		cacheOpts = append(slices.Clip(cacheOpts), cache.WithMetrics(f.metrics, name))
+	}
+
+	records := cache.New(ctx, store, cacheOpts...)
+	if records == nil {
+		return nil, fmt.Errorf("collection %q: %w", name, errOpenCollection)
+	}
+
+	return &collection{cache: records, storage: store}, nil
+}
+
+// close flushes the collection's records and closes its storage.
+func (c *collection) close(ctx context.Context) error {
+	err := c.cache.Close(ctx)
+	if err != nil {
+		return fmt.Errorf("closing cache: %w", err)
+	}
+
+	err = c.storage.Close()
+	if err != nil {
+		return fmt.Errorf("closing storage: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package collection
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"zabbix-technical-task/pkg/cache"
+	"zabbix-technical-task/pkg/cache/mocks"
+	"zabbix-technical-task/pkg/storage"
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+func TestFileRegistry(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	defaultCache := new(mocks.Cache)
+
+	registry := NewFileRegistry(dir, WithDefault(defaultCache), WithCacheOptions(cache.WithFlushInterval(0)))
+
+	err := registry.Open(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"teams", "billing"} {
+		err = registry.Create(t.Context(), name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if names := registry.List(); !slices.Equal(names, []string{"billing", DefaultName, "teams"}) {
+		t.Fatalf("unexpected collections %v", names)
+	}
+
+	errorCases := []struct {
+		name string
+		err  error
+		fn   func() error
+	}{
+		{"existing", ErrCollectionExists, func() error { return registry.Create(t.Context(), "teams") }},
+		{"existing default", ErrCollectionExists, func() error { return registry.Create(t.Context(), DefaultName) }},
+		{"invalid name", ErrInvalidName, func() error { return registry.Create(t.Context(), "../etc") }},
+		{"drop default", ErrDropDefault, func() error { return registry.Drop(DefaultName) }},
+		{"drop missing", ErrCollectionNotFound, func() error { return registry.Drop("missing") }},
+	}
+
+	for _, tc := range errorCases {
+		if err = tc.fn(); !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	if got, _ := registry.Get(DefaultName); got != defaultCache {
+		t.Errorf("expected the default cache, got %v", got)
+	}
+
+	teams, err := registry.Get("teams")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	billing, err := registry.Get("billing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Collections have separate id spaces.
+	for _, records := range []cache.Cache{teams, billing} {
+		err = records.Add(t.Context(), 1, userrecord.Record{"id": uint64(1)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	err = registry.Drop("billing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "billing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected dropped collection to be removed, got %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, droppedPrefix+"billing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected renamed directory of dropped collection to be removed, got %v", err)
+	}
+
+	// A write holding on to the dropped cache must not reach its storage.
+	err = billing.Add(t.Context(), 2, userrecord.Record{"id": uint64(2)})
+	if !errors.Is(err, cache.ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	err = registry.Close(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reopened := NewFileRegistry(dir)
+
+	err = reopened.Open(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defer func() {
+		closeErr := reopened.Close(t.Context())
+		if closeErr != nil {
+			t.Errorf("unexpected error: %v", closeErr)
+		}
+	}()
+
+	if names := reopened.List(); !slices.Equal(names, []string{"teams"}) {
+		t.Fatalf("unexpected collections after reopening %v", names)
+	}
+
+	teams, _ = reopened.Get("teams")
+	if _, err = teams.Get(t.Context(), 1); err != nil {
+		t.Errorf("expected record to survive reopening, got %v", err)
+	}
+
+	if _, err = reopened.Get("billing"); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("expected ErrCollectionNotFound, got %v", err)
+	}
+}
+
+func TestFileRegistryBackends(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range []storage.Backend{storage.BackendLog, storage.BackendDir} {
+		t.Run(string(backend), func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			registry := NewFileRegistry(dir, WithBackend(backend), WithCacheOptions(cache.WithFlushInterval(0)))
+
+			err := registry.Create(t.Context(), "teams")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			teams, _ := registry.Get("teams")
+
+			err = teams.Add(t.Context(), 1, userrecord.Record{"id": uint64(1)})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = registry.Close(t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			reopened := NewFileRegistry(dir, WithBackend(backend))
+
+			err = reopened.Open(t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			teams, err = reopened.Get("teams")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err = teams.Get(t.Context(), 1); err != nil {
+				t.Errorf("expected record to survive reopening, got %v", err)
+			}
+
+			err = reopened.Close(t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileRegistryFlushErr(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	registry := NewFileRegistry(dir, WithCacheOptions(cache.WithFlushInterval(0)))
+
+	err := registry.Create(t.Context(), "teams")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = registry.FlushErr(); err != nil {
+		t.Fatalf("expected no flush error, got %v", err)
+	}
+
+	teams, err := registry.Get("teams")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Saving fails once the collection's directory is gone.
+	err = os.RemoveAll(filepath.Join(dir, "teams"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = teams.SaveRecords(t.Context()); err == nil {
+		t.Fatal("expected save into a removed directory to fail")
+	}
+
+	if err = registry.FlushErr(); err == nil {
+		t.Error("expected the failed save to be reported")
+	}
+}
@@ -0,0 +1,37 @@
+package collection
+
+import (
+	"context"
+	"errors"
+
+	"zabbix-technical-task/pkg/cache"
+)
+
+// DefaultName is the name of the collection served under /records.
+const DefaultName = "default"
+
+var (
+	errCreateDir      = errors.New("failed to create collection directory")
+	errReadDir        = errors.New("failed to read collections directory")
+	errRemoveDir      = errors.New("failed to remove collection directory")
+	errOpenCollection = errors.New("failed to open collection")
+
+	// ErrInvalidName is returned for collection names that are not 1 to 64
+	// lowercase letters, digits, '-' or '_', starting with a letter or digit.
+	ErrInvalidName = errors.New("invalid collection name")
+	// ErrCollectionExists is returned when creating a collection that already exists.
+	ErrCollectionExists = errors.New("collection already exists")
+	// ErrCollectionNotFound is returned when no collection has the requested name.
+	ErrCollectionNotFound = errors.New("collection not found")
+	// ErrDropDefault is returned when dropping the default collection.
+	ErrDropDefault = errors.New("cannot drop the default collection")
+)
+
+// Registry defines the interface for managing named collections of records,
+// each with its own cache, storage and id space.
+type Registry interface {
+	Create(ctx context.Context, name string) error
+	Get(name string) (cache.Cache, error)
+	List() []string
+	Drop(name string) error
+}
@@ -0,0 +1,56 @@
+// Package logging carries request IDs in contexts and adds them to the
+// records logged with log/slog.
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// RequestIDKey is the attribute key of the request ID in log records.
+const RequestIDKey = "request_id"
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// handler adds the request ID of the logging context to every record.
+type handler struct {
+	slog.Handler
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// NewHandler wraps next so that records logged with a context carrying
+// a request ID get it as the request_id attribute.
+func NewHandler(next slog.Handler) slog.Handler {
+	return handler{Handler: next}
+}
+
+// Handle adds the request ID of ctx to r before passing it on.
+func (h handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(RequestIDKey, id))
+	}
+
+	return h.Handler.Handle(ctx, r) //nolint:wrapcheck // The wrapped handler's error is passed on as is.
+}
+
+// WithAttrs returns a handler whose records also have attrs.
+func (h handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a handler that qualifies later attributes with name.
+func (h handler) WithGroup(name string) slog.Handler {
+	return handler{Handler: h.Handler.WithGroup(name)}
+}
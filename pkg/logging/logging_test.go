@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected map[string]any
+	}{
+		{
+			name:     "without request ID",
+			ctx:      context.Background(),
+			expected: map[string]any{"level": "INFO", "msg": "saved", "component": "cache", "records": float64(2)},
+		},
+		{
+			name: "with request ID",
+			ctx:  WithRequestID(context.Background(), "abc"),
+			expected: map[string]any{
+				"level": "INFO", "msg": "saved", "component": "cache", "records": float64(2), RequestIDKey: "abc",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+
+			logger := slog.New(NewHandler(slog.NewJSONHandler(&out, &slog.HandlerOptions{
+				ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+
+					return a
+				},
+			})))
+
+			logger.With("component", "cache").InfoContext(tt.ctx, "saved", "records", 2)
+
+			var logged map[string]any
+
+			require.NoError(t, json.Unmarshal(out.Bytes(), &logged))
+			assert.Equal(t, tt.expected, logged)
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, RequestID(context.Background()))
+	assert.Equal(t, "abc", RequestID(WithRequestID(context.Background(), "abc")))
+}
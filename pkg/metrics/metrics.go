@@ -0,0 +1,356 @@
+// Package metrics collects counters, histograms and gauges and exposes them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types as named in the exposition format.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// labelSeparator joins label values into series keys. It cannot occur in valid UTF-8.
+const labelSeparator = "\xff"
+
+var (
+	// DefaultBuckets are histogram upper bounds, in seconds, suited to request latencies.
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// Registry holds metrics by name and writes all of them on request.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// metric is a named family of series sharing help text, type and label names.
+type metric interface {
+	kind() string
+	write(b *strings.Builder, name string)
+}
+
+// family holds the series of a metric, keyed by their joined label values.
+type family[V any] struct {
+	mu     sync.Mutex
+	help   string
+	labels []string
+	series map[string]V
+}
+
+// Counter is a metric whose series only go up. A nil Counter discards all changes.
+type Counter struct {
+	family[float64]
+}
+
+// Histogram counts observations in buckets. A nil Histogram discards all observations.
+type Histogram struct {
+	family[*histogramValue]
+
+	buckets []float64
+}
+
+// histogramValue is a single series of a histogram.
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// GaugeFunc is a metric whose series are read from functions when the metrics are written.
+type GaugeFunc struct {
+	family[func() float64]
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Counter returns the counter registered as name, registering it first if needed.
+// It panics if name is already registered as another type of metric.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return register(r, name, func() *Counter {
+		return &Counter{family: newFamily[float64](help, labels)}
+	})
+}
+
+// Histogram returns the histogram registered as name, registering it first if needed.
+// Buckets are the ascending upper bounds; the +Inf bucket is added implicitly.
+// It panics if name is already registered as another type of metric.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return register(r, name, func() *Histogram {
+		return &Histogram{family: newFamily[*histogramValue](help, labels), buckets: slices.Clone(buckets)}
+	})
+}
+
+// GaugeFunc returns the gauge registered as name, registering it first if needed.
+// It panics if name is already registered as another type of metric.
+func (r *Registry) GaugeFunc(name, help string, labels ...string) *GaugeFunc {
+	return register(r, name, func() *GaugeFunc {
+		return &GaugeFunc{family: newFamily[func() float64](help, labels)}
+	})
+}
+
+// WriteTo writes all metrics, ordered by name, in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+
+	r.mu.Unlock()
+
+	var b strings.Builder
+
+	for i, m := range metrics {
+		m.write(&b, names[i])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	if err != nil {
+		return int64(n), fmt.Errorf("writing metrics: %w", err)
+	}
+
+	return int64(n), nil
+}
+
+// ServeHTTP responds with all metrics in the text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+
+	_, err := r.WriteTo(w)
+	if err != nil {
+		slog.WarnContext(req.Context(), "failed to write metrics", "err", err)
+	}
+}
+
+// Inc adds one to the series with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the series with the given label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if c == nil || v < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.series[seriesKey(labelValues)] += v
+}
+
+func (*Counter) kind() string {
+	return typeCounter
+}
+
+func (c *Counter) write(b *strings.Builder, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(b, name, typeCounter)
+
+	for _, key := range c.sortedKeys() {
+		writeSample(b, name, formatLabels(c.labels, key, ""), c.series[key])
+	}
+}
+
+// Observe adds v to the series with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := seriesKey(labelValues)
+
+	value, ok := h.series[key]
+	if !ok {
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = value
+	}
+
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		value.counts[i]++
+	}
+
+	value.count++
+	value.sum += v
+}
+
+func (*Histogram) kind() string {
+	return typeHistogram
+}
+
+func (h *Histogram) write(b *strings.Builder, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(b, name, typeHistogram)
+
+	for _, key := range h.sortedKeys() {
+		value := h.series[key]
+
+		var cumulative uint64
+
+		for i, upper := range h.buckets {
+			cumulative += value.counts[i]
+			writeSample(b, name+"_bucket", formatLabels(h.labels, key, formatFloat(upper)), float64(cumulative))
+		}
+
+		writeSample(b, name+"_bucket", formatLabels(h.labels, key, "+Inf"), float64(value.count))
+		writeSample(b, name+"_sum", formatLabels(h.labels, key, ""), value.sum)
+		writeSample(b, name+"_count", formatLabels(h.labels, key, ""), float64(value.count))
+	}
+}
+
+// Set makes f the source of the series with the given label values.
+func (g *GaugeFunc) Set(f func() float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.series[seriesKey(labelValues)] = f
+}
+
+// Delete removes the series with the given label values.
+func (g *GaugeFunc) Delete(labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.series, seriesKey(labelValues))
+}
+
+func (*GaugeFunc) kind() string {
+	return typeGauge
+}
+
+func (g *GaugeFunc) write(b *strings.Builder, name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.writeHeader(b, name, typeGauge)
+
+	for _, key := range g.sortedKeys() {
+		writeSample(b, name, formatLabels(g.labels, key, ""), g.series[key]())
+	}
+}
+
+func newFamily[V any](help string, labels []string) family[V] {
+	return family[V]{help: help, labels: labels, series: make(map[string]V)}
+}
+
+// writeHeader writes the HELP and TYPE lines of the metric.
+func (f *family[V]) writeHeader(b *strings.Builder, name, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(f.help), name, kind)
+}
+
+// sortedKeys returns the series keys ordered by their label values. The caller must hold f.mu.
+func (f *family[V]) sortedKeys() []string {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b string) int {
+		return slices.Compare(strings.Split(a, labelSeparator), strings.Split(b, labelSeparator))
+	})
+
+	return keys
+}
+
+// register returns the metric registered as name, creating it with create if needed.
+func register[M metric](r *Registry, name string, create func() M) M {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.metrics[name]; ok {
+		m, sameType := existing.(M)
+		if !sameType {
+			panic(fmt.Sprintf("metrics: %s is already registered as a %s", name, existing.kind()))
+		}
+
+		return m
+	}
+
+	m := create()
+	r.metrics[name] = m
+
+	return m
+}
+
+// seriesKey joins label values into the key of a series.
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, labelSeparator)
+}
+
+// formatLabels renders the label set of the series with key, adding le unless it is empty.
+func formatLabels(names []string, key, le string) string {
+	values := strings.Split(key, labelSeparator)
+	pairs := make([]string, 0, len(names)+1)
+
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(value)+`"`)
+	}
+
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// writeSample writes a single sample line.
+func writeSample(b *strings.Builder, name, labels string, value float64) {
+	b.WriteString(name + labels + " " + formatFloat(value) + "\n")
+}
+
+// formatFloat formats v as the exposition format expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		record   func(r *Registry)
+		expected string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "counter",
+			record: func(r *Registry) {
+				requests := r.Counter("requests_total", "Requests served.", "route", "status")
+				requests.Inc("/records", "200")
+				requests.Add(2, "/records", "200")
+				requests.Inc("/records/{id}", "404")
+				requests.Add(-1, "/records", "200")
+			},
+			expected: "# HELP requests_total Requests served.\n" +
+				"# TYPE requests_total counter\n" +
+				"requests_total{route=\"/records\",status=\"200\"} 3\n" +
+				"requests_total{route=\"/records/{id}\",status=\"404\"} 1\n",
+		},
+		{
+			name: "histogram",
+			record: func(r *Registry) {
+				latency := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+				latency.Observe(0.05)
+				latency.Observe(0.1)
+				latency.Observe(0.5)
+				latency.Observe(3)
+			},
+			expected: "# HELP latency_seconds Latency.\n" +
+				"# TYPE latency_seconds histogram\n" +
+				"latency_seconds_bucket{le=\"0.1\"} 2\n" +
+				"latency_seconds_bucket{le=\"1\"} 3\n" +
+				"latency_seconds_bucket{le=\"+Inf\"} 4\n" +
+				"latency_seconds_sum 3.65\n" +
+				"latency_seconds_count 4\n",
+		},
+		{
+			name: "gauges ordered by name and labels escaped",
+			record: func(r *Registry) {
+				r.GaugeFunc("size", "Size\nin bytes.").Set(func() float64 { return 1.5 })
+
+				count := r.GaugeFunc("count", "Count.", "name")
+				count.Set(func() float64 { return 2 }, `a"b\`)
+				count.Set(func() float64 { return 3 }, "dropped")
+				count.Delete("dropped")
+			},
+			expected: "# HELP count Count.\n" +
+				"# TYPE count gauge\n" +
+				"count{name=\"a\\\"b\\\\\"} 2\n" +
+				"# HELP size Size\\nin bytes.\n" +
+				"# TYPE size gauge\n" +
+				"size 1.5\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRegistry()
+			if tt.record != nil {
+				tt.record(r)
+			}
+
+			var b strings.Builder
+
+			n, err := r.WriteTo(&b)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, b.String())
+			assert.Equal(t, int64(len(tt.expected)), n)
+		})
+	}
+}
+
+func TestRegistryReturnsRegisteredMetric(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+
+	assert.Same(t, r.Counter("total", "Total."), r.Counter("total", "Total."))
+	assert.Panics(t, func() { r.GaugeFunc("total", "Total.") })
+}
+
+func TestNilMetricsDiscardObservations(t *testing.T) {
+	t.Parallel()
+
+	var (
+		counter   *Counter
+		histogram *Histogram
+	)
+
+	assert.NotPanics(t, func() {
+		counter.Inc()
+		histogram.Observe(1)
+	})
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	r.Counter("total", "Total.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "total 1\n")
+}
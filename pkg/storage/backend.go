@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// Backends that New can create.
+const (
+	// BackendFile keeps a snapshot file and a write-ahead log, see FileStorage.
+	BackendFile Backend = "file"
+	// BackendLog keeps a segmented append-only log, see LogStorage.
+	BackendLog Backend = "log"
+	// BackendDir keeps one file per record, see DirStorage.
+	BackendDir Backend = "dir"
+	// BackendLSM keeps a log-structured merge tree of sorted tables, see LSMStorage.
+	BackendLSM Backend = "lsm"
+	// BackendMemory keeps records in memory only, see MemoryStorage.
+	BackendMemory Backend = "memory"
+)
+
+// Names of the data of each backend inside a store's directory.
+const (
+	fileName    = "data.txt"
+	segmentsDir = "segments"
+	recordsDir  = "records"
+	tablesDir   = "tables"
+)
+
+// ErrUnknownBackend is returned by New and Create for a backend they do not know.
+var ErrUnknownBackend = errors.New("unknown storage backend")
+
+// Backend names a storage implementation.
+type Backend string
+
+// Store is a Storage as created by New: it remembers its sequence, reports
+// what it loaded and must be closed when it is no longer used.
+type Store interface {
+	Storage
+	Sequencer
+	// RaiseSequence raises the sequence to seq, so that ids up to seq are
+	// never issued, even if no record has them. The next Save persists it.
+	RaiseSequence(seq uint64)
+	Report() LoadReport
+	Close() error
+}
+
+// Backends returns all backends that New can create.
+func Backends() []Backend {
+	return []Backend{BackendFile, BackendLog, BackendDir, BackendLSM, BackendMemory}
+}
+
+// New creates a store of backend that keeps its data in dir.
+func New(backend Backend, dir string, opts ...Option) (Store, error) {
+	switch backend {
+	case BackendFile:
+		return NewFileStorage(SnapshotFile(dir), opts...), nil
+	case BackendLog:
+		return NewLogStorage(filepath.Join(dir, segmentsDir), opts...), nil
+	case BackendDir:
+		return NewDirStorage(filepath.Join(dir, recordsDir), opts...), nil
+	case BackendLSM:
+		return NewLSMStorage(filepath.Join(dir, tablesDir), opts...), nil
+	case BackendMemory:
+		return NewMemoryStorage(opts...), nil
+	default:
+		return nil, fmt.Errorf("backend %q: %w", backend, ErrUnknownBackend)
+	}
+}
+
+// Create prepares an empty store of backend in dir, which must exist.
+// Only FileStorage needs this; the other backends start out empty.
+func Create(backend Backend, dir string) error {
+	switch backend {
+	case BackendFile:
+		name := SnapshotFile(dir)
+
+		err := os.WriteFile(name, nil, 0o644)
+		if err != nil {
+			return fmt.Errorf("creating %q: %w", name, errCreateFile)
+		}
+
+		return nil
+	case BackendLog, BackendDir, BackendLSM, BackendMemory:
+		return nil
+	default:
+		return fmt.Errorf("backend %q: %w", backend, ErrUnknownBackend)
+	}
+}
+
+// SnapshotFile returns the name of the snapshot of the file store in dir.
+func SnapshotFile(dir string) string {
+	return filepath.Join(dir, fileName)
+}
+
+// Convert saves records, loaded from another store whose sequence is seq, to
+// dst, replacing the records dst holds. The sequence is carried over, so that
+// dst never issues the ids of records deleted before the conversion.
+func Convert(ctx context.Context, dst Store, records map[uint64]userrecord.Record, seq uint64) error {
+	dst.RaiseSequence(seq)
+
+	err := dst.Save(ctx, records)
+	if err != nil {
+		return fmt.Errorf("converting records: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,366 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// TestConformance runs the conformance suite against every backend.
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range Backends() {
+		t.Run(string(backend), func(t *testing.T) {
+			t.Parallel()
+
+			testConformance(t, func(t *testing.T) (func(opts ...Option) Store, string) {
+				t.Helper()
+
+				dir := t.TempDir()
+
+				err := Create(backend, dir)
+				if err != nil {
+					t.Fatalf("Create failed: %v", err)
+				}
+
+				// A memory store keeps its records only as long as it is the same store.
+				var memory Store
+
+				return func(opts ...Option) Store {
+					if memory != nil {
+						return memory
+					}
+
+					store, err := New(backend, dir, opts...)
+					if err != nil {
+						t.Fatalf("New failed: %v", err)
+					}
+
+					if backend == BackendMemory {
+						memory = store
+					}
+
+					return store
+				}, dir
+			})
+		})
+	}
+}
+
+// testConformance checks the behaviour every Store must have. newStore prepares
+// an empty store and returns a function that opens it with options, as after a
+// restart, and the directory holding its files.
+func testConformance(t *testing.T, newStore func(t *testing.T) (func(opts ...Option) Store, string)) {
+	t.Helper()
+
+	alice := userrecord.Record{"id": uint64(1), "name": "Alice"}
+	bob := userrecord.Record{"id": uint64(2), "name": "Bob"}
+	carol := userrecord.Record{"id": uint64(3), "name": "Carol"}
+	// large is longer than the default token size of a bufio.Scanner.
+	large := userrecord.Record{"id": uint64(4), "name": strings.Repeat("x", 100<<10)}
+
+	tests := []struct {
+		name     string
+		run      func(t *testing.T, store Store)
+		expected map[uint64]userrecord.Record
+		sequence uint64
+	}{
+		{
+			name:     "empty",
+			run:      func(*testing.T, Store) {},
+			expected: map[uint64]userrecord.Record{},
+		},
+		{
+			name: "appended changes",
+			run: func(t *testing.T, store Store) {
+				appendEntries(t, store,
+					Entry{Op: OpAdd, ID: 1, Record: alice},
+					Entry{Op: OpAdd, ID: 2, Record: bob},
+					Entry{Op: OpUpdate, ID: 1, Record: userrecord.Record{"id": uint64(1), "name": "Alicia"}},
+					Entry{Op: OpDelete, ID: 2},
+				)
+			},
+			expected: map[uint64]userrecord.Record{1: {"id": uint64(1), "name": "Alicia"}},
+			sequence: 2,
+		},
+		{
+			name: "batch",
+			run: func(t *testing.T, store Store) {
+				appendEntries(t, store,
+					Entry{Op: OpAdd, ID: 1, Record: alice},
+					Entry{Op: OpBatch, Entries: []Entry{
+						{Op: OpAdd, ID: 2, Record: bob},
+						{Op: OpDelete, ID: 1},
+					}},
+				)
+			},
+			expected: map[uint64]userrecord.Record{2: bob},
+			sequence: 2,
+		},
+		{
+			name: "invalid batch",
+			run: func(t *testing.T, store Store) {
+				appendEntries(t, store, Entry{Op: OpAdd, ID: 1, Record: alice})
+
+				// Backends may reject the batch right away or skip it when loading.
+				_ = store.Append(t.Context(), Entry{Op: OpBatch, Entries: []Entry{
+					{Op: OpAdd, ID: 2, Record: bob},
+					{Op: OpAdd, ID: 3, Record: userrecord.Record{"name": "Carol"}},
+				}})
+			},
+			expected: map[uint64]userrecord.Record{1: alice},
+			sequence: 1,
+		},
+		{
+			name: "save replaces",
+			run: func(t *testing.T, store Store) {
+				appendEntries(t, store, Entry{Op: OpAdd, ID: 1, Record: alice})
+				saveRecords(t, store, map[uint64]userrecord.Record{2: bob, 3: carol})
+			},
+			expected: map[uint64]userrecord.Record{2: bob, 3: carol},
+			sequence: 3,
+		},
+		{
+			name: "appended after save",
+			run: func(t *testing.T, store Store) {
+				saveRecords(t, store, map[uint64]userrecord.Record{1: alice})
+				appendEntries(t, store,
+					Entry{Op: OpAdd, ID: 2, Record: bob},
+					Entry{Op: OpDelete, ID: 1},
+				)
+			},
+			expected: map[uint64]userrecord.Record{2: bob},
+			sequence: 2,
+		},
+		{
+			name: "sequence outlives deleted records",
+			run: func(t *testing.T, store Store) {
+				appendEntries(t, store,
+					Entry{Op: OpAdd, ID: 1, Record: alice},
+					Entry{Op: OpAdd, ID: 3, Record: carol},
+					Entry{Op: OpDelete, ID: 3},
+				)
+				saveRecords(t, store, map[uint64]userrecord.Record{1: alice})
+			},
+			expected: map[uint64]userrecord.Record{1: alice},
+			sequence: 3,
+		},
+		{
+			name: "records over 64 KiB",
+			run: func(t *testing.T, store Store) {
+				saveRecords(t, store, map[uint64]userrecord.Record{4: large})
+				appendEntries(t, store, Entry{Op: OpUpdate, ID: 4, Record: userrecord.Record{
+					"id": uint64(4), "name": large["name"], "tag": "updated",
+				}})
+			},
+			expected: map[uint64]userrecord.Record{4: {"id": uint64(4), "name": large["name"], "tag": "updated"}},
+			sequence: 4,
+		},
+		{
+			name: "canceled save",
+			run: func(t *testing.T, store Store) {
+				saveRecords(t, store, map[uint64]userrecord.Record{1: alice})
+
+				ctx, cancel := context.WithCancel(t.Context())
+				cancel()
+
+				err := store.Save(ctx, map[uint64]userrecord.Record{2: bob})
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("expected Save to fail with context.Canceled, got %v", err)
+				}
+			},
+			expected: map[uint64]userrecord.Record{1: alice},
+			sequence: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			open, _ := newStore(t)
+
+			store := open()
+			initRecords(t, store)
+			tt.run(t, store)
+
+			err := store.Close()
+			if err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			reopened := open()
+			records := initRecords(t, reopened)
+
+			if got, want := encode(t, records), encode(t, tt.expected); got != want {
+				t.Errorf("expected records %s, got %s", want, got)
+			}
+
+			if got := reopened.Sequence(); got != tt.sequence {
+				t.Errorf("expected sequence %d, got %d", tt.sequence, got)
+			}
+
+			if got := reopened.Report().Loaded; got != len(tt.expected) {
+				t.Errorf("expected report of %d loaded records, got %d", len(tt.expected), got)
+			}
+
+			err = reopened.Close()
+			if err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+		})
+	}
+
+	t.Run("canceled init", func(t *testing.T) {
+		t.Parallel()
+
+		open, _ := newStore(t)
+		store := open()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		err := store.Init(ctx, make(map[uint64]userrecord.Record))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected Init to fail with context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("corrupt entries", func(t *testing.T) {
+		t.Parallel()
+
+		open, dir := newStore(t)
+
+		store := open()
+		initRecords(t, store)
+		appendEntries(t, store,
+			Entry{Op: OpAdd, ID: 1, Record: alice},
+			Entry{Op: OpAdd, ID: 2, Record: bob},
+		)
+
+		err := store.Close()
+		if err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		damaged := damageFiles(t, dir, `"name":"Bob"`, `"name":"Bob`)
+		if len(damaged) == 0 {
+			t.Skip("the store keeps no files")
+		}
+
+		reopened := open()
+		records := initRecords(t, reopened)
+
+		defer reopened.Close()
+
+		if got, want := encode(t, records), encode(t, map[uint64]userrecord.Record{1: alice}); got != want {
+			t.Errorf("expected records %s, got %s", want, got)
+		}
+
+		corrupted := reopened.Report().Corrupted
+		if len(corrupted) == 0 {
+			t.Errorf("expected the damaged entry to be reported")
+		}
+
+		for _, entry := range corrupted {
+			if !slices.Contains(damaged, entry.File) {
+				t.Errorf("expected corrupt entries in %v, got %+v", damaged, entry)
+			}
+		}
+
+		strict := open(WithStrict())
+
+		defer strict.Close()
+
+		err = strict.Init(t.Context(), make(map[uint64]userrecord.Record))
+		if !errors.Is(err, ErrCorruptSnapshot) {
+			t.Errorf("expected Init to fail with ErrCorruptSnapshot in strict mode, got %v", err)
+		}
+	})
+}
+
+// damageFiles replaces old with new in every file under dir that contains it
+// and returns the names of the files it changed.
+func damageFiles(t *testing.T, dir, old, new string) []string {
+	t.Helper()
+
+	var damaged []string
+
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil || !bytes.Contains(data, []byte(old)) {
+			return err
+		}
+
+		damaged = append(damaged, name)
+
+		return os.WriteFile(name, bytes.ReplaceAll(data, []byte(old), []byte(new)), 0o600)
+	})
+	if err != nil {
+		t.Fatalf("damaging files: %v", err)
+	}
+
+	return damaged
+}
+
+// initRecords loads the records of store.
+func initRecords(t *testing.T, store Store) map[uint64]userrecord.Record {
+	t.Helper()
+
+	records := make(map[uint64]userrecord.Record)
+
+	err := store.Init(t.Context(), records)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	return records
+}
+
+// appendEntries appends entries to store in order.
+func appendEntries(t *testing.T, store Store, entries ...Entry) {
+	t.Helper()
+
+	for _, entry := range entries {
+		err := store.Append(t.Context(), entry)
+		if err != nil {
+			t.Fatalf("Append of %s %d failed: %v", entry.Op, entry.ID, err)
+		}
+	}
+}
+
+// saveRecords saves records to store.
+func saveRecords(t *testing.T, store Store, records map[uint64]userrecord.Record) {
+	t.Helper()
+
+	err := store.Save(t.Context(), records)
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+}
+
+// encode returns records as JSON, so that ids read back as float64 compare equal to uint64 ones.
+func encode(t *testing.T, records map[uint64]userrecord.Record) string {
+	t.Helper()
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		t.Fatalf("encoding records: %v", err)
+	}
+
+	return string(data)
+}
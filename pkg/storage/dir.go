@@ -0,0 +1,448 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+const (
+	// recordSuffix ends the names of the record files of a DirStorage, after the record id.
+	recordSuffix = ".json"
+	// pendingFile names the batch a DirStorage is applying. It is removed once
+	// all records of the batch are written.
+	pendingFile = "pending-batch"
+)
+
+var (
+	_ Store = (*DirStorage)(nil)
+
+	errRemoveFile = errors.New("failed to remove file")
+)
+
+// DirStorage keeps every record in its own file, named after the record's id,
+// in a directory. Changes are written to the record files right away, so Save
+// only has to catch up with records that were not appended. The entries of a
+// batch are first written together to a pending file, and a batch interrupted
+// by a crash is completed by the next Init.
+type DirStorage struct {
+	state
+	dir     string
+	strict  bool
+	metrics storageMetrics
+	// sums are the checksums of the record files, so that unchanged records are not rewritten.
+	sums map[uint64][sha256.Size]byte
+	// savedSeq is the sequence in the sequence file.
+	savedSeq uint64
+}
+
+// NewDirStorage creates a DirStorage keeping its records in dir.
+func NewDirStorage(dir string, opts ...Option) *DirStorage {
+	s := newSettings(opts)
+
+	return &DirStorage{
+		dir:     dir,
+		strict:  s.strict,
+		metrics: s.metrics,
+		sums:    make(map[uint64][sha256.Size]byte),
+	}
+}
+
+// Init completes an interrupted batch, if any, and loads all record files.
+// A missing directory holds no records.
+func (d *DirStorage) Init(ctx context.Context, records map[uint64]userrecord.Record) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	start := time.Now()
+
+	size, err := d.load(ctx, records)
+	d.metrics.observe(metricInit, start, size, err)
+
+	return err
+}
+
+// load reads the record files and returns their size. Record files that cannot
+// be decoded, or that hold a record with another id than their name, are
+// skipped and reported. The caller must hold the lock.
+func (d *DirStorage) load(ctx context.Context, records map[uint64]userrecord.Record) (int64, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, fmt.Errorf("loading %q: %w", d.dir, err)
+	}
+
+	d.savedSeq, err = readSequence(filepath.Join(d.dir, sequenceFile))
+	if err != nil {
+		return 0, fmt.Errorf("initializing sequence: %w", err)
+	}
+
+	d.sums = make(map[uint64][sha256.Size]byte)
+
+	err = d.finishPending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("reading directory %q: %w", d.dir, errOpenFile)
+	}
+
+	var (
+		size      int64
+		corrupted []CorruptEntry
+	)
+
+	for _, entry := range entries {
+		err = ctx.Err()
+		if err != nil {
+			return size, fmt.Errorf("loading records: %w", err)
+		}
+
+		id, ok := recordID(entry.Name())
+		if !ok {
+			continue
+		}
+
+		name := filepath.Join(d.dir, entry.Name())
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return size, fmt.Errorf("reading %q: %w", name, errOpenFile)
+		}
+
+		size += int64(len(data))
+
+		var rec userrecord.Record
+
+		err = json.Unmarshal(data, &rec)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to unmarshal a record", "file", name, "err", err)
+
+			corrupted = append(corrupted, CorruptEntry{File: name, Line: 1, Reason: "undecodable record"})
+
+			continue
+		}
+
+		// ValidateID turns the decoded id into the uint64 that ID returns.
+		err = rec.ValidateID()
+		if recID, _ := rec.ID(); err != nil || recID != id {
+			slog.WarnContext(ctx, "record does not match its file", "file", name, "id", rec["id"])
+
+			corrupted = append(corrupted, CorruptEntry{File: name, Line: 1, Reason: "record id does not match the file name"})
+
+			continue
+		}
+
+		records[id] = rec
+		d.sums[id] = sha256.Sum256(data)
+	}
+
+	d.loaded(records, d.savedSeq)
+
+	return size, d.reportCorrupted(corrupted, d.strict)
+}
+
+// finishPending applies the pending batch, if there is one. The caller must hold the lock.
+func (d *DirStorage) finishPending(ctx context.Context) error {
+	name := filepath.Join(d.dir, pendingFile)
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("reading %q: %w", name, errOpenFile)
+	}
+
+	var batch Entry
+
+	err = json.Unmarshal(data, &batch)
+	if err != nil {
+		// The pending file is written atomically, so its batch was never applied.
+		slog.WarnContext(ctx, "discarding undecodable pending batch", "file", name, "err", err)
+	} else {
+		slog.InfoContext(ctx, "completing interrupted batch", "entries", len(batch.Entries))
+
+		for _, nested := range batch.Entries {
+			_, err = d.apply(ctx, nested)
+			if err != nil {
+				return fmt.Errorf("completing pending batch: %w", err)
+			}
+		}
+	}
+
+	return d.remove(ctx, name)
+}
+
+// Save writes every record whose file differs from it and removes the files of
+// records that are not in records. If ctx is done, Save stops early; the
+// records written so far stay written.
+func (d *DirStorage) Save(ctx context.Context, records map[uint64]userrecord.Record) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	start := time.Now()
+
+	size, err := d.save(ctx, records)
+	d.metrics.observe(metricSave, start, size, err)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to save records", "dir", d.dir, "err", err)
+	}
+
+	return err
+}
+
+// save brings the record files in line with records and returns the number of
+// bytes written. The caller must hold the lock.
+func (d *DirStorage) save(ctx context.Context, records map[uint64]userrecord.Record) (int64, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, fmt.Errorf("saving to %q: %w", d.dir, err)
+	}
+
+	for id := range records {
+		d.observeID(id)
+	}
+
+	err = d.saveSequence(ctx, d.lastID)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+
+	for id, rec := range records {
+		err = ctx.Err()
+		if err != nil {
+			return size, fmt.Errorf("writing records: %w", err)
+		}
+
+		written, err := d.write(ctx, id, rec)
+		size += written
+
+		if err != nil {
+			return size, err
+		}
+	}
+
+	for id := range d.sums {
+		if _, exists := records[id]; !exists {
+			err = d.delete(ctx, id)
+			if err != nil {
+				return size, err
+			}
+		}
+	}
+
+	return size, nil
+}
+
+// Append durably applies a single change to the record files.
+func (d *DirStorage) Append(ctx context.Context, entry Entry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.appendEntry(ctx, entry)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to append to log", "op", entry.Op, "id", entry.ID, "err", err)
+
+		return fmt.Errorf("appending %s of record with id %d: %w", entry.Op, entry.ID, err)
+	}
+
+	d.observeID(entry.maxID())
+
+	return nil
+}
+
+// appendEntry applies entry, going through the pending file for a batch.
+// The caller must hold the lock.
+func (d *DirStorage) appendEntry(ctx context.Context, entry Entry) error {
+	if entry.Op != OpBatch {
+		err := checkEntry(entry)
+		if err != nil {
+			return err
+		}
+
+		_, err = d.apply(ctx, entry)
+
+		return err
+	}
+
+	for _, nested := range entry.Entries {
+		err := checkEntry(nested)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshaling batch: %w", err)
+	}
+
+	err = d.writeFile(ctx, filepath.Join(d.dir, pendingFile), append(data, '\n'))
+	if err != nil {
+		return err
+	}
+
+	for _, nested := range entry.Entries {
+		_, err = d.apply(ctx, nested)
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.remove(ctx, filepath.Join(d.dir, pendingFile))
+}
+
+// apply writes or deletes the record file of a single, non-batch entry.
+func (d *DirStorage) apply(ctx context.Context, entry Entry) (int64, error) {
+	switch entry.Op {
+	case OpAdd, OpUpdate:
+		return d.write(ctx, entry.ID, entry.Record)
+	case OpDelete:
+		return 0, d.delete(ctx, entry.ID)
+	default:
+		return 0, fmt.Errorf("operation %q: %w", entry.Op, errUnknownOp)
+	}
+}
+
+// write replaces the file of the record with id, unless it already holds rec,
+// and returns the number of bytes written.
+func (d *DirStorage) write(ctx context.Context, id uint64, rec userrecord.Record) (int64, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return 0, fmt.Errorf("marshaling record with id %d: %w", id, err)
+	}
+
+	data = append(data, '\n')
+
+	sum := sha256.Sum256(data)
+	if stored, exists := d.sums[id]; exists && stored == sum {
+		return 0, nil
+	}
+
+	err = d.writeFile(ctx, d.recordName(id), data)
+	if err != nil {
+		return 0, err
+	}
+
+	d.sums[id] = sum
+
+	return int64(len(data)), nil
+}
+
+// delete removes the file of the record with id.
+func (d *DirStorage) delete(ctx context.Context, id uint64) error {
+	// Once the file is gone, only the sequence remembers that id was used.
+	if id > d.savedSeq {
+		err := d.saveSequence(ctx, max(d.lastID, id))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := d.remove(ctx, d.recordName(id))
+	if err != nil {
+		return err
+	}
+
+	delete(d.sums, id)
+
+	return nil
+}
+
+// saveSequence writes seq to the sequence file if it is higher than the saved one.
+func (d *DirStorage) saveSequence(ctx context.Context, seq uint64) error {
+	if seq <= d.savedSeq {
+		return nil
+	}
+
+	err := os.MkdirAll(d.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating %q: %w", d.dir, errCreateFile)
+	}
+
+	err = writeSequence(ctx, filepath.Join(d.dir, sequenceFile), seq)
+	if err != nil {
+		return fmt.Errorf("saving sequence: %w", err)
+	}
+
+	d.savedSeq = seq
+
+	return nil
+}
+
+// writeFile atomically replaces the named file in the directory with data.
+func (d *DirStorage) writeFile(ctx context.Context, name string, data []byte) error {
+	err := os.MkdirAll(d.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating %q: %w", d.dir, errCreateFile)
+	}
+
+	return writeAtomic(ctx, name, func(file *os.File) error {
+		_, err := file.Write(data)
+		if err != nil {
+			return fmt.Errorf("writing %q: %w", file.Name(), ErrWriteRecords)
+		}
+
+		err = file.Sync()
+		if err != nil {
+			return fmt.Errorf("syncing %q: %w", file.Name(), errSyncFile)
+		}
+
+		return nil
+	})
+}
+
+// remove deletes the named file in the directory, if it exists, and makes the deletion durable.
+func (d *DirStorage) remove(ctx context.Context, name string) error {
+	err := os.Remove(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("removing %q: %w", name, errRemoveFile)
+	}
+
+	return syncDir(ctx, d.dir)
+}
+
+// Close releases nothing, as DirStorage keeps no files open. It implements Store.
+func (d *DirStorage) Close() error {
+	return nil
+}
+
+// recordName returns the name of the file of the record with id.
+func (d *DirStorage) recordName(id uint64) string {
+	return filepath.Join(d.dir, strconv.FormatUint(id, 10)+recordSuffix)
+}
+
+// recordID returns the id of the record kept in the file with name.
+func recordID(name string) (uint64, bool) {
+	text, ok := strings.CutSuffix(name, recordSuffix)
+	if !ok {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
@@ -0,0 +1,804 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+const (
+	// memtableLog names the write-ahead log of the memtable of an LSMStorage.
+	memtableLog = "memtable.log"
+	// frozenLog names the log of a frozen memtable until it is written to a table.
+	frozenLog = "frozen.log"
+	// defaultMemtableSize is the size of the memtable's log at which it is flushed by default.
+	defaultMemtableSize = 4 << 20
+	// maxTables is the number of tables above which they are compacted into one.
+	maxTables = 8
+)
+
+var (
+	_ Store  = (*LSMStorage)(nil)
+	_ Engine = (*LSMStorage)(nil)
+)
+
+// LSMStorage is a log-structured merge tree. Changes are logged and collected
+// in a memtable, which is frozen once its log reaches the memtable size and
+// written to a new sorted table in the background, while a new memtable takes
+// the changes. Records are looked up in the memtables and then in the
+// tables from the newest to the oldest, reading only the block of a table
+// that may hold them, so that the records need not be held in memory. Once
+// there are more than maxTables tables, they are merged into one. A manifest
+// lists the tables in use, so that tables left over by a crash are ignored.
+type LSMStorage struct {
+	state
+	dir          string
+	memtableSize int64
+	strict       bool
+	metrics      storageMetrics
+	wal          *wal
+	// memtable holds the changes logged since the last flush. A nil record is
+	// a tombstone, hiding the record in the tables.
+	memtable map[uint64]userrecord.Record
+	// frozen holds the changes of the memtable being written to a table, or of
+	// one whose flush failed, and is nil otherwise. It is never changed.
+	frozen map[uint64]userrecord.Record
+	// flushing is set while a flush runs in the background.
+	flushing bool
+	flushes  sync.WaitGroup
+	// tables are ordered from the newest to the oldest.
+	tables []*table
+	// next is the number of the next table to write.
+	next uint64
+}
+
+// WithMemtableSize sets the size in bytes of logged changes at which an
+// LSMStorage writes its memtable to a table. The default is 4 MiB.
+func WithMemtableSize(size int64) Option {
+	return func(s *settings) {
+		s.memtableSize = max(size, 1)
+	}
+}
+
+// NewLSMStorage creates an LSMStorage keeping its tables in dir.
+func NewLSMStorage(dir string, opts ...Option) *LSMStorage {
+	s := newSettings(opts)
+
+	return &LSMStorage{
+		dir:          dir,
+		memtableSize: s.memtableSize,
+		strict:       s.strict,
+		metrics:      s.metrics,
+		wal:          newWAL(filepath.Join(dir, memtableLog), s.sync),
+		memtable:     make(map[uint64]userrecord.Record),
+		next:         1,
+	}
+}
+
+// Open reads the indexes of the tables and replays the memtables' logs,
+// without loading any records. A missing directory holds no records.
+func (l *LSMStorage) Open(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := time.Now()
+
+	corrupted, err := l.open(ctx)
+	if err == nil {
+		l.report = LoadReport{}
+		err = l.reportCorrupted(corrupted, l.strict)
+	}
+
+	l.metrics.observe(metricInit, start, fileSize(l.wal.filename), err)
+
+	return err
+}
+
+// Init opens the storage and loads all records into records.
+func (l *LSMStorage) Init(ctx context.Context, records map[uint64]userrecord.Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := time.Now()
+
+	err := l.load(ctx, records)
+	l.metrics.observe(metricInit, start, l.size(), err)
+
+	return err
+}
+
+// load opens the storage and scans all records into records. The caller must hold the lock.
+func (l *LSMStorage) load(ctx context.Context, records map[uint64]userrecord.Record) error {
+	corrupted, err := l.open(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = l.scan(ctx, func(id uint64, rec userrecord.Record) error {
+		records[id] = rec
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("loading records: %w", err)
+	}
+
+	l.loaded(records, l.lastID)
+
+	return l.reportCorrupted(corrupted, l.strict)
+}
+
+// open reads the manifest, opens its tables and replays the logs into the
+// memtables, and returns the log entries that could not be decoded. The
+// caller must hold the lock.
+func (l *LSMStorage) open(ctx context.Context) ([]CorruptEntry, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %w", l.dir, err)
+	}
+
+	l.closeTables(ctx)
+
+	nums, err := readManifest(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, num := range nums {
+		t, err := openTable(l.dir, num)
+		if err != nil {
+			l.closeTables(ctx)
+
+			return nil, err
+		}
+
+		l.tables = slices.Insert(l.tables, 0, t)
+		l.next = max(l.next, num+1)
+		l.observeID(t.index.Max)
+	}
+
+	l.removeUnlisted(ctx)
+
+	// A memtable frozen before a crash is written to a table by the next flush.
+	frozen := make(map[uint64]userrecord.Record)
+
+	frozenID, corrupted, err := newWAL(l.frozenLog(), SyncNever).replayInto(ctx, func(entry Entry) error {
+		return applyToMemtable(frozen, entry)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("initializing from frozen log: %w", err)
+	}
+
+	l.frozen = nil
+	if len(frozen) > 0 {
+		l.frozen = frozen
+	}
+
+	l.memtable = make(map[uint64]userrecord.Record)
+
+	logID, logCorrupted, err := l.wal.replayInto(ctx, func(entry Entry) error {
+		return applyToMemtable(l.memtable, entry)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("initializing from log: %w", err)
+	}
+
+	corrupted = append(corrupted, logCorrupted...)
+
+	l.wal.written = fileSize(l.wal.filename)
+
+	seq, err := readSequence(filepath.Join(l.dir, sequenceFile))
+	if err != nil {
+		return nil, fmt.Errorf("initializing sequence: %w", err)
+	}
+
+	l.observeID(max(seq, logID, frozenID))
+
+	return corrupted, nil
+}
+
+// Get returns the record with id from the memtables or the newest table
+// holding it, or ErrNotFound. Gets run concurrently with each other.
+func (l *LSMStorage) Get(ctx context.Context, id uint64) (userrecord.Record, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, fmt.Errorf("getting record with id %d: %w", id, err)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, memtable := range []map[uint64]userrecord.Record{l.memtable, l.frozen} {
+		rec, exists := memtable[id]
+		if !exists {
+			continue
+		}
+
+		if rec == nil {
+			return nil, fmt.Errorf("record with id %d: %w", id, ErrNotFound)
+		}
+
+		return rec, nil
+	}
+
+	for _, t := range l.tables {
+		entry, found, err := t.get(id)
+		if err != nil {
+			return nil, fmt.Errorf("getting record with id %d: %w", id, err)
+		}
+
+		if !found {
+			continue
+		}
+
+		if entry.Op == OpDelete {
+			break
+		}
+
+		return entry.Record, nil
+	}
+
+	return nil, fmt.Errorf("record with id %d: %w", id, ErrNotFound)
+}
+
+// Scan calls fn for every record in ascending id order. Writers are blocked
+// until the scan is done.
+func (l *LSMStorage) Scan(ctx context.Context, fn func(id uint64, rec userrecord.Record) error) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.scan(ctx, fn)
+}
+
+// scan merges the memtables and the tables. The caller must hold the lock.
+func (l *LSMStorage) scan(ctx context.Context, fn func(id uint64, rec userrecord.Record) error) error {
+	cursors := make([]cursor, 0, len(l.tables)+2)
+
+	cursors = append(cursors,
+		&entryCursor{entries: memtableEntries(l.memtable)},
+		&entryCursor{entries: memtableEntries(l.frozen)},
+	)
+	for _, t := range l.tables {
+		cursors = append(cursors, t.entries())
+	}
+
+	return merge(ctx, cursors, func(entry Entry) error {
+		if entry.Op == OpDelete {
+			return nil
+		}
+
+		return fn(entry.ID, entry.Record)
+	})
+}
+
+// Save replaces all tables with a single one holding records and empties the
+// memtables. If ctx is done before all records are written, the tables are
+// left as they were and the context's error is returned.
+func (l *LSMStorage) Save(ctx context.Context, records map[uint64]userrecord.Record) error {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := time.Now()
+
+	err := l.save(ctx, records)
+	l.metrics.observe(metricSave, start, l.size(), err)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to save records", "dir", l.dir, "err", err)
+	}
+
+	return err
+}
+
+// save writes records to a new table that replaces all others. The caller must hold the lock.
+func (l *LSMStorage) save(ctx context.Context, records map[uint64]userrecord.Record) error {
+	err := ctx.Err()
+	if err != nil {
+		return fmt.Errorf("saving to %q: %w", l.dir, err)
+	}
+
+	for id := range records {
+		l.observeID(id)
+	}
+
+	err = l.saveSequence(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]Entry, 0, len(records))
+	for _, id := range slices.Sorted(maps.Keys(records)) {
+		entries = append(entries, Entry{Op: OpAdd, ID: id, Record: records[id]})
+	}
+
+	return l.replaceTables(ctx, entries)
+}
+
+// Append logs entry and applies it to the memtable. Once the memtable's log
+// reaches the memtable size, the memtable is flushed in the background.
+func (l *LSMStorage) Append(ctx context.Context, entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.append(entry)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to append to log", "op", entry.Op, "id", entry.ID, "err", err)
+
+		return fmt.Errorf("appending %s of record with id %d: %w", entry.Op, entry.ID, err)
+	}
+
+	l.observeID(entry.maxID())
+
+	if l.wal.written < l.memtableSize || l.flushing {
+		return nil
+	}
+
+	// The entry is already durable in the log, so the flush need not hold up the caller.
+	l.flushing = true
+	l.flushes.Add(1)
+
+	go l.flushInBackground()
+
+	return nil
+}
+
+// flushInBackground flushes the memtable on behalf of Append.
+func (l *LSMStorage) flushInBackground() {
+	defer l.flushes.Done()
+
+	ctx := context.Background()
+
+	err := l.Flush(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to flush memtable", "dir", l.dir, "err", err)
+	}
+
+	l.mu.Lock()
+	l.flushing = false
+	l.mu.Unlock()
+}
+
+// append checks entry, logs it and applies it to the memtable. The caller must hold the lock.
+func (l *LSMStorage) append(entry Entry) error {
+	if entry.Op != OpBatch {
+		err := checkEntry(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, nested := range entry.Entries {
+		err := checkEntry(nested)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.MkdirAll(l.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating %q: %w", l.dir, errCreateFile)
+	}
+
+	err = l.wal.append(entry)
+	if err != nil {
+		return err
+	}
+
+	return applyToMemtable(l.memtable, entry)
+}
+
+// Flush writes the memtable to a new table and drops its log. Changes can be
+// appended and records read while the table is written.
+func (l *LSMStorage) Flush(ctx context.Context) error {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	return l.flush(ctx)
+}
+
+// flush writes a frozen memtable left by a failed flush, then the memtable, to
+// new tables and compacts the tables once there are too many. The caller must
+// hold saveMu, but not the lock.
+func (l *LSMStorage) flush(ctx context.Context) error {
+	err := l.writeFrozen(ctx)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	err = l.freeze(ctx)
+	l.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	err = l.writeFrozen(ctx)
+	if err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	tables := len(l.tables)
+	l.mu.RUnlock()
+
+	if tables > maxTables {
+		return l.compact(ctx)
+	}
+
+	return nil
+}
+
+// freeze sets the memtable aside to be written to a table, unless one is set
+// aside already, and starts a new one. Its log is renamed to the frozen log,
+// so that changes can be logged while it is written. The caller must hold the lock.
+func (l *LSMStorage) freeze(ctx context.Context) error {
+	if l.frozen != nil || len(l.memtable) == 0 {
+		return nil
+	}
+
+	// The entries are already written, so failing to close only loses the file handle.
+	err := l.wal.close()
+	if err != nil {
+		slog.WarnContext(ctx, "failed to close log", "file", l.wal.filename, "err", err)
+	}
+
+	err = os.Rename(l.wal.filename, l.frozenLog())
+	if err != nil {
+		return fmt.Errorf("freezing memtable: %w", errRenameFile)
+	}
+
+	err = syncDir(ctx, l.dir)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to sync frozen log", "file", l.frozenLog(), "err", err)
+	}
+
+	l.frozen = l.memtable
+	l.memtable = make(map[uint64]userrecord.Record)
+	l.wal.written = 0
+
+	return nil
+}
+
+// writeFrozen writes the frozen memtable to a new table without holding the
+// lock, then takes it to list the table and drop the frozen log. The caller
+// must hold saveMu.
+func (l *LSMStorage) writeFrozen(ctx context.Context) error {
+	l.mu.Lock()
+
+	frozen := l.frozen
+	if frozen == nil {
+		l.mu.Unlock()
+
+		return nil
+	}
+
+	num := l.reserveTable()
+
+	l.mu.Unlock()
+
+	t, err := l.writeTable(ctx, num, func(add func(Entry) error) error {
+		for _, entry := range memtableEntries(frozen) {
+			err := add(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tables := slices.Insert(slices.Clone(l.tables), 0, t)
+
+	err = writeManifest(ctx, l.dir, tables)
+	if err != nil {
+		l.discardTable(ctx, t)
+
+		return err
+	}
+
+	l.tables = tables
+	l.frozen = nil
+
+	// A frozen log left behind is replayed into the next flush, which only repeats its changes.
+	err = os.Remove(l.frozenLog())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.WarnContext(ctx, "failed to remove frozen log", "file", l.frozenLog(), "err", err)
+	}
+
+	return nil
+}
+
+// compact merges all tables into one, dropping tombstones and the records they
+// hide. The tables are merged without holding the lock. The caller must hold
+// saveMu, so that no other flush changes the tables meanwhile.
+func (l *LSMStorage) compact(ctx context.Context) error {
+	l.mu.Lock()
+
+	// Once the tombstones are gone, only the sequence remembers their ids.
+	err := l.saveSequence(ctx)
+	tables := l.tables
+	num := l.reserveTable()
+
+	l.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	cursors := make([]cursor, 0, len(tables))
+	for _, t := range tables {
+		cursors = append(cursors, t.entries())
+	}
+
+	t, err := l.writeTable(ctx, num, func(add func(Entry) error) error {
+		return merge(ctx, cursors, func(entry Entry) error {
+			if entry.Op == OpDelete {
+				return nil
+			}
+
+			return add(entry)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("compacting tables: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err = writeManifest(ctx, l.dir, []*table{t})
+	if err != nil {
+		l.discardTable(ctx, t)
+
+		return err
+	}
+
+	for _, old := range tables {
+		l.discardTable(ctx, old)
+	}
+
+	l.tables = []*table{t}
+
+	return nil
+}
+
+// replaceTables writes entries to a new table that replaces all others, and
+// empties the memtables. The caller must hold the lock.
+func (l *LSMStorage) replaceTables(ctx context.Context, entries []Entry) error {
+	t, err := l.writeTable(ctx, l.reserveTable(), func(add func(Entry) error) error {
+		for _, entry := range entries {
+			err := ctx.Err()
+			if err != nil {
+				return fmt.Errorf("writing records: %w", err)
+			}
+
+			err = add(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeManifest(ctx, l.dir, []*table{t})
+	if err != nil {
+		l.discardTable(ctx, t)
+
+		return err
+	}
+
+	for _, old := range l.tables {
+		l.discardTable(ctx, old)
+	}
+
+	l.tables = []*table{t}
+
+	return l.resetMemtable()
+}
+
+// reserveTable returns the number of the next table to write. The caller must hold the lock.
+func (l *LSMStorage) reserveTable() uint64 {
+	num := l.next
+	l.next++
+
+	return num
+}
+
+// writeTable writes the entries passed by fill to the table numbered num and opens it.
+func (l *LSMStorage) writeTable(ctx context.Context, num uint64,
+	fill func(add func(Entry) error) error,
+) (*table, error) {
+	err := os.MkdirAll(l.dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("creating %q: %w", l.dir, errCreateFile)
+	}
+
+	err = writeTable(ctx, tableName(l.dir, num), fill)
+	if err != nil {
+		return nil, err
+	}
+
+	return openTable(l.dir, num)
+}
+
+// resetMemtable empties the memtables once their changes are in a table. The caller must hold the lock.
+func (l *LSMStorage) resetMemtable() error {
+	err := l.wal.truncate()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(l.frozenLog())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing frozen log %q: %w", l.frozenLog(), ErrTruncateLog)
+	}
+
+	l.memtable = make(map[uint64]userrecord.Record)
+	l.frozen = nil
+
+	return nil
+}
+
+// frozenLog returns the name of the log of the frozen memtable.
+func (l *LSMStorage) frozenLog() string {
+	return filepath.Join(l.dir, frozenLog)
+}
+
+// saveSequence writes the sequence file. The caller must hold the lock.
+func (l *LSMStorage) saveSequence(ctx context.Context) error {
+	err := os.MkdirAll(l.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating %q: %w", l.dir, errCreateFile)
+	}
+
+	err = writeSequence(ctx, filepath.Join(l.dir, sequenceFile), l.lastID)
+	if err != nil {
+		return fmt.Errorf("saving sequence: %w", err)
+	}
+
+	return nil
+}
+
+// discardTable closes a table that is no longer listed and removes its file.
+func (l *LSMStorage) discardTable(ctx context.Context, t *table) {
+	err := t.close()
+	if err != nil {
+		slog.WarnContext(ctx, "failed to close table", "err", err)
+	}
+
+	err = os.Remove(t.name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.WarnContext(ctx, "failed to remove table", "file", t.name, "err", err)
+	}
+}
+
+// removeUnlisted removes the table files missing from the manifest, which a
+// crash left behind. The caller must hold the lock.
+func (l *LSMStorage) removeUnlisted(ctx context.Context) {
+	nums, err := listTables(l.dir)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to list tables", "err", err)
+
+		return
+	}
+
+	for _, num := range nums {
+		if slices.ContainsFunc(l.tables, func(t *table) bool { return t.num == num }) {
+			continue
+		}
+
+		name := tableName(l.dir, num)
+
+		slog.WarnContext(ctx, "removing table missing from manifest", "file", name)
+
+		err = os.Remove(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.WarnContext(ctx, "failed to remove table", "file", name, "err", err)
+		}
+
+		l.next = max(l.next, num+1)
+	}
+}
+
+// closeTables closes all tables. The caller must hold the lock.
+func (l *LSMStorage) closeTables(ctx context.Context) {
+	for _, t := range l.tables {
+		err := t.close()
+		if err != nil {
+			slog.WarnContext(ctx, "failed to close table", "err", err)
+		}
+	}
+
+	l.tables = nil
+}
+
+// size returns the size of the tables and the logs. The caller must hold the lock.
+func (l *LSMStorage) size() int64 {
+	size := fileSize(l.wal.filename) + fileSize(l.frozenLog())
+	for _, t := range l.tables {
+		size += fileSize(t.name)
+	}
+
+	return size
+}
+
+// Close waits for a flush in the background and closes the log and the tables.
+func (l *LSMStorage) Close() error {
+	l.flushes.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closeTables(context.Background())
+
+	return l.wal.close()
+}
+
+// applyToMemtable applies a log entry to memtable, leaving a tombstone for a
+// deletion. The entries of a batch are all checked before any of them is applied.
+func applyToMemtable(memtable map[uint64]userrecord.Record, entry Entry) error {
+	switch entry.Op {
+	case OpBatch:
+		for _, nested := range entry.Entries {
+			err := checkEntry(nested)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, nested := range entry.Entries {
+			_ = applyToMemtable(memtable, nested)
+		}
+	case OpAdd, OpUpdate:
+		err := checkEntry(entry)
+		if err != nil {
+			return err
+		}
+
+		memtable[entry.ID] = entry.Record
+	case OpDelete:
+		memtable[entry.ID] = nil
+	default:
+		return fmt.Errorf("operation %q: %w", entry.Op, errUnknownOp)
+	}
+
+	return nil
+}
+
+// memtableEntries returns the changes in memtable as table entries in ascending id order.
+func memtableEntries(memtable map[uint64]userrecord.Record) []Entry {
+	entries := make([]Entry, 0, len(memtable))
+
+	for _, id := range slices.Sorted(maps.Keys(memtable)) {
+		if rec := memtable[id]; rec != nil {
+			entries = append(entries, Entry{Op: OpAdd, ID: id, Record: rec})
+		} else {
+			entries = append(entries, Entry{Op: OpDelete, ID: id})
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+var _ Store = (*MemoryStorage)(nil)
+
+// MemoryStorage keeps records in memory only, so they are lost when the process
+// exits; it is meant for tests. Records are copied through JSON on the way in
+// and out, as if they were written to a file, so the storage never shares them
+// with its caller and numbers come back as float64.
+type MemoryStorage struct {
+	state
+	records map[uint64]userrecord.Record
+	metrics storageMetrics
+}
+
+// NewMemoryStorage creates an empty MemoryStorage.
+func NewMemoryStorage(opts ...Option) *MemoryStorage {
+	s := newSettings(opts)
+
+	return &MemoryStorage{
+		records: make(map[uint64]userrecord.Record),
+		metrics: s.metrics,
+	}
+}
+
+// Init copies the stored records into records.
+func (m *MemoryStorage) Init(ctx context.Context, records map[uint64]userrecord.Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := time.Now()
+
+	err := m.load(ctx, records)
+	m.metrics.observe(metricInit, start, 0, err)
+
+	return err
+}
+
+// load copies the stored records. The caller must hold the lock.
+func (m *MemoryStorage) load(ctx context.Context, records map[uint64]userrecord.Record) error {
+	err := ctx.Err()
+	if err != nil {
+		return fmt.Errorf("loading records: %w", err)
+	}
+
+	for id, rec := range m.records {
+		err = ctx.Err()
+		if err != nil {
+			return fmt.Errorf("loading records: %w", err)
+		}
+
+		copied, err := copyOf(rec)
+		if err != nil {
+			return fmt.Errorf("copying record with id %d: %w", id, err)
+		}
+
+		// Ids are decoded as float64, as from a file.
+		err = copied.ValidateID()
+		if err != nil {
+			return fmt.Errorf("copying record with id %d: %w", id, err)
+		}
+
+		records[id] = copied
+	}
+
+	m.loaded(records, m.lastID)
+
+	return nil
+}
+
+// Save replaces the stored records with copies of records. If ctx is done
+// before all records are copied, the stored records are left as they were.
+func (m *MemoryStorage) Save(ctx context.Context, records map[uint64]userrecord.Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := time.Now()
+
+	err := m.save(ctx, records)
+	m.metrics.observe(metricSave, start, 0, err)
+
+	return err
+}
+
+// save copies records. The caller must hold the lock.
+func (m *MemoryStorage) save(ctx context.Context, records map[uint64]userrecord.Record) error {
+	saved := make(map[uint64]userrecord.Record, len(records))
+
+	for id, rec := range records {
+		err := ctx.Err()
+		if err != nil {
+			return fmt.Errorf("saving records: %w", err)
+		}
+
+		copied, err := copyOf(rec)
+		if err != nil {
+			return fmt.Errorf("copying record with id %d: %w: %w", id, ErrWriteRecords, err)
+		}
+
+		saved[id] = copied
+	}
+
+	m.records = saved
+
+	for id := range saved {
+		m.observeID(id)
+	}
+
+	return nil
+}
+
+// Append applies a copy of entry to the stored records.
+func (m *MemoryStorage) Append(_ context.Context, entry Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied, err := copyOf(entry)
+	if err != nil {
+		return fmt.Errorf("copying %s of record with id %d: %w: %w", entry.Op, entry.ID, ErrWriteLog, err)
+	}
+
+	err = applyEntry(m.records, copied)
+	if err != nil {
+		return fmt.Errorf("appending %s of record with id %d: %w", entry.Op, entry.ID, err)
+	}
+
+	m.observeID(entry.maxID())
+
+	return nil
+}
+
+// Close keeps the records, so that the next Init loads them again. It implements Store.
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+// copyOf returns a deep copy of v made by encoding it to JSON and decoding it again.
+func copyOf[T any](v T) (T, error) {
+	var copied T
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return copied, fmt.Errorf("encoding: %w", err)
+	}
+
+	err = json.Unmarshal(data, &copied)
+	if err != nil {
+		return copied, fmt.Errorf("decoding: %w", err)
+	}
+
+	return copied, nil
+}
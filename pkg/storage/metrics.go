@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"os"
+	"time"
+
+	"zabbix-technical-task/pkg/metrics"
+)
+
+// Storage operations reported by WithMetrics.
+const (
+	metricInit = "init"
+	metricSave = "save"
+)
+
+// storageMetrics are the metrics of Init and Save. All of them are nil if metrics are disabled.
+type storageMetrics struct {
+	duration *metrics.Histogram
+	bytes    *metrics.Counter
+	errors   *metrics.Counter
+}
+
+// WithMetrics reports the duration, size and failures of Init and Save in reg.
+// The metrics are shared by all storages using the same registry.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(s *settings) {
+		s.metrics = storageMetrics{
+			duration: reg.Histogram("storage_operation_duration_seconds",
+				"Duration of loading and saving snapshots.", metrics.DefaultBuckets, "op"),
+			bytes: reg.Counter("storage_operation_bytes_total",
+				"Bytes of snapshots and logs read by init and of snapshots written by save.", "op"),
+			errors: reg.Counter("storage_operation_errors_total", "Failed loads and saves.", "op"),
+		}
+	}
+}
+
+// observe records an operation that started at start and read or wrote size bytes.
+func (m storageMetrics) observe(op string, start time.Time, size int64, err error) {
+	m.duration.Observe(time.Since(start).Seconds(), op)
+
+	if err != nil {
+		m.errors.Inc(op)
+
+		return
+	}
+
+	m.bytes.Add(float64(size), op)
+}
+
+// fileSize returns the size of the named file, or 0 if it cannot be read.
+func fileSize(name string) int64 {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
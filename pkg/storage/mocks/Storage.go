@@ -3,7 +3,11 @@
 package mocks
 
 import (
+	context "context"
+
 	mock "github.com/stretchr/testify/mock"
+	storage "zabbix-technical-task/pkg/storage"
+
 	userrecord "zabbix-technical-task/pkg/userrecord"
 )
 
@@ -12,17 +16,35 @@ type Storage struct {
 	mock.Mock
 }
 
-// Init provides a mock function with given fields: records
-func (_m *Storage) Init(records map[uint64]userrecord.Record) error {
-	ret := _m.Called(records)
+// Append provides a mock function with given fields: ctx, entry
+func (_m *Storage) Append(ctx context.Context, entry storage.Entry) error {
+	ret := _m.Called(ctx, entry)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Append")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, storage.Entry) error); ok {
+		r0 = rf(ctx, entry)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Init provides a mock function with given fields: ctx, records
+func (_m *Storage) Init(ctx context.Context, records map[uint64]userrecord.Record) error {
+	ret := _m.Called(ctx, records)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Init")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(map[uint64]userrecord.Record) error); ok {
-		r0 = rf(records)
+	if rf, ok := ret.Get(0).(func(context.Context, map[uint64]userrecord.Record) error); ok {
+		r0 = rf(ctx, records)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -30,17 +52,17 @@ func (_m *Storage) Init(records map[uint64]userrecord.Record) error {
 	return r0
 }
 
-// Save provides a mock function with given fields: records
-func (_m *Storage) Save(records map[uint64]userrecord.Record) error {
-	ret := _m.Called(records)
+// Save provides a mock function with given fields: ctx, records
+func (_m *Storage) Save(ctx context.Context, records map[uint64]userrecord.Record) error {
+	ret := _m.Called(ctx, records)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Save")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(map[uint64]userrecord.Record) error); ok {
-		r0 = rf(records)
+	if rf, ok := ret.Get(0).(func(context.Context, map[uint64]userrecord.Record) error); ok {
+		r0 = rf(ctx, records)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// LoadReport describes the records loaded by the last Init.
+type LoadReport struct {
+	// Loaded is the number of records loaded.
+	Loaded int
+	// Invalid lists the loaded records that do not match the schema.
+	// They are kept, so that no data is lost when the schema changes.
+	Invalid []InvalidRecord
+	// Corrupted lists the snapshot and log lines and record files that could not be loaded.
+	Corrupted []CorruptEntry
+}
+
+// InvalidRecord is a loaded record that does not match the schema.
+type InvalidRecord struct {
+	ID         uint64
+	Violations []userrecord.Violation
+}
+
+// CorruptEntry is a snapshot or log line, or a record file, that could not be loaded.
+type CorruptEntry struct {
+	File   string
+	Line   int
+	Reason string
+}
+
+// Report returns the report of the last Init.
+func (s *state) Report() LoadReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.report
+}
+
+// checkSchema validates the records against the schema, in id order.
+func checkSchema(records map[uint64]userrecord.Record) LoadReport {
+	report := LoadReport{Loaded: len(records)}
+
+	ids := make([]uint64, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		var schemaErr *userrecord.SchemaError
+
+		if errors.As(records[id].ValidateSchema(), &schemaErr) {
+			report.Invalid = append(report.Invalid, InvalidRecord{ID: id, Violations: schemaErr.Violations})
+		}
+	}
+
+	return report
+}
+
+// reportCorrupted adds the corrupt entries to the report of the last load. In
+// strict mode any of them fails the load. The caller must hold s.mu.
+func (s *state) reportCorrupted(corrupted []CorruptEntry, strict bool) error {
+	s.report.Corrupted = corrupted
+
+	if strict && len(corrupted) > 0 {
+		return fmt.Errorf("%d corrupt entries, first in %q at line %d: %w",
+			len(corrupted), corrupted[0].File, corrupted[0].Line, ErrCorruptSnapshot)
+	}
+
+	return nil
+}
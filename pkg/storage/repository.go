@@ -1,20 +1,113 @@
 package storage
 
 import (
+	"context"
 	"errors"
 
 	"zabbix-technical-task/pkg/userrecord"
 )
 
+// Operations recorded in the write-ahead log.
+const (
+	OpAdd    Operation = "add"
+	OpUpdate Operation = "update"
+	OpDelete Operation = "delete"
+	// OpBatch groups entries that must be applied together or not at all.
+	OpBatch Operation = "batch"
+)
+
 var (
-	errOpenFile     = errors.New("failed to open file")
-	errScanFile     = errors.New("scanner error")
-	errCreateFile   = errors.New("failed to create file")
-	errWriteRecords = errors.New("failed to write records to file")
+	errOpenFile   = errors.New("failed to open file")
+	errScanFile   = errors.New("scanner error")
+	errCreateFile = errors.New("failed to create file")
+	errUnknownOp  = errors.New("unknown log operation")
+	errSyncFile   = errors.New("failed to sync file")
+	errRenameFile = errors.New("failed to rename file")
+	errCorruptSeq = errors.New("sequence is corrupt")
+
+	errSnapshotVersion = errors.New("unsupported snapshot version")
+	errMalformedLine   = errors.New("malformed line")
+	errChecksum        = errors.New("checksum mismatch")
+	errLineTooLong     = errors.New("line is too long")
+
+	// ErrWriteRecords is returned by Save when the snapshot cannot be written.
+	ErrWriteRecords = errors.New("failed to write records to file")
+	// ErrWriteLog is returned by Append when the entry cannot be written to the log.
+	ErrWriteLog = errors.New("failed to write to log")
+	// ErrSyncLog is returned when the log cannot be synced to disk.
+	ErrSyncLog = errors.New("failed to sync log")
+	// ErrTruncateLog is returned when the log cannot be truncated after a save.
+	ErrTruncateLog = errors.New("failed to truncate log")
+	// ErrNoSnapshot is returned by Init of a FileStorage when neither the
+	// snapshot nor any of its backups can be read.
+	ErrNoSnapshot = errors.New("no readable snapshot")
+	// ErrCorruptSnapshot is returned by Init in strict mode when a snapshot, log or record file is corrupt.
+	ErrCorruptSnapshot = errors.New("snapshot is corrupt")
+	// ErrNotFound is returned by Engine.Get when no record has the requested id.
+	ErrNotFound = errors.New("record not found in storage")
 )
 
+// Operation identifies the kind of change stored in a log entry.
+type Operation string
+
+// Entry is a single record change appended to the write-ahead log.
+type Entry struct {
+	Op     Operation         `json:"op"`
+	ID     uint64            `json:"id"`
+	Record userrecord.Record `json:"record,omitempty"`
+	// Entries holds the changes of an OpBatch entry.
+	Entries []Entry `json:"entries,omitempty"`
+}
+
+// maxID returns the highest record id the entry refers to.
+func (e Entry) maxID() uint64 {
+	id := e.ID
+	for _, nested := range e.Entries {
+		id = max(id, nested.maxID())
+	}
+
+	return id
+}
+
 // Storage defines the interface for storage operations.
 type Storage interface {
-	Init(records map[uint64]userrecord.Record) error
-	Save(records map[uint64]userrecord.Record) error
+	Init(ctx context.Context, records map[uint64]userrecord.Record) error
+	Save(ctx context.Context, records map[uint64]userrecord.Record) error
+	Append(ctx context.Context, entry Entry) error
+}
+
+// Checkpointer is implemented by storages that can save records while changes
+// are still being appended. Checkpoint marks the changes appended so far and
+// must not run concurrently with Append. SaveCheckpoint then saves records,
+// which must hold exactly the changes up to the checkpoint, and drops only the
+// logged changes it covers, keeping those appended since.
+type Checkpointer interface {
+	Checkpoint(ctx context.Context) (Checkpoint, error)
+	SaveCheckpoint(ctx context.Context, records map[uint64]userrecord.Record, checkpoint Checkpoint) error
+}
+
+// Checkpoint marks a position in the log of a Checkpointer.
+type Checkpoint struct {
+	// pos is the size of the write-ahead log of a FileStorage, or the number
+	// reserved for the compacted segment of a LogStorage.
+	pos uint64
+}
+
+// Sequencer is implemented by storages that remember the highest record id
+// they have ever persisted, so that deleted ids are never handed out again.
+type Sequencer interface {
+	Sequence() uint64
+}
+
+// Engine is a storage that reads records from disk on demand, so that its
+// records need not all be held in memory. Open prepares it instead of Init,
+// and Flush persists the logged changes instead of Save.
+type Engine interface {
+	Storage
+	Sequencer
+	Open(ctx context.Context) error
+	Get(ctx context.Context, id uint64) (userrecord.Record, error)
+	// Scan calls fn for every record in ascending id order, stopping at the first error.
+	Scan(ctx context.Context, fn func(id uint64, rec userrecord.Record) error) error
+	Flush(ctx context.Context) error
 }
@@ -0,0 +1,440 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+const (
+	// segmentSuffix ends the names of log segments.
+	segmentSuffix = ".log"
+	// compactedSuffix ends the names of compacted segments, which hold all
+	// records as of a save.
+	compactedSuffix = ".snap"
+	// defaultSegmentSize is the size at which a new segment is started by default.
+	defaultSegmentSize = 64 << 20
+)
+
+var (
+	_ Store        = (*LogStorage)(nil)
+	_ Checkpointer = (*LogStorage)(nil)
+)
+
+// LogStorage keeps records in a directory of numbered, append-only log
+// segments. Every change is appended to the active segment, and a new one is
+// started once it reaches the segment size. Save compacts the log: it writes
+// all records to a compacted segment and deletes the segments before it.
+type LogStorage struct {
+	state
+	dir         string
+	sync        SyncPolicy
+	segmentSize int64
+	strict      bool
+	metrics     storageMetrics
+	active      *wal
+	// next is the number of the next segment to start.
+	next uint64
+}
+
+// segment is a file of a LogStorage.
+type segment struct {
+	num       uint64
+	name      string
+	compacted bool
+}
+
+// WithSegmentSize sets the size in bytes at which a LogStorage starts a new
+// segment. The default is 64 MiB.
+func WithSegmentSize(size int64) Option {
+	return func(s *settings) {
+		s.segmentSize = max(size, 1)
+	}
+}
+
+// NewLogStorage creates a LogStorage keeping its segments in dir.
+func NewLogStorage(dir string, opts ...Option) *LogStorage {
+	s := newSettings(opts)
+
+	return &LogStorage{
+		dir:         dir,
+		sync:        s.sync,
+		segmentSize: s.segmentSize,
+		strict:      s.strict,
+		metrics:     s.metrics,
+		next:        1,
+	}
+}
+
+// Init loads the newest compacted segment and replays the segments written
+// after it. A missing directory holds no records.
+func (l *LogStorage) Init(ctx context.Context, records map[uint64]userrecord.Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := time.Now()
+
+	size, err := l.load(ctx, records)
+	l.metrics.observe(metricInit, start, size, err)
+
+	return err
+}
+
+// load reads the current segments and returns their size. The caller must hold the lock.
+func (l *LogStorage) load(ctx context.Context, records map[uint64]userrecord.Record) (int64, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, fmt.Errorf("loading %q: %w", l.dir, err)
+	}
+
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	// Segments before the newest compacted one are left over from an interrupted save.
+	first := 0
+
+	for i, seg := range segments {
+		if seg.compacted {
+			first = i
+		}
+	}
+
+	var (
+		size      int64
+		logID     uint64
+		corrupted []CorruptEntry
+	)
+
+	for _, seg := range segments[first:] {
+		if seg.compacted {
+			var entries []CorruptEntry
+
+			entries, err = readSnapshot(ctx, seg.name, records)
+			corrupted = append(corrupted, entries...)
+
+			if errors.Is(err, ErrCorruptSnapshot) {
+				slog.WarnContext(ctx, "compacted segment may be incomplete", "err", err)
+
+				err = nil
+			}
+		} else {
+			var (
+				id      uint64
+				entries []CorruptEntry
+			)
+
+			id, entries, err = newWAL(seg.name, l.sync).replay(ctx, records)
+			logID = max(logID, id)
+			corrupted = append(corrupted, entries...)
+		}
+
+		if err != nil {
+			return size, fmt.Errorf("loading segment: %w", err)
+		}
+
+		size += fileSize(seg.name)
+	}
+
+	if len(segments) > 0 {
+		l.next = segments[len(segments)-1].num + 1
+	}
+
+	seq, err := readSequence(filepath.Join(l.dir, sequenceFile))
+	if err != nil {
+		return size, fmt.Errorf("initializing sequence: %w", err)
+	}
+
+	l.loaded(records, max(seq, logID))
+
+	return size, l.reportCorrupted(corrupted, l.strict)
+}
+
+// Save compacts the log into a new compacted segment holding records. If ctx is
+// done before all records are written, the segments are left as they were and
+// the context's error is returned.
+func (l *LogStorage) Save(ctx context.Context, records map[uint64]userrecord.Record) error {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := time.Now()
+
+	size, err := l.compact(ctx, records)
+	l.metrics.observe(metricSave, start, size, err)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to save records", "dir", l.dir, "err", err)
+	}
+
+	return err
+}
+
+// compact writes the compacted segment and deletes the segments it replaces.
+// The caller must hold the lock.
+func (l *LogStorage) compact(ctx context.Context, records map[uint64]userrecord.Record) (int64, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, fmt.Errorf("saving to %q: %w", l.dir, err)
+	}
+
+	err = l.prepareCompaction(ctx, records)
+	if err != nil {
+		return 0, err
+	}
+
+	// Changes appended from now on go to a segment after the compacted one.
+	l.closeActive(ctx)
+
+	num := l.next
+	name := segmentName(l.dir, num, compactedSuffix)
+
+	err = writeAtomic(ctx, name, func(file *os.File) error {
+		return writeAndSync(ctx, file, records)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	l.next = num + 1
+	l.removeBefore(ctx, num)
+
+	return fileSize(name), nil
+}
+
+// Checkpoint closes the active segment and reserves the number of the
+// compacted segment, so that changes appended from now on go after it.
+func (l *LogStorage) Checkpoint(ctx context.Context) (Checkpoint, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closeActive(ctx)
+
+	num := l.next
+	l.next++
+
+	return Checkpoint{pos: num}, nil
+}
+
+// SaveCheckpoint writes records to the compacted segment reserved by
+// checkpoint and deletes the segments before it. Changes can be appended while
+// the records are being written.
+func (l *LogStorage) SaveCheckpoint(ctx context.Context, records map[uint64]userrecord.Record,
+	checkpoint Checkpoint,
+) error {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	start := time.Now()
+
+	size, err := l.compactCheckpoint(ctx, records, checkpoint.pos)
+	l.metrics.observe(metricSave, start, size, err)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to save records", "dir", l.dir, "err", err)
+	}
+
+	return err
+}
+
+// compactCheckpoint writes the compacted segment num without holding the
+// lock. The caller must hold saveMu.
+func (l *LogStorage) compactCheckpoint(ctx context.Context, records map[uint64]userrecord.Record,
+	num uint64,
+) (int64, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, fmt.Errorf("saving to %q: %w", l.dir, err)
+	}
+
+	l.mu.Lock()
+	err = l.prepareCompaction(ctx, records)
+	l.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	name := segmentName(l.dir, num, compactedSuffix)
+
+	err = writeAtomic(ctx, name, func(file *os.File) error {
+		return writeAndSync(ctx, file, records)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.removeBefore(ctx, num)
+
+	return fileSize(name), nil
+}
+
+// prepareCompaction creates the directory and persists the sequence, which
+// must be durable before the segments that raised it are dropped. The caller
+// must hold the lock.
+func (l *LogStorage) prepareCompaction(ctx context.Context, records map[uint64]userrecord.Record) error {
+	err := os.MkdirAll(l.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating %q: %w", l.dir, errCreateFile)
+	}
+
+	for id := range records {
+		l.observeID(id)
+	}
+
+	err = writeSequence(ctx, filepath.Join(l.dir, sequenceFile), l.lastID)
+	if err != nil {
+		return fmt.Errorf("saving sequence: %w", err)
+	}
+
+	return nil
+}
+
+// Append durably records a single change in the active segment.
+func (l *LogStorage) Append(ctx context.Context, entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.append(ctx, entry)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to append to log", "op", entry.Op, "id", entry.ID, "err", err)
+
+		return fmt.Errorf("appending %s of record with id %d: %w", entry.Op, entry.ID, err)
+	}
+
+	l.observeID(entry.maxID())
+
+	return nil
+}
+
+// append writes entry to the active segment, starting one if there is none.
+// The caller must hold the lock.
+func (l *LogStorage) append(ctx context.Context, entry Entry) error {
+	if l.active == nil {
+		err := os.MkdirAll(l.dir, 0o755)
+		if err != nil {
+			return fmt.Errorf("creating %q: %w", l.dir, errCreateFile)
+		}
+
+		l.active = newWAL(segmentName(l.dir, l.next, segmentSuffix), l.sync)
+		l.next++
+	}
+
+	err := l.active.append(entry)
+	if err != nil {
+		return err
+	}
+
+	if l.active.written >= l.segmentSize {
+		l.closeActive(ctx)
+	}
+
+	return nil
+}
+
+// Close closes the active segment.
+func (l *LogStorage) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return nil
+	}
+
+	err := l.active.close()
+	l.active = nil
+
+	return err
+}
+
+// closeActive closes the active segment, so that the next change starts a new one.
+// Its entries are already written, so failing to close it only loses the file handle.
+func (l *LogStorage) closeActive(ctx context.Context) {
+	if l.active == nil {
+		return
+	}
+
+	err := l.active.close()
+	if err != nil {
+		slog.WarnContext(ctx, "failed to close segment", "file", l.active.filename, "err", err)
+	}
+
+	l.active = nil
+}
+
+// removeBefore deletes the segments numbered below num.
+func (l *LogStorage) removeBefore(ctx context.Context, num uint64) {
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to list segments", "err", err)
+
+		return
+	}
+
+	for _, seg := range segments {
+		if seg.num >= num {
+			break
+		}
+
+		err = os.Remove(seg.name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.WarnContext(ctx, "failed to remove segment", "file", seg.name, "err", err)
+		}
+	}
+}
+
+// listSegments returns the segments in dir in the order they were written.
+// A missing directory has none.
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading directory %q: %w", dir, errOpenFile)
+	}
+
+	// Segment numbers are zero-padded, so the entries are already in order.
+	var segments []segment
+
+	for _, entry := range entries {
+		base, compacted := strings.CutSuffix(entry.Name(), compactedSuffix)
+		if !compacted {
+			var isLog bool
+
+			base, isLog = strings.CutSuffix(entry.Name(), segmentSuffix)
+			if !isLog {
+				continue
+			}
+		}
+
+		num, err := strconv.ParseUint(base, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		segments = append(segments, segment{num: num, name: filepath.Join(dir, entry.Name()), compacted: compacted})
+	}
+
+	return segments, nil
+}
+
+// segmentName returns the name of the segment numbered num.
+func segmentName(dir string, num uint64, suffix string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", num, suffix))
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// seqSuffix is appended to the snapshot filename to name the file holding the
+// highest record id ever persisted.
+const seqSuffix = ".seq"
+
+// sequenceFile names the file holding the sequence of a LogStorage or DirStorage.
+const sequenceFile = "sequence"
+
+// state is what every backend knows about the records it persisted. Its mutex
+// guards the whole backend; backends whose reads can run concurrently take it
+// for reading only.
+type state struct {
+	mu sync.RWMutex
+	// saveMu serializes saves, which may release mu while writing records.
+	saveMu sync.Mutex
+	lastID uint64
+	report LoadReport
+}
+
+// Sequence returns the highest record id the storage has ever persisted,
+// including ids of records that were deleted since.
+func (s *state) Sequence() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastID
+}
+
+// observeID raises the sequence to id. The caller must hold s.mu.
+func (s *state) observeID(id uint64) {
+	s.lastID = max(s.lastID, id)
+}
+
+// RaiseSequence raises the sequence to seq, if it is lower; the next Save
+// persists it.
+func (s *state) RaiseSequence(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observeID(seq)
+}
+
+// loaded raises the sequence to seq and to the ids of the loaded records and
+// checks them against the schema. The caller must hold s.mu.
+func (s *state) loaded(records map[uint64]userrecord.Record, seq uint64) {
+	s.observeID(seq)
+
+	for id := range records {
+		s.observeID(id)
+	}
+
+	s.report = checkSchema(records)
+}
+
+// readSequence reads a sequence file. A missing file means no ids were issued yet.
+func readSequence(name string) (uint64, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("reading sequence %q: %w", name, errOpenFile)
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing sequence %q: %w", name, errCorruptSeq)
+	}
+
+	return seq, nil
+}
+
+// writeSequence atomically replaces the sequence file.
+func writeSequence(ctx context.Context, name string, seq uint64) error {
+	return writeAtomic(ctx, name, func(file *os.File) error {
+		_, err := file.WriteString(strconv.FormatUint(seq, 10) + "\n")
+		if err != nil {
+			return fmt.Errorf("writing sequence: %w", ErrWriteRecords)
+		}
+
+		err = file.Sync()
+		if err != nil {
+			return fmt.Errorf("syncing sequence: %w", errSyncFile)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// loadSnapshot loads records from the primary snapshot, or from the newest
+// backup if the primary is missing. Corrupt entries are skipped and returned,
+// so that the intact ones are still loaded: the log only holds the changes
+// made since the primary was written, so replaying it onto an older backup
+// would lose the changes in between. A snapshot that cannot be opened or read
+// fails the load instead, as it may be intact and newer than every backup.
+func (f *FileStorage) loadSnapshot(ctx context.Context, records map[uint64]userrecord.Record) ([]CorruptEntry, error) {
+	for _, name := range f.snapshotNames() {
+		corrupted, err := readSnapshot(ctx, name, records)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("loading %q: %w", name, ctx.Err())
+		}
+
+		if errors.Is(err, os.ErrNotExist) {
+			slog.WarnContext(ctx, "skipping missing snapshot", "file", name)
+
+			continue
+		}
+
+		if errors.Is(err, ErrCorruptSnapshot) {
+			slog.WarnContext(ctx, "snapshot may be incomplete", "err", err)
+		} else if err != nil {
+			return nil, fmt.Errorf("loading %q: %w", name, err)
+		}
+
+		if name != f.filename {
+			slog.WarnContext(ctx, "primary snapshot is missing, recovered from backup", "file", f.filename, "backup", name)
+		}
+
+		return corrupted, nil
+	}
+
+	return nil, fmt.Errorf("loading %q: %w", f.filename, ErrNoSnapshot)
+}
+
+// snapshotNames returns the primary snapshot followed by its backups, newest first.
+func (f *FileStorage) snapshotNames() []string {
+	names := make([]string, 0, f.backups+1)
+
+	names = append(names, f.filename)
+	for i := 1; i <= f.backups; i++ {
+		names = append(names, backupName(f.filename, i))
+	}
+
+	return names
+}
+
+// writeSnapshot atomically replaces the snapshot file with records and
+// rotates the replaced one into the backups. A save that fails leaves the
+// backups as they were.
+func (f *FileStorage) writeSnapshot(ctx context.Context, records map[uint64]userrecord.Record) error {
+	write := func(file *os.File) error {
+		return writeAndSync(ctx, file, records)
+	}
+
+	if f.backups == 0 {
+		return writeAtomic(ctx, f.filename, write)
+	}
+
+	// The replaced snapshot keeps a name of its own until it becomes a backup.
+	previous := f.filename + previousSuffix
+
+	err := os.Remove(previous)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.WarnContext(ctx, "failed to remove previous snapshot", "file", previous, "err", err)
+	}
+
+	err = os.Link(f.filename, previous)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.WarnContext(ctx, "failed to back up snapshot", "file", f.filename, "err", err)
+	}
+
+	err = writeAtomic(ctx, f.filename, write)
+	if err != nil {
+		removeErr := os.Remove(previous)
+		if removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			slog.WarnContext(ctx, "failed to remove previous snapshot", "file", previous, "err", removeErr)
+		}
+
+		return err
+	}
+
+	f.rotate(ctx, previous)
+
+	return nil
+}
+
+// rotate shifts the existing backups by one, dropping the oldest one, and
+// renames previous, the snapshot just replaced, to the newest backup. There
+// is nothing to rotate if there was no snapshot to replace.
+func (f *FileStorage) rotate(ctx context.Context, previous string) {
+	_, err := os.Stat(previous)
+	if err != nil {
+		return
+	}
+
+	for i := f.backups - 1; i >= 1; i-- {
+		err = os.Rename(backupName(f.filename, i), backupName(f.filename, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.WarnContext(ctx, "failed to rotate snapshot backup", "backup", i, "err", err)
+		}
+	}
+
+	err = os.Rename(previous, backupName(f.filename, 1))
+	if err != nil {
+		slog.WarnContext(ctx, "failed to back up snapshot", "file", previous, "err", err)
+	}
+}
+
+// writeAtomic replaces filename with the content produced by write. The data is
+// written and synced to a temporary file that is then renamed over the original,
+// so a crash never leaves a partially written file behind.
+func writeAtomic(ctx context.Context, filename string, write func(file *os.File) error) error {
+	dir := filepath.Dir(filename)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file for %q: %w", filename, errCreateFile)
+	}
+
+	tmpName := tmp.Name()
+
+	defer func() {
+		removeErr := os.Remove(tmpName)
+		if removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			slog.WarnContext(ctx, "failed to remove temporary file", "file", tmpName, "err", removeErr)
+		}
+	}()
+
+	err = write(tmp)
+
+	closeErr := tmp.Close()
+	if err == nil && closeErr != nil {
+		err = fmt.Errorf("closing %q: %w", tmpName, ErrWriteRecords)
+	}
+
+	if err != nil {
+		return fmt.Errorf("saving to file %q: %w", filename, err)
+	}
+
+	err = os.Rename(tmpName, filename)
+	if err != nil {
+		return fmt.Errorf("replacing %q: %w", filename, errRenameFile)
+	}
+
+	err = syncDir(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("saving to file %q: %w", filename, err)
+	}
+
+	return nil
+}
+
+// readSnapshot loads records from a single snapshot file and returns the lines
+// that could not be loaded. It returns ErrCorruptSnapshot, with the intact
+// records still loaded, if there are any.
+func readSnapshot(ctx context.Context, name string, records map[uint64]userrecord.Record) ([]CorruptEntry, error) {
+	file, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("opening file %q: %w", name, err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("opening file %q: %w", name, errOpenFile)
+	}
+
+	defer func() {
+		closeErr := file.Close()
+		if closeErr != nil {
+			slog.WarnContext(ctx, "failed to close file", "file", name, "err", closeErr)
+		}
+	}()
+
+	corrupted, err := loadFromReader(ctx, file, records)
+	if err != nil {
+		return nil, fmt.Errorf("scanning file %q: %w", name, err)
+	}
+
+	for i := range corrupted {
+		corrupted[i].File = name
+	}
+
+	if len(corrupted) > 0 {
+		return corrupted, fmt.Errorf("file %q has %d corrupt lines: %w", name, len(corrupted), ErrCorruptSnapshot)
+	}
+
+	return nil, nil
+}
+
+// writeAndSync writes records to file through a buffer and flushes them to disk.
+func writeAndSync(ctx context.Context, file *os.File, records map[uint64]userrecord.Record) error {
+	buf := bufio.NewWriter(file)
+
+	err := saveToWriter(ctx, buf, records)
+	if err != nil {
+		return err
+	}
+
+	err = buf.Flush()
+	if err != nil {
+		return fmt.Errorf("flushing %q: %w", file.Name(), ErrWriteRecords)
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return fmt.Errorf("syncing %q: %w", file.Name(), errSyncFile)
+	}
+
+	return nil
+}
+
+// syncDir flushes directory metadata, making a preceding rename durable.
+func syncDir(ctx context.Context, dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("opening directory %q: %w", dir, errOpenFile)
+	}
+
+	defer func() {
+		closeErr := d.Close()
+		if closeErr != nil {
+			slog.WarnContext(ctx, "failed to close directory", "dir", dir, "err", closeErr)
+		}
+	}()
+
+	err = d.Sync()
+	if err != nil {
+		return fmt.Errorf("syncing directory %q: %w", dir, errSyncFile)
+	}
+
+	return nil
+}
+
+// backupName returns the name of the n-th rotated snapshot.
+func backupName(filename string, n int) string {
+	return filename + "." + strconv.Itoa(n)
+}
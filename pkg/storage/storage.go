@@ -2,131 +2,549 @@ package storage
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"log"
-	"os"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
 
 	"zabbix-technical-task/pkg/userrecord"
 )
 
-var _ Storage = (*FileStorage)(nil)
+const (
+	// walSuffix is appended to the snapshot filename to name its write-ahead log.
+	walSuffix = ".wal"
+	// defaultBackups is the number of previous snapshots kept by default.
+	defaultBackups = 3
+	// previousSuffix is appended to the snapshot filename to name the snapshot
+	// being replaced by a save, until it is rotated into the backups.
+	previousSuffix = ".prev"
+	// snapshotHeader starts the first line of a snapshot, followed by its version.
+	snapshotHeader = "#records-snapshot"
+	// snapshotVersion is the version of the snapshot format written by saveToWriter.
+	snapshotVersion = 1
+	// snapshotFooter starts the last line of a snapshot, followed by its record count.
+	snapshotFooter = "#end"
+	// MaxLine is the longest line read from a snapshot, log or table, and so
+	// bounds the size of a logged change. It is well above the default body
+	// limit of the API, which refuses to be configured with a larger one, so
+	// that a corrupt file without line breaks cannot exhaust memory.
+	MaxLine = 64 << 20
+)
+
+// castagnoli is the CRC32C table used for the checksums of snapshot lines.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	_ Store        = (*FileStorage)(nil)
+	_ Checkpointer = (*FileStorage)(nil)
+)
 
 // FileStorage implements StorageRepo interface for file-based storage.
+// Records are kept in a snapshot file, and every change made since the last
+// snapshot is appended to a write-ahead log next to it.
 type FileStorage struct {
+	state
 	filename string
+	backups  int
+	strict   bool
+	wal      *wal
+	metrics  storageMetrics
+}
+
+// Option configures a storage. Each backend ignores the options that do not apply to it.
+type Option func(*settings)
+
+// settings are the options of all backends.
+type settings struct {
+	sync         SyncPolicy
+	backups      int
+	segmentSize  int64
+	memtableSize int64
+	strict       bool
+	metrics      storageMetrics
+}
+
+// WithSyncPolicy sets when the write-ahead log of a FileStorage or LSMStorage,
+// or the active segment of a LogStorage, is flushed to stable storage. The
+// default is SyncAlways.
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(s *settings) {
+		s.sync = policy
+	}
+}
+
+// WithBackups sets how many previous snapshots a FileStorage keeps next to the
+// file as filename.1 (newest) to filename.N (oldest). The default is 3.
+func WithBackups(n int) Option {
+	return func(s *settings) {
+		s.backups = max(n, 0)
+	}
+}
+
+// WithStrict makes Init of a storage that keeps files fail with
+// ErrCorruptSnapshot when a snapshot, log or record file is corrupt, instead of
+// loading the intact entries and listing the others in the load report.
+func WithStrict() Option {
+	return func(s *settings) {
+		s.strict = true
+	}
+}
+
+// newSettings applies opts to the default settings.
+func newSettings(opts []Option) settings {
+	s := settings{
+		sync:         SyncAlways,
+		backups:      defaultBackups,
+		segmentSize:  defaultSegmentSize,
+		memtableSize: defaultMemtableSize,
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return s
 }
 
 // NewFileStorage creates a new FileStorage instance with the given filename.
-func NewFileStorage(filename string) *FileStorage {
+func NewFileStorage(filename string, opts ...Option) *FileStorage {
+	s := newSettings(opts)
+
 	return &FileStorage{
 		filename: filename,
+		backups:  s.backups,
+		strict:   s.strict,
+		wal:      newWAL(filename+walSuffix, s.sync),
+		metrics:  s.metrics,
 	}
 }
 
 // InitFromReader initializes the storage by loading records from the provided reader.
-func (f *FileStorage) InitFromReader(r io.Reader, records map[uint64]userrecord.Record) error {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		var rec userrecord.Record
+func (f *FileStorage) InitFromReader(ctx context.Context, r io.Reader, records map[uint64]userrecord.Record) error {
+	_, err := loadFromReader(ctx, r, records)
+	if err != nil {
+		return fmt.Errorf("scanning file %q: %w", f.filename, err)
+	}
 
-		err := json.Unmarshal(scanner.Bytes(), &rec)
-		if err != nil {
-			log.Printf("failed to unmarshal a record: %v", err)
+	return nil
+}
 
-			continue
-		}
+// Init initializes the storage by loading records from the file
+// and replaying the write-ahead log on top of them. It stops with the
+// context's error if ctx is done before all records are loaded.
+func (f *FileStorage) Init(ctx context.Context, records map[uint64]userrecord.Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-		err = rec.Validate()
-		if err != nil {
-			log.Printf("failed to validate a record: %v", err)
+	start := time.Now()
 
-			continue
-		}
+	err := f.load(ctx, records)
+	f.metrics.observe(metricInit, start, fileSize(f.filename)+fileSize(f.wal.filename), err)
 
-		id, err := rec.ID()
-		if err != nil {
-			log.Printf("failed to get record ID: %v", err)
+	return err
+}
 
-			continue
-		}
+// load reads the snapshot and replays the log. The caller must hold the lock.
+func (f *FileStorage) load(ctx context.Context, records map[uint64]userrecord.Record) error {
+	corrupted, err := f.loadSnapshot(ctx, records)
+	if err != nil {
+		return err
+	}
 
-		records[id] = rec
+	logID, logCorrupted, err := f.wal.replay(ctx, records)
+	if err != nil {
+		return fmt.Errorf("initializing from log: %w", err)
 	}
 
-	err := scanner.Err()
+	corrupted = append(corrupted, logCorrupted...)
+
+	seq, err := readSequence(f.filename + seqSuffix)
 	if err != nil {
-		return fmt.Errorf("scanning file %q: %w", f.filename, errScanFile)
+		return fmt.Errorf("initializing sequence: %w", err)
 	}
 
-	return nil
+	f.loaded(records, max(seq, logID))
+
+	return f.reportCorrupted(corrupted, f.strict)
 }
 
-// Init initializes the storage by loading records from the file.
-func (f *FileStorage) Init(records map[uint64]userrecord.Record) error {
-	file, err := os.Open(f.filename)
+// Save writes all records to the storage file and truncates the write-ahead log.
+// If ctx is done before all records are written, the storage file and the log
+// are left as they were and the context's error is returned.
+func (f *FileStorage) Save(ctx context.Context, records map[uint64]userrecord.Record) error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	start := time.Now()
+
+	err := f.save(ctx, records)
+	f.metrics.observe(metricSave, start, fileSize(f.filename), err)
+
 	if err != nil {
-		return fmt.Errorf("opening file %q: %w", f.filename, errOpenFile)
+		slog.ErrorContext(ctx, "failed to save records", "file", f.filename, "err", err)
 	}
 
-	defer func() {
-		err = file.Close()
-		if err != nil {
-			log.Printf("failed to close file %q: %v", f.filename, err)
-		}
-	}()
+	return err
+}
+
+// save writes the snapshot and compacts the log. The caller must hold the lock.
+func (f *FileStorage) save(ctx context.Context, records map[uint64]userrecord.Record) error {
+	err := ctx.Err()
+	if err != nil {
+		return fmt.Errorf("saving to file %q: %w", f.filename, err)
+	}
 
-	err = f.InitFromReader(file, records)
+	err = f.writeSnapshot(ctx, records)
 	if err != nil {
-		return fmt.Errorf("initializing from file %q: %w", f.filename, err)
+		return err
+	}
+
+	for id := range records {
+		f.observeID(id)
+	}
+
+	// The sequence must be durable before the log entries that raised it are dropped.
+	err = writeSequence(ctx, f.filename+seqSuffix, f.lastID)
+	if err != nil {
+		return fmt.Errorf("saving sequence: %w", err)
+	}
+
+	err = f.wal.truncate()
+	if err != nil {
+		return fmt.Errorf("compacting log: %w", err)
 	}
 
 	return nil
 }
 
-// Save writes all records to the storage file.
-func (f *FileStorage) Save(records map[uint64]userrecord.Record) error {
-	file, err := os.Create(f.filename)
+// Checkpoint marks the end of the write-ahead log.
+func (f *FileStorage) Checkpoint(context.Context) (Checkpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return Checkpoint{pos: uint64(fileSize(f.wal.filename))}, nil
+}
+
+// SaveCheckpoint writes records to the storage file and drops the entries of
+// the write-ahead log before checkpoint. Changes can be appended while the
+// records are being written.
+func (f *FileStorage) SaveCheckpoint(ctx context.Context, records map[uint64]userrecord.Record,
+	checkpoint Checkpoint,
+) error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	start := time.Now()
+
+	err := f.saveCheckpoint(ctx, records, checkpoint)
+	f.metrics.observe(metricSave, start, fileSize(f.filename), err)
+
 	if err != nil {
-		return fmt.Errorf("creating file %q: %w", f.filename, errCreateFile)
+		slog.ErrorContext(ctx, "failed to save records", "file", f.filename, "err", err)
 	}
 
-	defer func() {
-		closeErr := file.Close()
-		if closeErr != nil {
-			log.Printf("failed to close file %q: %v", f.filename, closeErr)
-		}
-	}()
+	return err
+}
 
-	err = saveToWriter(file, records)
+// saveCheckpoint writes the snapshot without holding the lock, then takes it
+// to compact the log. The caller must hold saveMu.
+func (f *FileStorage) saveCheckpoint(ctx context.Context, records map[uint64]userrecord.Record,
+	checkpoint Checkpoint,
+) error {
+	err := ctx.Err()
 	if err != nil {
 		return fmt.Errorf("saving to file %q: %w", f.filename, err)
 	}
 
+	err = f.writeSnapshot(ctx, records)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for id := range records {
+		f.observeID(id)
+	}
+
+	err = writeSequence(ctx, f.filename+seqSuffix, f.lastID)
+	if err != nil {
+		return fmt.Errorf("saving sequence: %w", err)
+	}
+
+	err = f.wal.discard(ctx, int64(checkpoint.pos))
+	if err != nil {
+		return fmt.Errorf("compacting log: %w", err)
+	}
+
+	return nil
+}
+
+// Append durably records a single change in the write-ahead log.
+func (f *FileStorage) Append(ctx context.Context, entry Entry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.wal.append(entry)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to append to log", "op", entry.Op, "id", entry.ID, "err", err)
+
+		return fmt.Errorf("appending %s of record with id %d: %w", entry.Op, entry.ID, err)
+	}
+
+	f.observeID(entry.maxID())
+
 	return nil
 }
 
-// saveToWriter writes all records to the provided writer.
-func saveToWriter(w io.Writer, records map[uint64]userrecord.Record) error {
+// Close closes the write-ahead log.
+func (f *FileStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.wal.close()
+}
+
+// loadFromReader loads records from the provided reader and returns the lines
+// that could not be loaded. Records without a valid id are skipped; schema
+// violations are left to the load report. Later lines win over earlier ones.
+func loadFromReader(ctx context.Context, r io.Reader, records map[uint64]userrecord.Record) ([]CorruptEntry, error) {
+	return ScanSnapshot(ctx, r, func(_ int, rec userrecord.Record) {
+		err := rec.ValidateID()
+		if err != nil {
+			slog.WarnContext(ctx, "failed to validate a record", "err", err)
+
+			return
+		}
+
+		id, err := rec.ID()
+		if err != nil {
+			slog.WarnContext(ctx, "failed to get record ID", "err", err)
+
+			return
+		}
+
+		records[id] = rec
+	})
+}
+
+// ScanSnapshot calls fn with every record read from a snapshot and its line
+// number, in file order and without checking ids, and returns the lines that
+// could not be read. A snapshot that starts with a header has a checksum on
+// every line and ends with a footer counting its records; one without a
+// header is read as plain JSON lines, as written by earlier versions.
+func ScanSnapshot(ctx context.Context, r io.Reader, fn func(line int, rec userrecord.Record)) ([]CorruptEntry, error) {
+	var (
+		corrupted []CorruptEntry
+		line      int
+		checked   bool // the snapshot has a header
+		count     int  // record lines of a checked snapshot
+		footer    = -1 // records counted by the footer, -1 until it is read
+		footerAt  int
+	)
+
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		err := ctx.Err()
+		if err != nil {
+			return corrupted, fmt.Errorf("loading records: %w", err)
+		}
+
+		line++
+		data := scanner.Bytes()
+
+		if line == 1 {
+			checked, err = parseHeader(data)
+			if err != nil {
+				return corrupted, err
+			}
+
+			if checked {
+				continue
+			}
+		}
+
+		if checked {
+			if footer >= 0 {
+				corrupted = append(corrupted, CorruptEntry{Line: line, Reason: "data after the footer"})
+
+				continue
+			}
+
+			if n, ok := parseFooter(data); ok {
+				footer, footerAt = n, line
+
+				continue
+			}
+
+			count++
+
+			data, err = verifyLine(data)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to verify a record", "line", line, "err", err)
+
+				corrupted = append(corrupted, CorruptEntry{Line: line, Reason: err.Error()})
+
+				continue
+			}
+		}
+
+		var rec userrecord.Record
+
+		err = json.Unmarshal(data, &rec)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to unmarshal a record", "err", err)
+
+			corrupted = append(corrupted, CorruptEntry{Line: line, Reason: "undecodable record"})
+
+			continue
+		}
+
+		fn(line, rec)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return corrupted, scanError(err)
+	}
+
+	switch {
+	case !checked:
+	case footer < 0:
+		corrupted = append(corrupted, CorruptEntry{
+			Line:   line + 1,
+			Reason: "missing footer, the snapshot may be truncated",
+		})
+	case footer != count:
+		corrupted = append(corrupted, CorruptEntry{
+			Line:   footerAt,
+			Reason: fmt.Sprintf("footer counts %d records, found %d", footer, count),
+		})
+	}
+
+	return corrupted, nil
+}
+
+// saveToWriter writes all records to the provided writer between a header
+// and a footer, each with its checksum, stopping early if ctx is done.
+func saveToWriter(ctx context.Context, w io.Writer, records map[uint64]userrecord.Record) error {
+	_, err := fmt.Fprintf(w, "%s %d\n", snapshotHeader, snapshotVersion)
+	if err != nil {
+		return fmt.Errorf("writing header to writer: %w", ErrWriteRecords)
+	}
+
+	count := 0
+
 	for _, rec := range records {
+		err = ctx.Err()
+		if err != nil {
+			return fmt.Errorf("writing records: %w", err)
+		}
+
 		data, marshalErr := json.Marshal(rec)
 		if marshalErr != nil {
-			log.Println(marshalErr)
+			slog.ErrorContext(ctx, "failed to marshal a record", "err", marshalErr)
 
 			continue
 		}
 
-		_, err := w.Write(data)
+		_, err = w.Write(encodeLine(data))
 		if err != nil {
-			return fmt.Errorf("writing to writer: %w", errWriteRecords)
+			return fmt.Errorf("writing to writer: %w", ErrWriteRecords)
 		}
 
-		_, err = w.Write([]byte("\n"))
-		if err != nil {
-			return fmt.Errorf("writing newline to writer: %w", errWriteRecords)
-		}
+		count++
+	}
+
+	_, err = fmt.Fprintf(w, "%s %d\n", snapshotFooter, count)
+	if err != nil {
+		return fmt.Errorf("writing footer to writer: %w", ErrWriteRecords)
 	}
 
 	return nil
 }
+
+// parseHeader reports whether line is the header of a checksummed snapshot.
+// Any other line starts a legacy snapshot of plain JSON lines.
+func parseHeader(line []byte) (bool, error) {
+	version, ok := strings.CutPrefix(string(line), snapshotHeader+" ")
+	if !ok {
+		return false, nil
+	}
+
+	if version != strconv.Itoa(snapshotVersion) {
+		return false, fmt.Errorf("snapshot version %q: %w", version, errSnapshotVersion)
+	}
+
+	return true, nil
+}
+
+// parseFooter returns the record count of line if it is a snapshot footer.
+func parseFooter(line []byte) (int, bool) {
+	count, ok := strings.CutPrefix(string(line), snapshotFooter+" ")
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// encodeLine returns a snapshot line holding data and its checksum.
+func encodeLine(data []byte) []byte {
+	line := make([]byte, 0, len(data)+10)
+	line = fmt.Appendf(line, "%08x ", crc32.Checksum(data, castagnoli))
+	line = append(line, data...)
+
+	return append(line, '\n')
+}
+
+// verifyLine checks the checksum of a snapshot line and returns its data.
+func verifyLine(line []byte) ([]byte, error) {
+	sum, data, ok := strings.Cut(string(line), " ")
+	if !ok || len(sum) != 8 {
+		return nil, errMalformedLine
+	}
+
+	want, err := strconv.ParseUint(sum, 16, 32)
+	if err != nil {
+		return nil, errMalformedLine
+	}
+
+	if crc32.Checksum([]byte(data), castagnoli) != uint32(want) {
+		return nil, errChecksum
+	}
+
+	return []byte(data), nil
+}
+
+// newLineScanner returns a scanner of the lines of r of up to MaxLine bytes.
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), MaxLine)
+
+	return scanner
+}
+
+// scanError returns the error for a line scanner that failed with err.
+func scanError(err error) error {
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("%w: %w, the limit is %d bytes", errScanFile, errLineTooLong, MaxLine)
+	}
+
+	return errScanFile
+}
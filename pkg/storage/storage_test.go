@@ -2,11 +2,20 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 
+	"zabbix-technical-task/pkg/metrics"
 	"zabbix-technical-task/pkg/userrecord"
 )
 
@@ -40,14 +49,14 @@ func TestInitFromReader(t *testing.T) {
 	storage := &FileStorage{}
 	records := make(map[uint64]userrecord.Record)
 
-	err := storage.InitFromReader(reader, records)
+	err := storage.InitFromReader(t.Context(), reader, records)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	reader = strings.NewReader(data[1])
 
-	err = storage.InitFromReader(reader, records)
+	err = storage.InitFromReader(t.Context(), reader, records)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -60,7 +69,7 @@ func TestInitFromReader(t *testing.T) {
 
 	reader = strings.NewReader(data[2])
 
-	err = storage.InitFromReader(reader, records)
+	err = storage.InitFromReader(t.Context(), reader, records)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -82,7 +91,7 @@ func TestSaveToWriter(t *testing.T) {
 
 	var buf bytes.Buffer
 
-	err := saveToWriter(&buf, records)
+	err := saveToWriter(t.Context(), &buf, records)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -95,13 +104,1286 @@ func TestSaveToWriter(t *testing.T) {
 	records = map[uint64]userrecord.Record{}
 	buf = bytes.Buffer{}
 
-	err = saveToWriter(&buf, records)
+	err = saveToWriter(t.Context(), &buf, records)
 	if err != nil {
 		t.Fatalf("unexpected error for empty records: %v", err)
 	}
 
 	content = buf.String()
-	if content != "" {
-		t.Errorf("expected empty content for empty records, got: %s", content)
+	if content != snapshotOf() {
+		t.Errorf("expected only header and footer for empty records, got: %s", content)
 	}
 }
+
+func TestSnapshotFormat(t *testing.T) {
+	t.Parallel()
+
+	intact := snapshotOf(`{"id":1}`, `{"id":2}`)
+	lines := strings.SplitAfter(intact, "\n")
+
+	tests := []struct {
+		name      string
+		input     string
+		wantErr   error
+		wantIDs   []uint64
+		corrupted []CorruptEntry
+	}{
+		{"intact", intact, nil, []uint64{1, 2}, nil},
+		{
+			"checksum mismatch", strings.Replace(intact, `{"id":2}`, `{"id":9}`, 1), nil, []uint64{1},
+			[]CorruptEntry{{Line: 3, Reason: "checksum mismatch"}},
+		},
+		{
+			"malformed line", lines[0] + `{"id":1}` + "\n" + lines[2] + "#end 2\n", nil, []uint64{2},
+			[]CorruptEntry{{Line: 2, Reason: "malformed line"}},
+		},
+		{
+			"truncated", lines[0] + lines[1] + lines[2][:10], nil, []uint64{1},
+			[]CorruptEntry{
+				{Line: 3, Reason: "checksum mismatch"},
+				{Line: 4, Reason: "missing footer, the snapshot may be truncated"},
+			},
+		},
+		{
+			"count mismatch", lines[0] + lines[1] + "#end 2\n", nil, []uint64{1},
+			[]CorruptEntry{{Line: 3, Reason: "footer counts 2 records, found 1"}},
+		},
+		{
+			"data after footer", intact + lines[1], nil, []uint64{1, 2},
+			[]CorruptEntry{{Line: 5, Reason: "data after the footer"}},
+		},
+		{"legacy", `{"id":1}` + "\n" + `{"id":2}` + "\n", nil, []uint64{1, 2}, nil},
+		{"unsupported version", "#records-snapshot 2\n#end 0\n", errSnapshotVersion, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			records := make(map[uint64]userrecord.Record)
+
+			corrupted, err := loadFromReader(t.Context(), strings.NewReader(tt.input), records)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			if !reflect.DeepEqual(corrupted, tt.corrupted) {
+				t.Errorf("expected corrupt entries %+v, got %+v", tt.corrupted, corrupted)
+			}
+
+			ids := slices.Sorted(maps.Keys(records))
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("expected ids %v, got %v", tt.wantIDs, ids)
+			}
+		})
+	}
+}
+
+func TestStrictInit(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "data.txt")
+
+	content := strings.Replace(snapshotOf(`{"id":1}`, `{"id":2}`), `{"id":2}`, `{"id":9}`, 1)
+
+	err := os.WriteFile(filename, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	storage := NewFileStorage(filename, WithBackups(0))
+	records := make(map[uint64]userrecord.Record)
+
+	err = storage.Init(t.Context(), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []CorruptEntry{{File: filename, Line: 3, Reason: "checksum mismatch"}}
+	if got := storage.Report().Corrupted; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected corrupt entries %+v, got %+v", want, got)
+	}
+
+	if len(records) != 1 {
+		t.Errorf("expected the intact record to be loaded, got %v", records)
+	}
+
+	err = NewFileStorage(filename, WithBackups(0), WithStrict()).Init(t.Context(), make(map[uint64]userrecord.Record))
+	if !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("expected ErrCorruptSnapshot in strict mode, got %v", err)
+	}
+}
+
+func TestReplayFromReader(t *testing.T) {
+	t.Parallel()
+
+	records := map[uint64]userrecord.Record{
+		1: {"id": uint64(1), "Name": "Alice"},
+		2: {"id": uint64(2), "Name": "Bob"},
+	}
+
+	walData := strings.Join([]string{
+		`{"op":"add","id":3,"record":{"id":3,"Name":"Carol"}}`,
+		`{"op":"update","id":1,"record":{"id":1,"Name":"Alicia"}}`,
+		`{"op":"delete","id":2}`,
+		`{"op":"rename","id":1}`,
+		`{"op":"add","id":4,"rec`,
+	}, "\n")
+
+	maxID, err := replayFromReader(t.Context(), strings.NewReader(walData), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if maxID != 3 {
+		t.Errorf("expected highest id 3, got %d", maxID)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d: %v", len(records), records)
+	}
+
+	if records[1]["Name"] != "Alicia" {
+		t.Errorf("expected updated record, got %v", records[1])
+	}
+
+	if records[3]["Name"] != "Carol" {
+		t.Errorf("expected added record, got %v", records[3])
+	}
+}
+
+func TestReplayBatch(t *testing.T) {
+	t.Parallel()
+
+	records := map[uint64]userrecord.Record{
+		1: {"id": uint64(1), "Name": "Alice"},
+	}
+
+	walData := strings.Join([]string{
+		`{"op":"batch","id":0,"entries":[{"op":"add","id":5,"record":{"id":5}},{"op":"delete","id":1}]}`,
+		`{"op":"batch","id":0,"entries":[{"op":"delete","id":5},{"op":"add","id":6,"record":{"Name":"x"}}]}`,
+	}, "\n")
+
+	maxID, err := replayFromReader(t.Context(), strings.NewReader(walData), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if maxID != 5 {
+		t.Errorf("expected highest id 5, got %d", maxID)
+	}
+
+	// The second batch holds an invalid entry, so none of its entries may be applied.
+	if len(records) != 1 || records[5] == nil {
+		t.Fatalf("expected only record 5, got %v", records)
+	}
+}
+
+func TestFileStorageWAL(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "data.txt")
+
+	err := os.WriteFile(filename, []byte(`{"id":1,"Name":"Alice"}`+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	storage := NewFileStorage(filename)
+	records := make(map[uint64]userrecord.Record)
+
+	err = storage.Init(t.Context(), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries := []Entry{
+		{Op: OpAdd, ID: 2, Record: userrecord.Record{"id": uint64(2), "Name": "Bob"}},
+		{Op: OpDelete, ID: 1},
+	}
+
+	for _, entry := range entries {
+		err = storage.Append(t.Context(), entry)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	err = storage.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Simulate a crash: the snapshot was never rewritten, only the log survived.
+	recovered := make(map[uint64]userrecord.Record)
+
+	err = NewFileStorage(filename).Init(t.Context(), recovered)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(recovered) != 1 || recovered[2]["Name"] != "Bob" {
+		t.Fatalf("expected only Bob after replay, got %v", recovered)
+	}
+
+	err = storage.Save(t.Context(), recovered)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(filename + walSuffix)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.Size() != 0 {
+		t.Errorf("expected log to be truncated after save, got %d bytes", info.Size())
+	}
+}
+
+func TestSaveRotatesSnapshots(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "data.txt")
+	storage := NewFileStorage(filename, WithBackups(2))
+
+	for i := range uint64(4) {
+		records := map[uint64]userrecord.Record{i: {"id": i}}
+
+		err := storage.Save(t.Context(), records)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := map[string]string{
+		filename:                snapshotOf(`{"id":3}`),
+		backupName(filename, 1): snapshotOf(`{"id":2}`),
+		backupName(filename, 2): snapshotOf(`{"id":1}`),
+		backupName(filename, 3): "",
+	}
+
+	for name, content := range expected {
+		data, err := os.ReadFile(name)
+		if content == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("expected %q to not exist, got %v", name, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if string(data) != content {
+			t.Errorf("expected %q to contain %s, got %s", name, content, data)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("expected no temporary files left, found %q", entry.Name())
+		}
+	}
+}
+
+// expiringContext is canceled once Err has been called n times.
+type expiringContext struct {
+	context.Context
+	n int
+}
+
+func (c *expiringContext) Err() error {
+	c.n--
+	if c.n < 0 {
+		return context.Canceled
+	}
+
+	return nil
+}
+
+func TestFailedSaveKeepsBackups(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "data.txt")
+	storage := NewFileStorage(filename, WithBackups(2))
+
+	for id := range uint64(2) {
+		err := storage.Save(t.Context(), map[uint64]userrecord.Record{id: {"id": id}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	records := make(map[uint64]userrecord.Record)
+	for id := uint64(10); id < 20; id++ {
+		records[id] = userrecord.Record{"id": id}
+	}
+
+	// The save is canceled while the records are being written.
+	err := storage.Save(&expiringContext{Context: t.Context(), n: 3}, records)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	expected := map[string]string{
+		filename:                  snapshotOf(`{"id":1}`),
+		backupName(filename, 1):   snapshotOf(`{"id":0}`),
+		backupName(filename, 2):   "",
+		filename + previousSuffix: "",
+	}
+
+	for name, content := range expected {
+		data, err := os.ReadFile(name)
+		if content == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("expected %q to not exist, got %v", name, err)
+			}
+
+			continue
+		}
+
+		if string(data) != content {
+			t.Errorf("expected %q to contain %s, got %s, %v", name, content, data, err)
+		}
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "data.txt")
+
+	err := os.WriteFile(filename, []byte(`{"id":1}`+"\n"), 0o644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	storage := NewFileStorage(filename, WithBackups(1))
+
+	err = storage.Init(ctx, make(map[uint64]userrecord.Record))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled from Init, got %v", err)
+	}
+
+	err = storage.Save(ctx, map[uint64]userrecord.Record{2: {"id": uint64(2)}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled from Save, got %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.TrimSpace(string(data)) != `{"id":1}` {
+		t.Errorf("expected snapshot to be left as it was, got %s", data)
+	}
+
+	if _, err = os.Stat(backupName(filename, 1)); !os.IsNotExist(err) {
+		t.Errorf("expected no backup to be rotated, got %v", err)
+	}
+
+	err = saveToWriter(ctx, &bytes.Buffer{}, map[uint64]userrecord.Record{2: {"id": uint64(2)}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from saveToWriter, got %v", err)
+	}
+}
+
+func TestInitFallsBackToBackup(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		primary   string
+		backup    string
+		log       string
+		wantErr   bool
+		wantIDs   []uint64
+		corrupted int
+	}{
+		{"intact primary", `{"id":1}` + "\n", `{"id":2}` + "\n", "", false, []uint64{1}, 0},
+		{"missing primary", "", `{"id":2}` + "\n", "", false, []uint64{2}, 0},
+		{"corrupt primary", `{"id":1}` + "\n" + `{"id":3,"Na` + "\n", `{"id":2}` + "\n", "", false, []uint64{1}, 1},
+		// The log holds the changes made since the primary was written, which the backup lacks.
+		{
+			"corrupt primary with log", `{"id":1}` + "\n" + `{"i` + "\n", `{"id":2}` + "\n",
+			`{"op":"add","id":4,"record":{"id":4}}` + "\n", false, []uint64{1, 4}, 1,
+		},
+		{"corrupt backup", "", `{"id":2}` + "\n" + `{"i` + "\n", "", false, []uint64{2}, 1},
+		{"nothing to load", "", "", "", true, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			filename := filepath.Join(t.TempDir(), "data.txt")
+			files := map[string]string{
+				filename:                tt.primary,
+				backupName(filename, 1): tt.backup,
+				filename + walSuffix:    tt.log,
+			}
+
+			for name, content := range files {
+				if content == "" {
+					continue
+				}
+
+				err := os.WriteFile(name, []byte(content), 0o600)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			storage := NewFileStorage(filename)
+			records := make(map[uint64]userrecord.Record)
+
+			err := storage.Init(t.Context(), records)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			if !slices.Equal(slices.Sorted(maps.Keys(records)), tt.wantIDs) {
+				t.Errorf("expected records %v, got %v", tt.wantIDs, records)
+			}
+
+			if got := len(storage.Report().Corrupted); got != tt.corrupted {
+				t.Errorf("expected %d corrupt entries, got %+v", tt.corrupted, storage.Report().Corrupted)
+			}
+		})
+	}
+}
+
+func TestInitUnreadableSnapshot(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "data.txt")
+
+	// A directory opens like a file, but reading it fails.
+	err := os.Mkdir(filename, 0o700)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = os.WriteFile(backupName(filename, 1), []byte(snapshotOf(`{"id":1}`)), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records := make(map[uint64]userrecord.Record)
+
+	err = NewFileStorage(filename).Init(t.Context(), records)
+	if !errors.Is(err, errScanFile) {
+		t.Errorf("expected errScanFile, got %v", err)
+	}
+
+	if len(records) != 0 {
+		t.Errorf("expected no records to be loaded from the backup, got %v", records)
+	}
+}
+
+func TestInitLineTooLong(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		file func(filename string) string
+	}{
+		{"snapshot", func(filename string) string { return filename }},
+		{"log", func(filename string) string { return filename + walSuffix }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			filename := filepath.Join(t.TempDir(), "data.txt")
+
+			err := os.WriteFile(filename, []byte(snapshotOf(`{"id":1}`)), 0o600)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// A damaged file without line breaks.
+			err = os.WriteFile(tt.file(filename), bytes.Repeat([]byte("x"), MaxLine+1), 0o600)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = NewFileStorage(filename).Init(t.Context(), make(map[uint64]userrecord.Record))
+			if !errors.Is(err, errLineTooLong) {
+				t.Errorf("expected errLineTooLong, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSequence(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "data.txt")
+
+	err := os.WriteFile(filename, []byte(`{"id":2}`+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	storage := NewFileStorage(filename)
+	records := make(map[uint64]userrecord.Record)
+
+	err = storage.Init(t.Context(), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if seq := storage.Sequence(); seq != 2 {
+		t.Fatalf("expected sequence 2 from snapshot, got %d", seq)
+	}
+
+	err = storage.Append(t.Context(), Entry{Op: OpAdd, ID: 5, Record: userrecord.Record{"id": uint64(5)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = storage.Append(t.Context(), Entry{Op: OpDelete, ID: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The log alone must be enough to restore the sequence after a crash.
+	restarted := NewFileStorage(filename)
+
+	err = restarted.Init(t.Context(), make(map[uint64]userrecord.Record))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if seq := restarted.Sequence(); seq != 5 {
+		t.Fatalf("expected sequence 5 from log, got %d", seq)
+	}
+
+	// Once the log is truncated, the sequence file keeps the deleted id.
+	err = storage.Save(t.Context(), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = storage.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restarted = NewFileStorage(filename)
+
+	err = restarted.Init(t.Context(), make(map[uint64]userrecord.Record))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if seq := restarted.Sequence(); seq != 5 {
+		t.Fatalf("expected sequence 5 from sequence file, got %d", seq)
+	}
+
+	err = os.WriteFile(filename+seqSuffix, []byte("five"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = NewFileStorage(filename).Init(t.Context(), make(map[uint64]userrecord.Record))
+	if err == nil {
+		t.Fatal("expected error for corrupt sequence file, got nil")
+	}
+}
+
+//nolint:paralleltest // SetSchema changes package state shared with other tests.
+func TestLoadReport(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data.txt")
+
+	err := os.WriteFile(filename, []byte(`{"id":1,"name":"a"}`+"\n"+`{"id":2}`+"\n"+`{"id":3,"name":7}`+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	schema, err := userrecord.ParseSchema([]byte(`{"required":["name"],"properties":{"name":{"type":"string"}}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	userrecord.SetSchema(schema)
+	defer userrecord.SetSchema(nil)
+
+	storage := NewFileStorage(filename)
+	records := make(map[uint64]userrecord.Record)
+
+	err = storage.Init(t.Context(), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected records failing the schema to be kept, got %d records", len(records))
+	}
+
+	want := LoadReport{
+		Loaded: 3,
+		Invalid: []InvalidRecord{
+			{ID: 2, Violations: []userrecord.Violation{{Path: "/name", Message: "is required"}}},
+			{ID: 3, Violations: []userrecord.Violation{{Path: "/name", Message: "must be of type string"}}},
+		},
+	}
+
+	if got := storage.Report(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected report %+v, got %+v", want, got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "data.txt")
+	line := `{"id":1,"Name":"Alice"}` + "\n"
+
+	err := os.WriteFile(filename, []byte(line), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reg := metrics.NewRegistry()
+	storage := NewFileStorage(filename, WithMetrics(reg))
+	records := make(map[uint64]userrecord.Record)
+
+	err = storage.Init(t.Context(), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = storage.Save(t.Context(), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Saving into a missing directory fails.
+	err = NewFileStorage(filepath.Join(dir, "missing", "data.txt"), WithMetrics(reg)).Save(t.Context(), records)
+	if err == nil {
+		t.Fatal("expected error saving into a missing directory")
+	}
+
+	var out strings.Builder
+
+	_, err = reg.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The legacy snapshot is rewritten in the checksummed format.
+	loadedSize := strconv.Itoa(len(line))
+	savedSize := strconv.Itoa(len(snapshotOf(`{"Name":"Alice","id":1}`)))
+
+	for _, expected := range []string{
+		`storage_operation_bytes_total{op="init"} ` + loadedSize + "\n",
+		`storage_operation_bytes_total{op="save"} ` + savedSize + "\n",
+		`storage_operation_duration_seconds_count{op="init"} 1` + "\n",
+		`storage_operation_duration_seconds_count{op="save"} 2` + "\n",
+		`storage_operation_errors_total{op="save"} 1` + "\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestLogStorageSegments(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	segments := func() []string {
+		t.Helper()
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var names []string
+
+		for _, entry := range entries {
+			if entry.Name() != sequenceFile {
+				names = append(names, entry.Name())
+			}
+		}
+
+		return names
+	}
+
+	// Every entry is larger than a byte, so each one starts a new segment.
+	storage := NewLogStorage(dir, WithSegmentSize(1))
+	records := make(map[uint64]userrecord.Record)
+
+	err := storage.Init(t.Context(), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for id := uint64(1); id <= 3; id++ {
+		err = storage.Append(t.Context(), Entry{Op: OpAdd, ID: id, Record: userrecord.Record{"id": id}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{"00000000000000000001.log", "00000000000000000002.log", "00000000000000000003.log"}
+	if got := segments(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected segments %v, got %v", expected, got)
+	}
+
+	err = storage.Save(t.Context(), map[uint64]userrecord.Record{2: {"id": uint64(2)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = storage.Append(t.Context(), Entry{Op: OpDelete, ID: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected = []string{"00000000000000000004.snap", "00000000000000000005.log"}
+	if got := segments(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected compacted segments %v, got %v", expected, got)
+	}
+
+	// A segment left over from an interrupted save is older than the compacted one.
+	err = os.WriteFile(filepath.Join(dir, "00000000000000000001.log"), []byte(`{"op":"add","id":1,"record":{"id":1}}`+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reopened := NewLogStorage(dir)
+	recovered := make(map[uint64]userrecord.Record)
+
+	err = reopened.Init(t.Context(), recovered)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(recovered) != 0 {
+		t.Errorf("expected no records, got %v", recovered)
+	}
+
+	if reopened.next != 6 {
+		t.Errorf("expected the next segment to be 6, got %d", reopened.next)
+	}
+}
+
+func TestLSMStorage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// Every entry is larger than a byte, so each one is flushed to a table,
+	// and the tables are compacted once there are more than maxTables.
+	storage := NewLSMStorage(dir, WithMemtableSize(1))
+
+	err := storage.Open(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for id := uint64(1); id <= 200; id++ {
+		err = storage.Append(t.Context(), Entry{Op: OpAdd, ID: id, Record: userrecord.Record{"id": id}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	err = storage.Append(t.Context(), Entry{Op: OpBatch, Entries: []Entry{
+		{Op: OpDelete, ID: 70},
+		{Op: OpDelete, ID: 200},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Flushing waits for the flushes started in the background.
+	err = storage.Flush(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	storage.mu.RLock()
+	tables := len(storage.tables)
+	storage.mu.RUnlock()
+
+	if tables > maxTables {
+		t.Errorf("expected at most %d tables, got %d", maxTables, tables)
+	}
+
+	err = storage.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A table written by a flush interrupted before the manifest was updated.
+	err = os.WriteFile(tableName(dir, 1000), []byte("leftover"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reopened := NewLSMStorage(dir)
+
+	err = reopened.Open(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defer reopened.Close()
+
+	if _, err = os.Stat(tableName(dir, 1000)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the leftover table to be removed, got %v", err)
+	}
+
+	if got := reopened.Sequence(); got != 200 {
+		t.Errorf("expected sequence 200, got %d", got)
+	}
+
+	for _, id := range []uint64{1, 64, 65, 129, 199} {
+		rec, err := reopened.Get(t.Context(), id)
+		if err != nil {
+			t.Fatalf("expected record %d, got error %v", id, err)
+		}
+
+		if got, _ := rec.ID(); got != id {
+			t.Errorf("expected record %d, got %v", id, rec)
+		}
+	}
+
+	for _, id := range []uint64{0, 70, 200, 201} {
+		_, err = reopened.Get(t.Context(), id)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for record %d, got %v", id, err)
+		}
+	}
+
+	var ids []uint64
+
+	err = reopened.Scan(t.Context(), func(id uint64, _ userrecord.Record) error {
+		ids = append(ids, id)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ids) != 198 || !slices.IsSorted(ids) || slices.Contains(ids, 70) {
+		t.Errorf("expected records 1 to 199 without 70 in order, got %v", ids)
+	}
+}
+
+func TestLSMStorageFlushesInBackground(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	storage := NewLSMStorage(dir, WithMemtableSize(1))
+
+	err := storage.Open(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Appends and reads go on while a flush cannot finish.
+	storage.saveMu.Lock()
+
+	for id := uint64(1); id <= 3; id++ {
+		appendEntries(t, storage, Entry{Op: OpAdd, ID: id, Record: userrecord.Record{"id": id}})
+
+		_, err = storage.Get(t.Context(), id)
+		if err != nil {
+			t.Fatalf("expected record %d, got error %v", id, err)
+		}
+	}
+
+	storage.mu.RLock()
+	tables := len(storage.tables)
+	storage.mu.RUnlock()
+
+	storage.saveMu.Unlock()
+
+	if tables != 0 {
+		t.Errorf("expected no tables while the flush is held up, got %d", tables)
+	}
+
+	err = storage.Flush(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = storage.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if size := fileSize(filepath.Join(dir, memtableLog)) + fileSize(filepath.Join(dir, frozenLog)); size != 0 {
+		t.Errorf("expected the logs to be empty after the flush, got %d bytes", size)
+	}
+
+	reopened := NewLSMStorage(dir)
+	loaded := initRecords(t, reopened)
+
+	defer reopened.Close()
+
+	if !slices.Equal(slices.Sorted(maps.Keys(loaded)), []uint64{1, 2, 3}) {
+		t.Errorf("expected records 1 to 3, got %v", loaded)
+	}
+}
+
+func TestDirStoragePendingBatch(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// Simulate a crash after the batch was written but before its records were.
+	err := os.WriteFile(filepath.Join(dir, "1.json"), []byte(`{"id":1,"Name":"Alice"}`+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	batch := `{"op":"batch","id":0,"entries":[{"op":"add","id":2,"record":{"id":2,"Name":"Bob"}},{"op":"delete","id":1}]}`
+
+	err = os.WriteFile(filepath.Join(dir, pendingFile), []byte(batch+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	storage := NewDirStorage(dir)
+	records := make(map[uint64]userrecord.Record)
+
+	err = storage.Init(t.Context(), records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(records) != 1 || records[2]["Name"] != "Bob" {
+		t.Fatalf("expected only Bob after completing the batch, got %v", records)
+	}
+
+	if storage.Sequence() != 2 {
+		t.Errorf("expected sequence 2, got %d", storage.Sequence())
+	}
+
+	_, err = os.Stat(filepath.Join(dir, pendingFile))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected pending batch to be removed, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range Backends() {
+		store, err := New(backend, t.TempDir())
+		if err != nil || store == nil {
+			t.Errorf("expected a %s store, got %v", backend, err)
+		}
+	}
+
+	_, err := New("tape", t.TempDir())
+	if !errors.Is(err, ErrUnknownBackend) {
+		t.Errorf("expected ErrUnknownBackend, got %v", err)
+	}
+
+	err = Create("tape", t.TempDir())
+	if !errors.Is(err, ErrUnknownBackend) {
+		t.Errorf("expected ErrUnknownBackend from Create, got %v", err)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range Backends() {
+		t.Run(string(backend), func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			err := Create(backend, dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			store, err := New(backend, dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			checkpointer, ok := store.(Checkpointer)
+			if !ok {
+				t.Skip("backend cannot take checkpoints")
+			}
+
+			initRecords(t, store)
+			appendEntries(t, store, Entry{Op: OpAdd, ID: 1, Record: userrecord.Record{"id": uint64(1)}})
+
+			checkpoint, err := checkpointer.Checkpoint(t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Appended while the records up to the checkpoint are being saved.
+			appendEntries(t, store, Entry{Op: OpAdd, ID: 2, Record: userrecord.Record{"id": uint64(2)}})
+
+			err = checkpointer.SaveCheckpoint(t.Context(), map[uint64]userrecord.Record{1: {"id": uint64(1)}}, checkpoint)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			appendEntries(t, store, Entry{Op: OpDelete, ID: 1})
+
+			err = store.Close()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			reopened, _ := New(backend, dir)
+			loaded := initRecords(t, reopened)
+
+			defer reopened.Close()
+
+			if !slices.Equal(slices.Sorted(maps.Keys(loaded)), []uint64{2}) {
+				t.Errorf("expected record 2, got %v", loaded)
+			}
+		})
+	}
+}
+
+func TestTornLogTail(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		backend Backend
+		log     func(dir string) string
+	}{
+		{BackendFile, func(dir string) string { return SnapshotFile(dir) + walSuffix }},
+		{BackendLog, func(dir string) string { return segmentName(filepath.Join(dir, segmentsDir), 1, segmentSuffix) }},
+		{BackendLSM, func(dir string) string { return filepath.Join(dir, tablesDir, memtableLog) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.backend), func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			err := Create(tt.backend, dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			store, _ := New(tt.backend, dir)
+			initRecords(t, store)
+			appendEntries(t, store, Entry{Op: OpAdd, ID: 1, Record: userrecord.Record{"id": uint64(1)}})
+
+			err = store.Close()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// A crash cut the last append short.
+			file, err := os.OpenFile(tt.log(dir), os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = file.WriteString(`{"op":"add","id":2,"rec`)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_ = file.Close()
+
+			restarted, _ := New(tt.backend, dir)
+			initRecords(t, restarted)
+			appendEntries(t, restarted, Entry{Op: OpAdd, ID: 3, Record: userrecord.Record{"id": uint64(3)}})
+
+			err = restarted.Close()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			reopened, _ := New(tt.backend, dir)
+			loaded := initRecords(t, reopened)
+
+			defer reopened.Close()
+
+			if !slices.Equal(slices.Sorted(maps.Keys(loaded)), []uint64{1, 3}) {
+				t.Errorf("expected records 1 and 3, got %v", loaded)
+			}
+		})
+	}
+}
+
+func TestCorruptLogEntries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		backend Backend
+		log     func(dir string) string
+	}{
+		{BackendFile, func(dir string) string { return SnapshotFile(dir) + walSuffix }},
+		{BackendLog, func(dir string) string { return segmentName(filepath.Join(dir, segmentsDir), 1, segmentSuffix) }},
+		{BackendLSM, func(dir string) string { return filepath.Join(dir, tablesDir, memtableLog) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.backend), func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			err := Create(tt.backend, dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			store, _ := New(tt.backend, dir)
+			initRecords(t, store)
+			appendEntries(t, store,
+				Entry{Op: OpAdd, ID: 1, Record: userrecord.Record{"id": uint64(1)}},
+				Entry{Op: OpAdd, ID: 2, Record: userrecord.Record{"id": uint64(2)}},
+			)
+
+			err = store.Close()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The second entry was damaged on disk, and a third one does not decode.
+			data, err := os.ReadFile(tt.log(dir))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			data = bytes.Replace(data, []byte(`"id":2`), []byte(`"id":7`), 1)
+			data = append(data, encodeLine([]byte("not an entry"))...)
+
+			err = os.WriteFile(tt.log(dir), data, 0o600)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			reopened, _ := New(tt.backend, dir)
+			loaded := initRecords(t, reopened)
+
+			defer reopened.Close()
+
+			if !slices.Equal(slices.Sorted(maps.Keys(loaded)), []uint64{1}) {
+				t.Errorf("expected the intact record 1, got %v", loaded)
+			}
+
+			want := []CorruptEntry{
+				{File: tt.log(dir), Line: 2, Reason: "checksum mismatch"},
+				{File: tt.log(dir), Line: 3, Reason: "undecodable entry"},
+			}
+			if got := reopened.Report().Corrupted; !reflect.DeepEqual(got, want) {
+				t.Errorf("expected corrupt entries %+v, got %+v", want, got)
+			}
+
+			strict, _ := New(tt.backend, dir, WithStrict())
+
+			defer strict.Close()
+
+			err = strict.Init(t.Context(), make(map[uint64]userrecord.Record))
+			if !errors.Is(err, ErrCorruptSnapshot) {
+				t.Errorf("expected ErrCorruptSnapshot in strict mode, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+
+	records := map[uint64]userrecord.Record{1: {"id": uint64(1)}, 3: {"id": uint64(3)}}
+
+	for _, backend := range Backends() {
+		if backend == BackendMemory {
+			continue
+		}
+
+		t.Run(string(backend), func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			err := Create(backend, dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			dst, err := New(backend, dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = dst.Init(t.Context(), make(map[uint64]userrecord.Record))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Record 5 was deleted from the store the records come from.
+			err = Convert(t.Context(), dst, maps.Clone(records), 5)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_ = dst.Close()
+
+			reopened, _ := New(backend, dir)
+			loaded := make(map[uint64]userrecord.Record)
+
+			err = reopened.Init(t.Context(), loaded)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			defer reopened.Close()
+
+			if !slices.Equal(slices.Sorted(maps.Keys(loaded)), []uint64{1, 3}) {
+				t.Errorf("expected records 1 and 3, got %v", loaded)
+			}
+
+			if seq := reopened.Sequence(); seq != 5 {
+				t.Errorf("expected the sequence to be carried over as 5, got %d", seq)
+			}
+		})
+	}
+}
+
+// snapshotOf returns a checksummed snapshot holding the JSON lines.
+func snapshotOf(lines ...string) string {
+	var b strings.Builder
+
+	b.WriteString("#records-snapshot 1\n")
+
+	for _, line := range lines {
+		b.Write(encodeLine([]byte(line)))
+	}
+
+	fmt.Fprintf(&b, "#end %d\n", len(lines))
+
+	return b.String()
+}
@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// tableSuffix ends the names of the tables of an LSMStorage.
+	tableSuffix = ".sst"
+	// manifestFile names the file listing the tables an LSMStorage reads.
+	manifestFile = "manifest"
+	// blockEntries is the number of entries between two keys of a table's sparse index.
+	blockEntries = 64
+	// footerSize is the size of a table's footer: the offset of its index as
+	// 16 hexadecimal digits and a newline.
+	footerSize = 17
+)
+
+var errCorruptTable = errors.New("table is corrupt")
+
+// table is an immutable file of entries sorted by record id. OpAdd entries
+// hold records and OpDelete entries are tombstones that hide the record in
+// older tables. The entries are followed by a sparse index, holding the id
+// and offset of every blockEntries-th entry, and a footer locating the index.
+// Only the index is kept in memory; entries are read from the file when needed.
+type table struct {
+	num   uint64
+	name  string
+	file  *os.File
+	index tableIndex
+	// end is the offset of the index, where the entries end.
+	end int64
+}
+
+// tableIndex is the sparse index of a table.
+type tableIndex struct {
+	Count  int     `json:"count"`
+	Min    uint64  `json:"min"`
+	Max    uint64  `json:"max"`
+	Blocks []block `json:"blocks"`
+}
+
+// block is a run of entries of a table, located by the id of its first entry.
+type block struct {
+	ID     uint64 `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+// tableWriter writes the entries of a table and builds its index.
+type tableWriter struct {
+	buf    *bufio.Writer
+	offset int64
+	index  tableIndex
+}
+
+// cursor reads entries in ascending id order.
+type cursor interface {
+	// next returns the next entry, or false once there are none left.
+	next() (Entry, bool, error)
+}
+
+// entryCursor reads entries from a slice.
+type entryCursor struct {
+	entries []Entry
+}
+
+// tableCursor reads the entries of a table from its file.
+type tableCursor struct {
+	name    string
+	scanner *bufio.Scanner
+}
+
+// writeTable atomically writes a table with the entries passed by fill to add,
+// which must pass them in ascending id order.
+func writeTable(ctx context.Context, name string, fill func(add func(Entry) error) error) error {
+	return writeAtomic(ctx, name, func(file *os.File) error {
+		w := &tableWriter{buf: bufio.NewWriter(file)}
+
+		err := fill(w.add)
+		if err != nil {
+			return err
+		}
+
+		err = w.finish()
+		if err != nil {
+			return fmt.Errorf("writing %q: %w", file.Name(), err)
+		}
+
+		err = file.Sync()
+		if err != nil {
+			return fmt.Errorf("syncing %q: %w", file.Name(), errSyncFile)
+		}
+
+		return nil
+	})
+}
+
+// add writes entry after the previous one, starting a new block if the current one is full.
+func (w *tableWriter) add(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshaling entry of record with id %d: %w", entry.ID, err)
+	}
+
+	if w.index.Count%blockEntries == 0 {
+		w.index.Blocks = append(w.index.Blocks, block{ID: entry.ID, Offset: w.offset})
+	}
+
+	if w.index.Count == 0 {
+		w.index.Min = entry.ID
+	}
+
+	w.index.Max = entry.ID
+	w.index.Count++
+
+	n, err := w.buf.Write(append(data, '\n'))
+	w.offset += int64(n)
+
+	if err != nil {
+		return fmt.Errorf("writing entry of record with id %d: %w", entry.ID, ErrWriteRecords)
+	}
+
+	return nil
+}
+
+// finish writes the index and the footer and flushes the buffer.
+func (w *tableWriter) finish() error {
+	data, err := json.Marshal(w.index)
+	if err != nil {
+		return fmt.Errorf("marshaling index: %w", err)
+	}
+
+	_, err = w.buf.Write(append(data, '\n'))
+	if err != nil {
+		return ErrWriteRecords
+	}
+
+	_, err = fmt.Fprintf(w.buf, "%016x\n", w.offset)
+	if err != nil {
+		return ErrWriteRecords
+	}
+
+	err = w.buf.Flush()
+	if err != nil {
+		return ErrWriteRecords
+	}
+
+	return nil
+}
+
+// openTable opens the table numbered num in dir and reads its index.
+func openTable(dir string, num uint64) (*table, error) {
+	name := tableName(dir, num)
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening table %q: %w", name, errOpenFile)
+	}
+
+	t := &table{num: num, name: name, file: file}
+
+	err = t.readIndex()
+	if err != nil {
+		_ = file.Close()
+
+		return nil, fmt.Errorf("reading table %q: %w", name, err)
+	}
+
+	return t, nil
+}
+
+// readIndex locates the index through the footer and decodes it.
+func (t *table) readIndex() error {
+	info, err := t.file.Stat()
+	if err != nil {
+		return errOpenFile
+	}
+
+	size := info.Size()
+	if size < footerSize {
+		return errCorruptTable
+	}
+
+	footer := make([]byte, footerSize)
+
+	_, err = t.file.ReadAt(footer, size-footerSize)
+	if err != nil {
+		return errOpenFile
+	}
+
+	end, err := strconv.ParseInt(strings.TrimSpace(string(footer)), 16, 64)
+	if err != nil || end < 0 || end > size-footerSize {
+		return errCorruptTable
+	}
+
+	data := make([]byte, size-footerSize-end)
+
+	_, err = t.file.ReadAt(data, end)
+	if err != nil {
+		return errOpenFile
+	}
+
+	err = json.Unmarshal(data, &t.index)
+	if err != nil {
+		return errCorruptTable
+	}
+
+	t.end = end
+
+	return nil
+}
+
+// get returns the entry of the record with id, or false if the table has none.
+// Only the block that may hold the entry is read.
+func (t *table) get(id uint64) (Entry, bool, error) {
+	if t.index.Count == 0 || id < t.index.Min || id > t.index.Max {
+		return Entry{}, false, nil
+	}
+
+	pos, found := slices.BinarySearchFunc(t.index.Blocks, id, func(b block, id uint64) int {
+		return cmp.Compare(b.ID, id)
+	})
+	if !found {
+		pos--
+	}
+
+	if pos < 0 {
+		return Entry{}, false, nil
+	}
+
+	start, end := t.index.Blocks[pos].Offset, t.end
+	if pos+1 < len(t.index.Blocks) {
+		end = t.index.Blocks[pos+1].Offset
+	}
+
+	c := t.cursor(start, end)
+
+	for {
+		entry, ok, err := c.next()
+		if err != nil || !ok || entry.ID > id {
+			return Entry{}, false, err
+		}
+
+		if entry.ID == id {
+			return entry, true, nil
+		}
+	}
+}
+
+// entries returns a cursor over all entries of the table.
+func (t *table) entries() cursor {
+	return t.cursor(0, t.end)
+}
+
+// cursor returns a cursor over the entries between the offsets start and end.
+func (t *table) cursor(start, end int64) *tableCursor {
+	return &tableCursor{
+		name:    t.name,
+		scanner: newLineScanner(io.NewSectionReader(t.file, start, end-start)),
+	}
+}
+
+// close closes the table's file.
+func (t *table) close() error {
+	err := t.file.Close()
+	if err != nil {
+		return fmt.Errorf("closing table %q: %w", t.name, err)
+	}
+
+	return nil
+}
+
+func (c *tableCursor) next() (Entry, bool, error) {
+	if !c.scanner.Scan() {
+		err := c.scanner.Err()
+		if err != nil {
+			return Entry{}, false, fmt.Errorf("scanning table %q: %w", c.name, scanError(err))
+		}
+
+		return Entry{}, false, nil
+	}
+
+	var entry Entry
+
+	// Tables are written atomically, so an undecodable entry is real damage.
+	err := json.Unmarshal(c.scanner.Bytes(), &entry)
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("decoding entry of table %q: %w", c.name, errCorruptTable)
+	}
+
+	// Ids are decoded as float64 and turned back into uint64, as in a snapshot.
+	if entry.Op != OpDelete {
+		err = entry.Record.ValidateID()
+		if err != nil {
+			return Entry{}, false, fmt.Errorf("entry %d of table %q: %w", entry.ID, c.name, errCorruptTable)
+		}
+	}
+
+	return entry, true, nil
+}
+
+func (c *entryCursor) next() (Entry, bool, error) {
+	if len(c.entries) == 0 {
+		return Entry{}, false, nil
+	}
+
+	entry := c.entries[0]
+	c.entries = c.entries[1:]
+
+	return entry, true, nil
+}
+
+// merge passes to fn, in ascending id order, the newest entry of every id
+// read by cursors, which are ordered from the newest to the oldest.
+func merge(ctx context.Context, cursors []cursor, fn func(Entry) error) error {
+	heads := make([]*Entry, len(cursors))
+
+	advance := func(i int) error {
+		entry, ok, err := cursors[i].next()
+		if err != nil {
+			return err
+		}
+
+		heads[i] = nil
+		if ok {
+			heads[i] = &entry
+		}
+
+		return nil
+	}
+
+	for i := range cursors {
+		err := advance(i)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return fmt.Errorf("merging tables: %w", err)
+		}
+
+		// The first of several heads with the lowest id is the newest.
+		var lowest *Entry
+
+		for _, head := range heads {
+			if head != nil && (lowest == nil || head.ID < lowest.ID) {
+				lowest = head
+			}
+		}
+
+		if lowest == nil {
+			return nil
+		}
+
+		entry := *lowest
+
+		for i, head := range heads {
+			if head != nil && head.ID == entry.ID {
+				err = advance(i)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = fn(entry)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readManifest returns the numbers of the tables listed in the manifest in dir,
+// from the oldest to the newest. A missing manifest lists no tables.
+func readManifest(dir string) ([]uint64, error) {
+	name := filepath.Join(dir, manifestFile)
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest %q: %w", name, errOpenFile)
+	}
+
+	var nums []uint64
+
+	err = json.Unmarshal(data, &nums)
+	if err != nil {
+		return nil, fmt.Errorf("decoding manifest %q: %w", name, errCorruptTable)
+	}
+
+	return nums, nil
+}
+
+// writeManifest atomically replaces the manifest in dir with the numbers of tables.
+func writeManifest(ctx context.Context, dir string, tables []*table) error {
+	// The manifest lists the tables from the oldest to the newest.
+	nums := make([]uint64, 0, len(tables))
+	for _, t := range slices.Backward(tables) {
+		nums = append(nums, t.num)
+	}
+
+	data, err := json.Marshal(nums)
+	if err != nil {
+		return fmt.Errorf("marshaling manifest: %w", err)
+	}
+
+	return writeAtomic(ctx, filepath.Join(dir, manifestFile), func(file *os.File) error {
+		_, err := file.Write(append(data, '\n'))
+		if err != nil {
+			return fmt.Errorf("writing manifest: %w", ErrWriteRecords)
+		}
+
+		err = file.Sync()
+		if err != nil {
+			return fmt.Errorf("syncing manifest: %w", errSyncFile)
+		}
+
+		return nil
+	})
+}
+
+// listTables returns the numbers of the table files in dir in ascending order.
+// A missing directory has none.
+func listTables(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading directory %q: %w", dir, errOpenFile)
+	}
+
+	var nums []uint64
+
+	for _, entry := range entries {
+		base, ok := strings.CutSuffix(entry.Name(), tableSuffix)
+		if !ok {
+			continue
+		}
+
+		num, err := strconv.ParseUint(base, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		nums = append(nums, num)
+	}
+
+	return nums, nil
+}
+
+// tableName returns the name of the table numbered num.
+func tableName(dir string, num uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", num, tableSuffix))
+}
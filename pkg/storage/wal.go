@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"zabbix-technical-task/pkg/userrecord"
+)
+
+// Sync policies for the write-ahead log.
+const (
+	// SyncAlways flushes the log to stable storage after every entry.
+	SyncAlways SyncPolicy = iota
+	// SyncNever leaves flushing the log to the operating system.
+	SyncNever
+)
+
+// SyncPolicy controls when the write-ahead log is flushed to stable storage.
+type SyncPolicy int
+
+// wal is an append-only log of record changes made since the last snapshot.
+// Every entry is a line prefixed with its checksum, as in a snapshot.
+type wal struct {
+	filename string
+	file     *os.File
+	sync     SyncPolicy
+	// written counts the bytes appended since the log was opened or truncated.
+	written int64
+}
+
+func newWAL(filename string, sync SyncPolicy) *wal {
+	return &wal{
+		filename: filename,
+		sync:     sync,
+	}
+}
+
+// replay applies all entries found in the log file to records and returns
+// the highest record id they refer to and the entries that could not be
+// decoded. A missing log file means there is nothing to replay.
+func (w *wal) replay(ctx context.Context, records map[uint64]userrecord.Record) (uint64, []CorruptEntry, error) {
+	return w.replayInto(ctx, func(entry Entry) error {
+		return applyEntry(records, entry)
+	})
+}
+
+// replayInto passes all entries found in the log file to apply and returns
+// the highest record id of the entries it accepted and the entries that could
+// not be decoded. A torn last entry, left by a crash during an append, is cut
+// off, so that the next append starts a line of its own instead of continuing it.
+func (w *wal) replayInto(ctx context.Context, apply func(Entry) error) (uint64, []CorruptEntry, error) {
+	file, err := os.Open(w.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil, nil
+	}
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("opening log %q: %w", w.filename, errOpenFile)
+	}
+
+	defer func() {
+		closeErr := file.Close()
+		if closeErr != nil {
+			slog.WarnContext(ctx, "failed to close log", "file", w.filename, "err", closeErr)
+		}
+	}()
+
+	scan, err := scanEntries(ctx, file, apply)
+	if err != nil {
+		return 0, nil, fmt.Errorf("replaying log %q: %w", w.filename, err)
+	}
+
+	err = w.dropTail(ctx, scan.end)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for i := range scan.corrupted {
+		scan.corrupted[i].File = w.filename
+	}
+
+	return scan.maxID, scan.corrupted, nil
+}
+
+// dropTail truncates the log to end, where its last complete entry ends.
+func (w *wal) dropTail(ctx context.Context, end int64) error {
+	size := fileSize(w.filename)
+	if end >= size {
+		return nil
+	}
+
+	slog.WarnContext(ctx, "dropping torn log entry", "file", w.filename, "bytes", size-end)
+
+	err := os.Truncate(w.filename, end)
+	if err != nil {
+		return fmt.Errorf("truncating log %q: %w", w.filename, ErrTruncateLog)
+	}
+
+	return nil
+}
+
+// append writes the entry to the end of the log, opening the log if needed.
+func (w *wal) append(entry Entry) error {
+	if w.file == nil {
+		file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening log %q: %w", w.filename, errOpenFile)
+		}
+
+		w.file = file
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshaling log entry: %w", err)
+	}
+
+	line := encodeLine(data)
+	if len(line) > MaxLine {
+		return fmt.Errorf("appending %d bytes to log %q: %w: %w", len(line), w.filename, ErrWriteLog, errLineTooLong)
+	}
+
+	n, err := w.file.Write(line)
+	w.written += int64(n)
+
+	if err != nil {
+		return fmt.Errorf("appending to log %q: %w", w.filename, ErrWriteLog)
+	}
+
+	if w.sync == SyncAlways {
+		err = w.file.Sync()
+		if err != nil {
+			return fmt.Errorf("syncing log %q: %w", w.filename, ErrSyncLog)
+		}
+	}
+
+	return nil
+}
+
+// truncate discards all entries, once they are covered by a snapshot.
+func (w *wal) truncate() error {
+	if w.file == nil {
+		err := os.Truncate(w.filename, 0)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("truncating log %q: %w", w.filename, ErrTruncateLog)
+		}
+
+		w.written = 0
+
+		return nil
+	}
+
+	err := w.file.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("truncating log %q: %w", w.filename, ErrTruncateLog)
+	}
+
+	w.written = 0
+
+	err = w.file.Sync()
+	if err != nil {
+		return fmt.Errorf("syncing log %q: %w", w.filename, ErrSyncLog)
+	}
+
+	return nil
+}
+
+// discard drops the first n bytes of the log, which a snapshot covers, and
+// keeps the entries appended after them. The rest of the log is rewritten
+// atomically, so a crash leaves either the whole log or its rest behind.
+func (w *wal) discard(ctx context.Context, n int64) error {
+	if n == 0 {
+		return nil
+	}
+
+	size := fileSize(w.filename)
+	if n >= size {
+		return w.truncate()
+	}
+
+	file, err := os.Open(w.filename)
+	if err != nil {
+		return fmt.Errorf("opening log %q: %w", w.filename, errOpenFile)
+	}
+
+	defer func() {
+		closeErr := file.Close()
+		if closeErr != nil {
+			slog.WarnContext(ctx, "failed to close log", "file", w.filename, "err", closeErr)
+		}
+	}()
+
+	rest := io.NewSectionReader(file, n, size-n)
+
+	err = writeAtomic(ctx, w.filename, func(tmp *os.File) error {
+		_, err := io.Copy(tmp, rest)
+		if err != nil {
+			return fmt.Errorf("copying log %q: %w", w.filename, ErrWriteLog)
+		}
+
+		err = tmp.Sync()
+		if err != nil {
+			return fmt.Errorf("syncing log %q: %w", w.filename, ErrSyncLog)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("truncating log %q: %w: %w", w.filename, ErrTruncateLog, err)
+	}
+
+	// The open file is the replaced one; the next append opens the new log.
+	err = w.close()
+	if err != nil {
+		slog.WarnContext(ctx, "failed to close replaced log", "file", w.filename, "err", err)
+	}
+
+	w.written = size - n
+
+	return nil
+}
+
+// close closes the log file if it is open.
+func (w *wal) close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	if err != nil {
+		return fmt.Errorf("closing log %q: %w", w.filename, err)
+	}
+
+	return nil
+}
+
+// replayFromReader applies log entries read from r to records and returns the
+// highest record id they refer to. Entries that cannot be decoded, such as a
+// torn last write, are skipped.
+func replayFromReader(ctx context.Context, r io.Reader, records map[uint64]userrecord.Record) (uint64, error) {
+	scan, err := scanEntries(ctx, r, func(entry Entry) error {
+		return applyEntry(records, entry)
+	})
+
+	return scan.maxID, err
+}
+
+// logScan is what scanEntries found in a log.
+type logScan struct {
+	// maxID is the highest record id of the entries accepted.
+	maxID uint64
+	// end is the offset at which the last complete entry ends.
+	end int64
+	// corrupted lists the entries that could not be decoded.
+	corrupted []CorruptEntry
+}
+
+// scanEntries passes the log entries read from r to apply. Entries that cannot
+// be decoded are skipped and listed, and entries that apply rejects are
+// skipped. So is a last line without a newline, which was torn by a crash
+// before it was written completely. Lines without a checksum are read as
+// plain JSON, as written by earlier versions.
+func scanEntries(ctx context.Context, r io.Reader, apply func(Entry) error) (logScan, error) {
+	var (
+		scan logScan
+		line int
+	)
+
+	scanner := newLineScanner(r)
+	scanner.Split(scanCompleteLines)
+
+	for scanner.Scan() {
+		err := ctx.Err()
+		if err != nil {
+			return scan, fmt.Errorf("replaying log: %w", err)
+		}
+
+		line++
+		data := scanner.Bytes()
+		scan.end += int64(len(data)) + 1
+
+		if !bytes.HasPrefix(data, []byte("{")) {
+			data, err = verifyLine(data)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to verify a log entry", "line", line, "err", err)
+
+				scan.corrupted = append(scan.corrupted, CorruptEntry{Line: line, Reason: err.Error()})
+
+				continue
+			}
+		}
+
+		var entry Entry
+
+		err = json.Unmarshal(data, &entry)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to unmarshal a log entry", "line", line, "err", err)
+
+			scan.corrupted = append(scan.corrupted, CorruptEntry{Line: line, Reason: "undecodable entry"})
+
+			continue
+		}
+
+		err = apply(entry)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to apply a log entry", "line", line, "err", err)
+
+			continue
+		}
+
+		scan.maxID = max(scan.maxID, entry.maxID())
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return scan, fmt.Errorf("scanning log: %w", scanError(err))
+	}
+
+	return scan, nil
+}
+
+// scanCompleteLines is a bufio.SplitFunc returning the lines that end with a
+// newline, without it, and leaving out the rest of the data at the end.
+func scanCompleteLines(data []byte, _ bool) (int, []byte, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return 0, nil, nil
+	}
+
+	return i + 1, data[:i], nil
+}
+
+// applyEntry applies a single log entry to records. The entries of a batch
+// are all checked before any of them is applied.
+func applyEntry(records map[uint64]userrecord.Record, entry Entry) error {
+	switch entry.Op {
+	case OpBatch:
+		for _, nested := range entry.Entries {
+			err := checkEntry(nested)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, nested := range entry.Entries {
+			_ = applyEntry(records, nested)
+		}
+	case OpAdd, OpUpdate:
+		err := checkEntry(entry)
+		if err != nil {
+			return err
+		}
+
+		records[entry.ID] = entry.Record
+	case OpDelete:
+		delete(records, entry.ID)
+	default:
+		return fmt.Errorf("operation %q: %w", entry.Op, errUnknownOp)
+	}
+
+	return nil
+}
+
+// checkEntry reports whether a single, non-batch entry can be applied.
+func checkEntry(entry Entry) error {
+	switch entry.Op {
+	case OpAdd, OpUpdate:
+		err := entry.Record.ValidateID()
+		if err != nil {
+			return fmt.Errorf("validating record with id %d: %w", entry.ID, err)
+		}
+	case OpDelete:
+	default:
+		return fmt.Errorf("operation %q: %w", entry.Op, errUnknownOp)
+	}
+
+	return nil
+}
@@ -0,0 +1,392 @@
+package userrecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned when a patch is malformed or cannot be applied to the record.
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrPatchTestFailed is returned when a JSON Patch test operation does not match the record.
+	ErrPatchTestFailed = errors.New("patch test failed")
+)
+
+// Patch describes a partial modification of a record.
+type Patch interface {
+	// Apply returns a patched copy of r, leaving r itself unchanged.
+	Apply(r Record) (Record, error)
+}
+
+// MergePatch is an RFC 7396 JSON merge patch.
+type MergePatch map[string]any
+
+// JSONPatch is an RFC 6902 JSON patch.
+type JSONPatch []PatchOperation
+
+// PatchOperation is a single operation of a JSON patch.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply merges the patch into a copy of r: null members are removed,
+// objects are merged recursively and any other value replaces the old one.
+func (p MergePatch) Apply(r Record) (Record, error) {
+	patched, ok := mergeValue(deepCopy(map[string]any(r)), map[string]any(p)).(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("merge patch must be an object: %w", ErrInvalidPatch)
+	}
+
+	return patched, nil
+}
+
+// Apply runs the operations in order on a copy of r.
+// Either all operations succeed or r is left as it was.
+func (p JSONPatch) Apply(r Record) (Record, error) {
+	var doc any = deepCopy(map[string]any(r))
+
+	for i, op := range p {
+		var err error
+
+		doc, err = op.apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %q): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	patched, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("patched document must be an object: %w", ErrInvalidPatch)
+	}
+
+	return patched, nil
+}
+
+// apply runs a single operation on doc and returns the updated document.
+func (op PatchOperation) apply(doc any) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		value, valueErr := op.value()
+		if valueErr != nil {
+			return nil, valueErr
+		}
+
+		switch op.Op {
+		case "add":
+			return addValue(doc, path, value)
+		case "replace":
+			return replaceValue(doc, path, value)
+		default:
+			return doc, testValue(doc, path, value)
+		}
+	case "remove":
+		doc, _, err = removeValue(doc, path)
+
+		return doc, err
+	case "move", "copy":
+		return op.transfer(doc, path)
+	default:
+		return nil, fmt.Errorf("unknown operation %q: %w", op.Op, ErrInvalidPatch)
+	}
+}
+
+// transfer implements move and copy, which add the value found at From to path.
+func (op PatchOperation) transfer(doc any, path []string) (any, error) {
+	from, err := parsePointer(op.From)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+
+	if op.Op == "copy" {
+		value, err = getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		return addValue(doc, path, deepCopy(value))
+	}
+
+	if strings.HasPrefix(op.Path, op.From+"/") {
+		return nil, fmt.Errorf("cannot move %q into its own child: %w", op.From, ErrInvalidPatch)
+	}
+
+	doc, value, err = removeValue(doc, from)
+	if err != nil {
+		return nil, err
+	}
+
+	return addValue(doc, path, value)
+}
+
+// value decodes the operation value; a missing value is an error.
+func (op PatchOperation) value() (any, error) {
+	if op.Value == nil {
+		return nil, fmt.Errorf("missing value: %w", ErrInvalidPatch)
+	}
+
+	var value any
+
+	err := json.Unmarshal(op.Value, &value)
+	if err != nil {
+		return nil, fmt.Errorf("decoding value: %w", ErrInvalidPatch)
+	}
+
+	return value, nil
+}
+
+// mergeValue applies an RFC 7396 merge patch to target and returns the result.
+func mergeValue(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any, len(patchObject))
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+
+			continue
+		}
+
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("pointer %q must start with '/': %w", pointer, ErrInvalidPatch)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// getValue returns the value found at path.
+func getValue(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found: %w", token, ErrInvalidPatch)
+			}
+
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into %q: %w", token, ErrInvalidPatch)
+		}
+	}
+
+	return doc, nil
+}
+
+// addValue inserts value at path, replacing an existing object member or
+// shifting array elements to the right.
+func addValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return updateParent(doc, path, func(parent any, key string) (any, error) {
+		switch container := parent.(type) {
+		case map[string]any:
+			container[key] = value
+
+			return container, nil
+		case []any:
+			if key == "-" {
+				return append(container, value), nil
+			}
+
+			i, err := arrayIndex(key, len(container))
+			if err != nil {
+				return nil, err
+			}
+
+			container = append(container, nil)
+			copy(container[i+1:], container[i:])
+			container[i] = value
+
+			return container, nil
+		default:
+			return nil, fmt.Errorf("cannot add %q to a scalar: %w", key, ErrInvalidPatch)
+		}
+	})
+}
+
+// replaceValue replaces the existing value at path.
+func replaceValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	doc, _, err := removeValue(doc, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return addValue(doc, path, value)
+}
+
+// removeValue removes the value at path and returns the updated document along with it.
+func removeValue(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole record: %w", ErrInvalidPatch)
+	}
+
+	var removed any
+
+	doc, err := updateParent(doc, path, func(parent any, key string) (any, error) {
+		switch container := parent.(type) {
+		case map[string]any:
+			value, ok := container[key]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found: %w", key, ErrInvalidPatch)
+			}
+
+			removed = value
+			delete(container, key)
+
+			return container, nil
+		case []any:
+			i, err := arrayIndex(key, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+
+			removed = container[i]
+
+			return append(container[:i], container[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %q from a scalar: %w", key, ErrInvalidPatch)
+		}
+	})
+
+	return doc, removed, err
+}
+
+// testValue checks that the value at path equals value.
+func testValue(doc any, path []string, value any) error {
+	actual, err := getValue(doc, path)
+	if err != nil {
+		return err
+	}
+
+	actualJSON, err := json.Marshal(actual)
+	if err != nil {
+		return fmt.Errorf("encoding value: %w", ErrInvalidPatch)
+	}
+
+	expectedJSON, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding value: %w", ErrInvalidPatch)
+	}
+
+	if !bytes.Equal(actualJSON, expectedJSON) {
+		return fmt.Errorf("value is %s, not %s: %w", actualJSON, expectedJSON, ErrPatchTestFailed)
+	}
+
+	return nil
+}
+
+// updateParent walks to the container holding the last token of path and lets
+// fn modify it. Containers along the way are replaced by fn's results, as
+// appending to or removing from an array may produce a new slice.
+func updateParent(doc any, path []string, fn func(parent any, key string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := getValue(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = updateParent(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch container := doc.(type) {
+	case map[string]any:
+		container[path[0]] = child
+	case []any:
+		i, _ := arrayIndex(path[0], len(container)-1)
+		container[i] = child
+	}
+
+	return doc, nil
+}
+
+// arrayIndex parses an array index token, which must not exceed maxIndex.
+func arrayIndex(token string, maxIndex int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q: %w", token, ErrInvalidPatch)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > maxIndex {
+		return 0, fmt.Errorf("array index %q out of range: %w", token, ErrInvalidPatch)
+	}
+
+	return i, nil
+}
+
+// deepCopy copies objects and arrays so that patching never modifies a stored record.
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(v))
+		for key, value := range v {
+			copied[key] = deepCopy(value)
+		}
+
+		return copied
+	case []any:
+		copied := make([]any, len(v))
+		for i, value := range v {
+			copied[i] = deepCopy(value)
+		}
+
+		return copied
+	default:
+		return v
+	}
+}
@@ -0,0 +1,168 @@
+package userrecord
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMergePatchApply(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		record   string
+		patch    string
+		expected string
+	}{
+		{"set field", `{"id":1,"a":"b"}`, `{"a":"c"}`, `{"a":"c","id":1}`},
+		{"add field", `{"id":1}`, `{"b":"c"}`, `{"b":"c","id":1}`},
+		{"remove field", `{"id":1,"a":"b"}`, `{"a":null}`, `{"id":1}`},
+		{"replace array", `{"id":1,"a":["b"]}`, `{"a":["c","d"]}`, `{"a":["c","d"],"id":1}`},
+		{"merge object", `{"id":1,"a":{"b":"c","d":"e"}}`, `{"a":{"b":"x","d":null}}`, `{"a":{"b":"x"},"id":1}`},
+		{"object over scalar", `{"id":1,"a":"b"}`, `{"a":{"c":"d","e":null}}`, `{"a":{"c":"d"},"id":1}`},
+		{"empty patch", `{"id":1,"a":"b"}`, `{}`, `{"a":"b","id":1}`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				record Record
+				patch  MergePatch
+			)
+
+			mustUnmarshal(t, c.record, &record)
+			mustUnmarshal(t, c.patch, &patch)
+
+			original := mustMarshal(t, record)
+
+			patched, err := patch.Apply(record)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := mustMarshal(t, patched); got != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, got)
+			}
+
+			if got := mustMarshal(t, record); got != original {
+				t.Errorf("expected original record to stay %s, got %s", original, got)
+			}
+		})
+	}
+}
+
+func TestJSONPatchApply(t *testing.T) {
+	t.Parallel()
+
+	const record = `{"a/b":1,"address":{"city":"Riga"},"id":1,"likes":["apples","bananas"],"name":"Alice"}`
+
+	cases := []struct {
+		name     string
+		patch    string
+		expected string
+		err      error
+	}{
+		{
+			"add member",
+			`[{"op":"add","path":"/age","value":30}]`,
+			`{"a/b":1,"address":{"city":"Riga"},"age":30,"id":1,"likes":["apples","bananas"],"name":"Alice"}`,
+			nil,
+		},
+		{
+			"add to array",
+			`[{"op":"add","path":"/likes/1","value":"pears"},{"op":"add","path":"/likes/-","value":"kiwis"}]`,
+			`{"a/b":1,"address":{"city":"Riga"},"id":1,"likes":["apples","pears","bananas","kiwis"],"name":"Alice"}`,
+			nil,
+		},
+		{
+			"remove nested and escaped",
+			`[{"op":"remove","path":"/address/city"},{"op":"remove","path":"/a~1b"}]`,
+			`{"address":{},"id":1,"likes":["apples","bananas"],"name":"Alice"}`,
+			nil,
+		},
+		{
+			"replace array element",
+			`[{"op":"replace","path":"/likes/0","value":"pears"}]`,
+			`{"a/b":1,"address":{"city":"Riga"},"id":1,"likes":["pears","bananas"],"name":"Alice"}`,
+			nil,
+		},
+		{
+			"move and copy",
+			`[{"op":"move","from":"/address/city","path":"/city"},{"op":"copy","from":"/likes","path":"/loves"}]`,
+			`{"a/b":1,"address":{},"city":"Riga","id":1,"likes":["apples","bananas"],` +
+				`"loves":["apples","bananas"],"name":"Alice"}`,
+			nil,
+		},
+		{
+			"passing test",
+			`[{"op":"test","path":"/id","value":1},{"op":"test","path":"/likes","value":["apples","bananas"]}]`,
+			record,
+			nil,
+		},
+		{"failing test", `[{"op":"test","path":"/name","value":"Bob"}]`, "", ErrPatchTestFailed},
+		{"replace missing member", `[{"op":"replace","path":"/age","value":30}]`, "", ErrInvalidPatch},
+		{"remove out of range", `[{"op":"remove","path":"/likes/2"}]`, "", ErrInvalidPatch},
+		{"leading zero index", `[{"op":"remove","path":"/likes/01"}]`, "", ErrInvalidPatch},
+		{"missing value", `[{"op":"add","path":"/age"}]`, "", ErrInvalidPatch},
+		{"unknown operation", `[{"op":"rename","path":"/name"}]`, "", ErrInvalidPatch},
+		{"invalid pointer", `[{"op":"remove","path":"name"}]`, "", ErrInvalidPatch},
+		{"move into child", `[{"op":"move","from":"/address","path":"/address/old"}]`, "", ErrInvalidPatch},
+		{"replace root with array", `[{"op":"replace","path":"","value":[]}]`, "", ErrInvalidPatch},
+		{
+			"atomic failure",
+			`[{"op":"remove","path":"/name"},{"op":"test","path":"/name","value":"Alice"}]`,
+			"",
+			ErrInvalidPatch,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				rec   Record
+				patch JSONPatch
+			)
+
+			mustUnmarshal(t, record, &rec)
+			mustUnmarshal(t, c.patch, &patch)
+
+			patched, err := patch.Apply(rec)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expected error %v, got %v", c.err, err)
+			}
+
+			if c.err == nil && mustMarshal(t, patched) != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, mustMarshal(t, patched))
+			}
+
+			if got := mustMarshal(t, rec); got != record {
+				t.Errorf("expected original record to stay unchanged, got %s", got)
+			}
+		})
+	}
+}
+
+func mustUnmarshal(t *testing.T, data string, v any) {
+	t.Helper()
+
+	err := json.Unmarshal([]byte(data), v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func mustMarshal(t *testing.T, v any) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return string(data)
+}
@@ -1,22 +1,49 @@
 package userrecord
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
 
 var (
-	errNoID        = errors.New("missing id")
-	errIDNotNumber = errors.New("id must be a number")
-	errIDNotUint   = errors.New("'id' must be a non-negative integer")
-	errID          = errors.New("id is not exist or is not a uint64")
+	// ErrNoID is returned for records without an id.
+	ErrNoID = errors.New("missing id")
+	// ErrInvalidID is returned for ids that are not non-negative integers.
+	ErrInvalidID = errors.New("invalid id")
+
+	errIDNotNumber = fmt.Errorf("%w: must be a number", ErrInvalidID)
+	errIDNotUint   = fmt.Errorf("%w: must be a non-negative integer", ErrInvalidID)
+	errID          = fmt.Errorf("%w: id is not exist or is not a uint64", ErrInvalidID)
 )
 
 // Record represents a generic record with dynamic fields.
 type Record map[string]any // interface{}
 
-// Validate validates the record to ensure it has a valid 'id' field.
+// Validate validates the record to ensure it has a valid 'id' field and
+// matches the schema set by SetSchema, if any.
 func (r Record) Validate() error {
+	err := r.ValidateID()
+	if err != nil {
+		return err
+	}
+
+	return r.ValidateSchema()
+}
+
+// ValidateID validates only the 'id' field of the record.
+// A JSON number id is converted to uint64, and an id that already is one is kept.
+func (r Record) ValidateID() error {
 	id, ok := r["id"]
 	if !ok {
-		return errNoID
+		return ErrNoID
+	}
+
+	if _, ok = id.(uint64); ok {
+		return nil
 	}
 
 	idFloat, ok := id.(float64)
@@ -42,3 +69,72 @@ func (r Record) ID() (uint64, error) {
 
 	return id, nil
 }
+
+// Matches reports whether the field equals value, or for array fields whether
+// any element equals it. Values are compared in their JSON text form, so the
+// number 30 matches "30" and true matches "true".
+func (r Record) Matches(field, value string) bool {
+	return slices.Contains(r.FieldValues(field), value)
+}
+
+// FieldValues returns the JSON text forms compared by Matches for the field:
+// its value, or each scalar element if it is an array. Objects have no text form.
+func (r Record) FieldValues(field string) []string {
+	fieldValue, ok := r[field]
+	if !ok {
+		return nil
+	}
+
+	items, ok := fieldValue.([]any)
+	if !ok {
+		items = []any{fieldValue}
+	}
+
+	values := make([]string, 0, len(items))
+
+	for _, item := range items {
+		if text, ok := scalarString(item); ok {
+			values = append(values, text)
+		}
+	}
+
+	return values
+}
+
+// scalarString returns the JSON text form of a scalar value, without quotes
+// for strings. It returns false for objects and arrays.
+func scalarString(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case int:
+		return strconv.Itoa(v), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "null", true
+	default:
+		return "", false
+	}
+}
+
+// Version returns a hash of the record's JSON encoding. Two records have the
+// same version exactly when they have the same content, so the version can be
+// derived again after a restart without being stored. A record that is changed
+// and changed back gets its old version again; conditional writes accept that,
+// since they are then based on the record's current content.
+func (r Record) Version() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("encoding record: %w", err)
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+
+	return strconv.FormatUint(h.Sum64(), 16), nil
+}
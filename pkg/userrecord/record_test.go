@@ -2,6 +2,7 @@ package userrecord
 
 import (
 	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -28,10 +29,15 @@ func TestValidate(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			name:   "already converted id",
+			record: Record{"id": uint64(123)},
+			err:    nil,
+		},
 		{
 			name:   "missing id",
 			record: Record{"name": "test"},
-			err:    errNoID,
+			err:    ErrNoID,
 		},
 		{
 			name:   "id not a number",
@@ -102,3 +108,108 @@ func TestID(t *testing.T) {
 		})
 	}
 }
+
+func TestMatches(t *testing.T) {
+	t.Parallel()
+
+	record := Record{
+		"id":     uint64(7),
+		"name":   "Alice",
+		"age":    30.0,
+		"admin":  true,
+		"email":  nil,
+		"likes":  []any{"apples", 3.5},
+		"owner":  map[string]any{"name": "Alice"},
+		"nested": []any{[]any{"apples"}},
+	}
+
+	cases := []struct {
+		field string
+		value string
+		want  bool
+	}{
+		{"id", "7", true},
+		{"name", "Alice", true},
+		{"name", "alice", false},
+		{"age", "30", true},
+		{"age", "30.0", false},
+		{"admin", "true", true},
+		{"email", "null", true},
+		{"likes", "apples", true},
+		{"likes", "3.5", true},
+		{"likes", "bananas", false},
+		{"owner", "Alice", false},
+		{"nested", "apples", false},
+		{"missing", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.field+"="+c.value, func(t *testing.T) {
+			t.Parallel()
+
+			if got := record.Matches(c.field, c.value); got != c.want {
+				t.Errorf("expected %v, got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestFieldValues(t *testing.T) {
+	t.Parallel()
+
+	record := Record{
+		"name":  "Alice",
+		"likes": []any{"apples", 3.5, map[string]any{}},
+		"owner": map[string]any{"name": "Alice"},
+	}
+
+	cases := []struct {
+		field string
+		want  []string
+	}{
+		{"name", []string{"Alice"}},
+		{"likes", []string{"apples", "3.5"}},
+		{"owner", []string{}},
+		{"missing", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.field, func(t *testing.T) {
+			t.Parallel()
+
+			if got := record.FieldValues(c.field); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("expected %v, got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	t.Parallel()
+
+	version := func(r Record) string {
+		t.Helper()
+
+		v, err := r.Version()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return v
+	}
+
+	original := version(Record{"id": uint64(1), "name": "Alice", "likes": []any{"apples"}})
+
+	if got := version(Record{"likes": []any{"apples"}, "name": "Alice", "id": 1.0}); got != original {
+		t.Errorf("expected equal content to have version %s, got %s", original, got)
+	}
+
+	if got := version(Record{"id": uint64(1), "name": "Alicia", "likes": []any{"apples"}}); got == original {
+		t.Errorf("expected changed content to have a new version, got %s", got)
+	}
+
+	_, err := Record{"id": uint64(1), "bad": func() {}}.Version()
+	if err == nil {
+		t.Error("expected error for unencodable record, got nil")
+	}
+}
@@ -0,0 +1,341 @@
+package userrecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+var (
+	// ErrSchemaViolation is wrapped by every SchemaError.
+	ErrSchemaViolation = errors.New("record does not match schema")
+
+	errInvalidSchema = errors.New("invalid schema")
+
+	// schema is the schema enforced by Validate, nil if records are not checked.
+	// It is shared by all records of the process, whatever collection holds them.
+	schema atomic.Pointer[Schema]
+)
+
+// Schema is the subset of a JSON Schema (draft 2020-12) enforced on records:
+// type, required, properties, enum, minimum, maximum, minLength, maxLength,
+// minItems, maxItems, pattern and items. Other keywords are ignored.
+// Patterns use Go regular expression syntax.
+//
+//nolint:tagliatelle // JSON Schema keywords are camelCase.
+type Schema struct {
+	Type       schemaTypes        `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*Schema `json:"properties"`
+	Enum       []any              `json:"enum"`
+	Minimum    *float64           `json:"minimum"`
+	Maximum    *float64           `json:"maximum"`
+	MinLength  *int               `json:"minLength"`
+	MaxLength  *int               `json:"maxLength"`
+	MinItems   *int               `json:"minItems"`
+	MaxItems   *int               `json:"maxItems"`
+	Pattern    string             `json:"pattern"`
+	Items      *Schema            `json:"items"`
+
+	pattern *regexp.Regexp
+}
+
+// Violation describes one way in which a record does not match the schema.
+type Violation struct {
+	// Path is the JSON pointer to the offending value, empty for the record itself.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// SchemaError lists all schema violations of a record.
+type SchemaError struct {
+	Violations []Violation
+}
+
+// schemaTypes is the value of the type keyword, which may be a single name or a list.
+type schemaTypes []string
+
+// LoadSchema reads and compiles a schema from a JSON file.
+func LoadSchema(filename string) (*Schema, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema %q: %w", filename, err)
+	}
+
+	s, err := ParseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("loading schema %q: %w", filename, err)
+	}
+
+	return s, nil
+}
+
+// ParseSchema decodes and compiles a JSON schema.
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, fmt.Errorf("decoding schema: %w: %w", errInvalidSchema, err)
+	}
+
+	err = s.compile("")
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// SetSchema sets the schema enforced by Validate. It is global: it applies to
+// every record of the process, so all collections share it. A nil schema
+// disables the check.
+func SetSchema(s *Schema) {
+	schema.Store(s)
+}
+
+// ValidateSchema checks the record against the schema set by SetSchema
+// and returns a *SchemaError listing every violation.
+func (r Record) ValidateSchema() error {
+	s := schema.Load()
+	if s == nil {
+		return nil
+	}
+
+	var violations []Violation
+
+	s.validate("", map[string]any(r), &violations)
+
+	if len(violations) > 0 {
+		return &SchemaError{Violations: violations}
+	}
+
+	return nil
+}
+
+// Error implements the error interface.
+func (e *SchemaError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, strconv.Quote(v.Path)+" "+v.Message)
+	}
+
+	return ErrSchemaViolation.Error() + ": " + strings.Join(messages, "; ")
+}
+
+// Unwrap makes SchemaError match ErrSchemaViolation.
+func (e *SchemaError) Unwrap() error {
+	return ErrSchemaViolation
+}
+
+// UnmarshalJSON accepts both a single type name and a list of names.
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+
+	if json.Unmarshal(data, &name) == nil {
+		*t = schemaTypes{name}
+
+		return nil
+	}
+
+	var names []string
+
+	err := json.Unmarshal(data, &names)
+	if err != nil {
+		return fmt.Errorf("type must be a string or an array of strings: %w", err)
+	}
+
+	*t = names
+
+	return nil
+}
+
+// compile checks the keywords and compiles patterns, recursively.
+func (s *Schema) compile(path string) error {
+	for _, name := range s.Type {
+		switch name {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("%q: unknown type %q: %w", path, name, errInvalidSchema)
+		}
+	}
+
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%q: pattern: %w: %w", path, errInvalidSchema, err)
+		}
+
+		s.pattern = re
+	}
+
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%q: property %q has no schema: %w", path, name, errInvalidSchema)
+		}
+
+		err := property.compile(path + "/properties/" + name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.compile(path + "/items")
+	}
+
+	return nil
+}
+
+// validate appends the violations of value, found at path, to violations.
+func (s *Schema) validate(path string, value any, violations *[]Violation) {
+	report := func(format string, args ...any) {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(name string) bool { return hasType(value, name) }) {
+		report("must be of type %s", strings.Join(s.Type, " or "))
+
+		return
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(allowed any) bool { return jsonEqual(value, allowed) }) {
+		report("must be one of the enumerated values")
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		s.validateObject(path, value, violations)
+	case []any:
+		s.validateArray(path, value, violations)
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.MinLength != nil && length < *s.MinLength {
+			report("must be at least %d characters long", *s.MinLength)
+		}
+
+		if s.MaxLength != nil && length > *s.MaxLength {
+			report("must be at most %d characters long", *s.MaxLength)
+		}
+
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			report("must match pattern %q", s.Pattern)
+		}
+	default:
+		n, ok := toFloat(value)
+		if !ok {
+			return
+		}
+
+		if s.Minimum != nil && n < *s.Minimum {
+			report("must be >= %v", *s.Minimum)
+		}
+
+		if s.Maximum != nil && n > *s.Maximum {
+			report("must be <= %v", *s.Maximum)
+		}
+	}
+}
+
+// validateObject checks the required and properties keywords.
+func (s *Schema) validateObject(path string, object map[string]any, violations *[]Violation) {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			*violations = append(*violations, Violation{
+				Path:    path + "/" + escapePointer(name),
+				Message: "is required",
+			})
+		}
+	}
+
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		if value, ok := object[name]; ok {
+			s.Properties[name].validate(path+"/"+escapePointer(name), value, violations)
+		}
+	}
+}
+
+// validateArray checks the minItems, maxItems and items keywords.
+func (s *Schema) validateArray(path string, array []any, violations *[]Violation) {
+	if s.MinItems != nil && len(array) < *s.MinItems {
+		message := fmt.Sprintf("must have at least %d items", *s.MinItems)
+		*violations = append(*violations, Violation{Path: path, Message: message})
+	}
+
+	if s.MaxItems != nil && len(array) > *s.MaxItems {
+		message := fmt.Sprintf("must have at most %d items", *s.MaxItems)
+		*violations = append(*violations, Violation{Path: path, Message: message})
+	}
+
+	if s.Items != nil {
+		for i, item := range array {
+			s.Items.validate(path+"/"+strconv.Itoa(i), item, violations)
+		}
+	}
+}
+
+// hasType reports whether value is an instance of the named JSON type.
+func hasType(value any, name string) bool {
+	switch value.(type) {
+	case map[string]any, Record:
+		return name == "object"
+	case []any:
+		return name == "array"
+	case string:
+		return name == "string"
+	case bool:
+		return name == "boolean"
+	case nil:
+		return name == "null"
+	}
+
+	n, ok := toFloat(value)
+	if !ok {
+		return false
+	}
+
+	return name == "number" || (name == "integer" && n == math.Trunc(n))
+}
+
+// toFloat converts the numeric types found in records to float64.
+func toFloat(value any) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case uint64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// jsonEqual reports whether two values have the same JSON encoding.
+func jsonEqual(a, b any) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// escapePointer escapes a member name for use in a JSON pointer.
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,148 @@
+package userrecord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["id", "name"],
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"name": {"type": "string", "minLength": 2, "maxLength": 5, "pattern": "^[a-z]+$"},
+		"age": {"type": ["integer", "null"], "minimum": 0, "maximum": 150},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"a/b": {"type": "boolean"}
+	}
+}`
+
+func TestParseSchema(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		schema string
+		err    bool
+	}{
+		{name: "valid", schema: testSchema},
+		{name: "not json", schema: `{`, err: true},
+		{name: "unknown type", schema: `{"type": "date"}`, err: true},
+		{name: "bad type keyword", schema: `{"type": 1}`, err: true},
+		{name: "bad pattern", schema: `{"properties": {"a": {"pattern": "("}}}`, err: true},
+		{name: "bad items pattern", schema: `{"items": {"pattern": "["}}`, err: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseSchema([]byte(tc.schema))
+			if tc.err {
+				require.ErrorIs(t, err, errInvalidSchema)
+
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	t.Parallel()
+
+	s, err := ParseSchema([]byte(testSchema))
+	require.NoError(t, err)
+
+	cases := []struct {
+		name       string
+		record     Record
+		violations []Violation
+	}{
+		{
+			name:   "valid",
+			record: Record{"id": uint64(1), "name": "ann", "age": 30.0, "role": "admin", "tags": []any{"x"}},
+		},
+		{
+			name:   "null allowed by type list",
+			record: Record{"id": 1.0, "name": "ann", "age": nil},
+		},
+		{
+			name:       "missing required",
+			record:     Record{"id": 1.0},
+			violations: []Violation{{Path: "/name", Message: "is required"}},
+		},
+		{
+			name:   "wrong types",
+			record: Record{"id": 1.5, "name": 7.0, "a/b": "yes"},
+			violations: []Violation{
+				{Path: "/a~1b", Message: "must be of type boolean"},
+				{Path: "/id", Message: "must be of type integer"},
+				{Path: "/name", Message: "must be of type string"},
+			},
+		},
+		{
+			name:   "string constraints",
+			record: Record{"id": 1.0, "name": "ABCDEF"},
+			violations: []Violation{
+				{Path: "/name", Message: "must be at most 5 characters long"},
+				{Path: "/name", Message: `must match pattern "^[a-z]+$"`},
+			},
+		},
+		{
+			name:   "number range and enum",
+			record: Record{"id": 0.0, "name": "ann", "age": 151.0, "role": "root"},
+			violations: []Violation{
+				{Path: "/age", Message: "must be <= 150"},
+				{Path: "/id", Message: "must be >= 1"},
+				{Path: "/role", Message: "must be one of the enumerated values"},
+			},
+		},
+		{
+			name:   "array constraints",
+			record: Record{"id": 1.0, "name": "ann", "tags": []any{"x", 2.0, "z"}},
+			violations: []Violation{
+				{Path: "/tags", Message: "must have at most 2 items"},
+				{Path: "/tags/1", Message: "must be of type string"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var violations []Violation
+
+			s.validate("", map[string]any(tc.record), &violations)
+			assert.Equal(t, tc.violations, violations)
+		})
+	}
+}
+
+//nolint:paralleltest // SetSchema changes package state shared with other tests.
+func TestValidateWithSchema(t *testing.T) {
+	s, err := ParseSchema([]byte(testSchema))
+	require.NoError(t, err)
+
+	SetSchema(s)
+	defer SetSchema(nil)
+
+	require.NoError(t, Record{"id": 1.0, "name": "ann"}.Validate())
+
+	err = Record{"id": 1.0}.Validate()
+	require.ErrorIs(t, err, ErrSchemaViolation)
+
+	var schemaErr *SchemaError
+
+	require.True(t, errors.As(err, &schemaErr))
+	assert.Equal(t, []Violation{{Path: "/name", Message: "is required"}}, schemaErr.Violations)
+	assert.EqualError(t, err, `record does not match schema: "/name" is required`)
+
+	require.ErrorIs(t, Record{"name": "ann"}.Validate(), ErrNoID)
+}